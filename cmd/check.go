@@ -18,7 +18,9 @@ func newCheckCmd() *cobra.Command {
 	cmd.AddCommand(
 		check.NewRowConsistencyCmd(),
 		check.NewDistributionCmd(),
-		check.NewCheckRegionBoundaryCmd())
+		check.NewCheckRegionBoundaryCmd(),
+		check.NewCheckIndexCmd(),
+		check.NewCheckRegionChainCmd())
 
 	return cmd
 }
@@ -0,0 +1,267 @@
+package check
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/logger"
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/pd"
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/tidb"
+)
+
+// regionToRepair is an inconsistent Region and the table it belongs to
+type regionToRepair struct {
+	result *rowsCheckResult
+	region pd.Region
+}
+
+// applyRemovePeers removes the TiFlash peers of the inconsistent Regions through
+// PD, waits for PD adding the peers back, then checks the Regions again. The
+// Regions still inconsistent are repaired again, up to `opts.applyRounds` rounds.
+func applyRemovePeers(client *tidb.Client, opts checkRowsOpts, results []rowsCheckResult) error {
+	var targets []regionToRepair
+	for i := range results {
+		for _, region := range results[i].inconsistRegions {
+			targets = append(targets, regionToRepair{result: &results[i], region: region})
+		}
+	}
+	if len(targets) == 0 {
+		fmt.Fprintln(opts.out, "No inconsistent Region to repair")
+		return nil
+	}
+	pdClient, err := newPDClient(opts.tidb, client)
+	if err != nil {
+		return err
+	}
+
+	// The Regions with unhealthy TiFlash peers after repairing, they can not
+	// be checked until the peers catch up
+	var skipped []regionToRepair
+	for round := 1; len(targets) > 0 && round <= opts.applyRounds; round++ {
+		logger.Infof("Round %d, remove the TiFlash peers of %d inconsistent Regions", round, len(targets))
+		for _, t := range targets {
+			for _, storeID := range t.region.GetLearnerStoreIDs() {
+				fmt.Fprintf(opts.out, "operator add remove-peer %d %d\n", t.region.Id, storeID)
+			}
+		}
+		if !opts.assumeYes && !confirm("Submit these operators to PD?") {
+			fmt.Fprintln(opts.out, "Operators are not submitted")
+			return nil
+		}
+		if err = removeLearnerPeers(&pdClient, targets, opts.opTimeout); err != nil {
+			return err
+		}
+		if err = waitLearnerPeersAdded(&pdClient, targets, opts.opTimeout); err != nil {
+			return err
+		}
+		var unhealthy []regionToRepair
+		if targets, unhealthy, err = recheckRegions(opts.tidb, &pdClient, targets); err != nil {
+			return err
+		}
+		skipped = append(skipped, unhealthy...)
+	}
+
+	if len(skipped) > 0 {
+		fmt.Fprintf(opts.out, "\n%d Regions are skipped since their TiFlash peers are not healthy, check them again later:\n", len(skipped))
+		for _, t := range skipped {
+			fmt.Fprintf(opts.out, "Region %v of `%s`.`%s`\n", t.region, t.result.dbName, t.result.tableName)
+		}
+	}
+
+	if len(targets) > 0 {
+		fmt.Fprintf(opts.out, "\n%d Regions are still inconsistent after %d rounds:\n", len(targets), opts.applyRounds)
+		for _, t := range targets {
+			fmt.Fprintf(opts.out, "Region %v of `%s`.`%s`\n", t.region, t.result.dbName, t.result.tableName)
+		}
+		return fmt.Errorf("%d Regions are still inconsistent after %d rounds", len(targets), opts.applyRounds)
+	}
+	if len(skipped) > 0 {
+		fmt.Fprintln(opts.out, "\nThe other inconsistent Regions are repaired")
+		return nil
+	}
+	fmt.Fprintln(opts.out, "\nAll the inconsistent Regions are repaired")
+	return nil
+}
+
+// peerRemoval is the learner peers of a Region to remove. PD runs at most one
+// operator for a Region, so the peers are removed one by one.
+type peerRemoval struct {
+	regionID int64
+	stores   []int64
+	running  bool
+}
+
+// removeLearnerPeers removes all the learner peers of the Regions, and waits for
+// the operators to finish
+func removeLearnerPeers(pdClient *pd.Client, targets []regionToRepair, timeout time.Duration) error {
+	removals := make([]*peerRemoval, 0, len(targets))
+	for _, t := range targets {
+		removals = append(removals, &peerRemoval{regionID: t.region.Id, stores: t.region.GetLearnerStoreIDs()})
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		numPending := 0
+		for _, r := range removals {
+			if len(r.stores) == 0 {
+				continue
+			}
+			numPending++
+			if !r.running {
+				err := pdClient.AddRemovePeerOperator(r.regionID, r.stores[0])
+				if errors.Is(err, pd.ErrRegionNotFound) {
+					// It is checked again with the Region containing its start key
+					logger.Warnf("Region %d is not found, it could be merged, skip removing its peers", r.regionID)
+					r.stores = nil
+					continue
+				} else if err != nil {
+					return err
+				}
+				logger.Infof("operator add remove-peer %d %d => submitted", r.regionID, r.stores[0])
+				r.running = true
+				continue
+			}
+			status, err := pdClient.GetOperatorStatus(r.regionID)
+			if errors.Is(err, pd.ErrOperatorNotFound) {
+				// The record of finished operator could be expired, check
+				// whether the peer is removed by the Region
+				if status, err = getRemovePeerStatus(pdClient, r.regionID, r.stores[0]); err != nil {
+					return err
+				}
+			} else if err != nil {
+				return err
+			}
+			if !pd.IsOperatorFinished(status) {
+				continue
+			}
+			if status != pd.OperatorStatusSuccess {
+				return fmt.Errorf("operator add remove-peer %d %d is not success, status: %s", r.regionID, r.stores[0], status)
+			}
+			logger.Infof("operator add remove-peer %d %d => %s", r.regionID, r.stores[0], status)
+			r.stores, r.running = r.stores[1:], false
+		}
+		if numPending == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timeout waiting for %d Regions removing peers after %s", numPending, timeout)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// getRemovePeerStatus returns the status of removing the peer of the Region on
+// the store by checking the peers of the Region
+func getRemovePeerStatus(pdClient *pd.Client, regionID, storeID int64) (string, error) {
+	region, err := pdClient.GetRegionByID(regionID)
+	if errors.Is(err, pd.ErrRegionNotFound) {
+		// The Region is merged, so the peer is removed
+		return pd.OperatorStatusSuccess, nil
+	} else if err != nil {
+		return "", err
+	}
+	for _, p := range region.Peers {
+		if p.StoreId == storeID {
+			return pd.OperatorStatusRunning, nil
+		}
+	}
+	return pd.OperatorStatusSuccess, nil
+}
+
+// waitLearnerPeersAdded waits for PD adding back the learner peers of the
+// Regions and the peers catching up, so that the Regions can be read from
+// TiFlash again
+func waitLearnerPeersAdded(pdClient *pd.Client, targets []regionToRepair, timeout time.Duration) error {
+	logger.Infof("Waiting for the TiFlash peers to be added back")
+	deadline := time.Now().Add(timeout)
+	for _, t := range targets {
+		removed := make(map[int64]bool)
+		for _, p := range t.region.Peers {
+			if p.IsLearner() {
+				removed[p.Id] = true
+			}
+		}
+		key, err := tidb.FromPDKey(t.region.StartKey)
+		if err != nil {
+			return err
+		}
+		for {
+			region, err := pdClient.GetRegionByKey(key)
+			if errors.Is(err, pd.ErrRegionNotFound) {
+				// The Region could be changing, wait for it to be reported
+				region = pd.Region{}
+			} else if err != nil {
+				return err
+			}
+			// The new peers are pending or down before they catch up
+			numAdded := 0
+			for _, p := range region.Peers {
+				if p.IsLearner() && !removed[p.Id] && !region.IsPeerPending(p.Id) && !region.IsPeerDown(p.Id) {
+					numAdded++
+				}
+			}
+			if numAdded >= len(removed) {
+				logger.Infof("Region %v has %d TiFlash peers", region, numAdded)
+				break
+			}
+			if time.Now().After(deadline) {
+				return fmt.Errorf("timeout waiting for the TiFlash peers of Region %d to be added back after %s", t.region.Id, timeout)
+			}
+			time.Sleep(time.Second)
+		}
+	}
+	return nil
+}
+
+// recheckRegions checks the rows of the Regions again at the latest snapshot,
+// it returns the Regions that are still inconsistent, and the Regions skipped
+// since their TiFlash peers are not healthy
+func recheckRegions(tidbOpts tidb.TiDBClientOpts, pdClient *pd.Client, targets []regionToRepair) ([]regionToRepair, []regionToRepair, error) {
+	var tso uint64
+	client, err := newSnapshotClient(tidbOpts, &tso)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer client.Close()
+
+	ctx := context.Background()
+	var inconsist, skipped []regionToRepair
+	for _, t := range targets {
+		key, err := tidb.FromPDKey(t.region.StartKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		// The Region could be changed after removing peers, check with the latest one
+		region, err := pdClient.GetRegionByKey(key)
+		if errors.Is(err, pd.ErrRegionNotFound) {
+			return nil, nil, fmt.Errorf("no Region contains the start key of Region %d, run `check region-chain` to find the holes between Regions: %w", t.region.Id, err)
+		} else if err != nil {
+			return nil, nil, err
+		}
+		if reason := getSkipReason(&region); reason != "" {
+			fmt.Fprintf(t.result.opts.out, "Skip checking Region %v, %s\n", region, reason)
+			skipped = append(skipped, regionToRepair{result: t.result, region: region})
+			continue
+		}
+		queryRange, err := getCheckRangeFromRegion(&region, t.result.handle, t.result.table.ID)
+		if err != nil {
+			return nil, nil, err
+		}
+		conn, err := newCheckConn(ctx, client.Db)
+		if err != nil {
+			return nil, nil, err
+		}
+		logger.Debugf("The query range of Region %d is %s", region.Id, queryRange.String())
+		isConsist, err := haveConsistRows(t.result.opts.out, conn, t.result.opts.tableRef(), t.result.handle, t.result.opts.checksumExpr, queryRange, t.result.opts.numReplica)
+		conn.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		printRegionCheckResult(t.result.opts.out, region, isConsist)
+		if !isConsist {
+			inconsist = append(inconsist, regionToRepair{result: t.result, region: region})
+		}
+	}
+	return inconsist, skipped, nil
+}
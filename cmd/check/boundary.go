@@ -2,7 +2,13 @@ package check
 
 import (
 	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
 
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/format"
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/logger"
 	"github.com/JaySon-Huang/tiflash-ctl/pkg/options"
 	"github.com/JaySon-Huang/tiflash-ctl/pkg/pd"
 	"github.com/JaySon-Huang/tiflash-ctl/pkg/tidb"
@@ -15,6 +21,8 @@ func NewCheckRegionBoundaryCmd() *cobra.Command {
 		Use:   "boundary",
 		Short: "Check the boundary of Regions",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			opt.output = options.GetOutput(cmd)
+			opt.out = format.TextWriter(opt.output)
 			return checkBoundary(opt)
 		},
 	}
@@ -24,20 +32,31 @@ func NewCheckRegionBoundaryCmd() *cobra.Command {
 
 	c.Flags().StringVar(&opt.dbName, "database", "", "The database name of query table")
 	c.Flags().StringVar(&opt.tableName, "table", "", "The table name of query table")
+	c.Flags().StringVar(&opt.partitions, "partition", "", "The comma separated partition names to check (check all partitions by default)")
 
 	c.Flags().Int64Var(&opt.numPerBatch, "batch", 16, "The batch size for fetching Region info")
 	c.Flags().StringVar(&opt.mode, "cmd", "split", "'split' dump the split command, 'merge' dump the merge command")
+	c.Flags().BoolVar(&opt.fix, "fix", false, "Fix the invalid boundaries by splitting and merging the Regions through PD, instead of dumping the commands")
+	c.Flags().BoolVar(&opt.assumeYes, "yes", false, "Submit the operators in fix mode without confirmation")
+	c.Flags().DurationVar(&opt.opTimeout, "operator_timeout", 10*time.Minute, "The timeout of waiting for the operators to finish in fix mode")
 
 	return c
 }
 
 type checkRegionBoundaryOpts struct {
-	tidb      tidb.TiDBClientOpts
-	dbName    string
-	tableName string
+	tidb       tidb.TiDBClientOpts
+	dbName     string
+	tableName  string
+	partitions string
 
 	numPerBatch int64
 	mode        string
+	fix         bool
+	assumeYes   bool
+	opTimeout   time.Duration
+	output      string
+	// Where the results are printed as text, see format.TextWriter
+	out io.Writer
 }
 
 func checkBoundary(opts checkRegionBoundaryOpts) error {
@@ -47,27 +66,210 @@ func checkBoundary(opts checkRegionBoundaryOpts) error {
 	}
 	defer client.Close()
 
-	pdInstances, err := client.GetInstances("pd")
+	pdClient, err := newPDClient(opts.tidb, &client)
 	if err != nil {
 		return err
 	}
-	pdClient := pd.NewPDClient(pdInstances[0]) // FIXME: can not get instances
 
-	tableID, err := client.GetTableID(opts.dbName, opts.tableName)
+	tables, err := client.GetPhysicalTables(opts.dbName, opts.tableName)
 	if err != nil {
 		return err
 	}
+	if tables, err = tidb.FilterPartitions(tables, opts.partitions); err != nil {
+		return err
+	}
+
+	commonCols, err := client.GetCommonHandleColumns(opts.dbName, opts.tableName)
+	if err != nil {
+		return err
+	}
+	isCommonHandle := len(commonCols) > 0
+
+	report := &boundaryReport{Database: opts.dbName, Table: opts.tableName, Fixed: opts.fix}
+	for _, table := range tables {
+		if table.IsPartition() {
+			logger.Infof("Checking the Region boundary of %s", table.String())
+		}
+		var res boundaryScanResult
+		if opts.fix {
+			res, err = fixBoundaryOfTable(opts, &pdClient, table.ID, isCommonHandle)
+		} else {
+			res, err = checkBoundaryOfTable(opts, &pdClient, table.ID, isCommonHandle)
+		}
+		if err != nil {
+			return err
+		}
+		report.Tables = append(report.Tables, newBoundaryTableReport(opts, table, &res))
+	}
+	if format.IsStructured(opts.output) {
+		return format.Render(os.Stdout, opts.output, report)
+	}
+	if tables[0].IsPartition() {
+		fmt.Fprintln(opts.out)
+		if err = format.Render(os.Stdout, format.Text, report); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// boundaryReport is the result of `check boundary` for the structured output
+type boundaryReport struct {
+	Database string `json:"database"`
+	Table    string `json:"table"`
+	// Whether the invalid boundaries are fixed through PD
+	Fixed  bool                  `json:"fixed"`
+	Tables []boundaryTableReport `json:"tables"`
+}
+
+type boundaryTableReport struct {
+	Partition         string   `json:"partition,omitempty"`
+	TableID           int64    `json:"table_id"`
+	NumRegions        int      `json:"num_regions"`
+	InvalidRegions    []int64  `json:"invalid_regions"`
+	InvalidBoundaries []string `json:"invalid_boundaries"`
+	// The pd-ctl commands to fix the invalid boundaries in the mode of --cmd
+	Operators []string          `json:"operators"`
+	Unfixable []unfixableReport `json:"unfixable_boundaries,omitempty"`
+}
+
+type unfixableReport struct {
+	Key    string `json:"key"`
+	Reason string `json:"reason"`
+}
+
+func newBoundaryTableReport(opts checkRegionBoundaryOpts, table tidb.PhysicalTable, res *boundaryScanResult) boundaryTableReport {
+	r := boundaryTableReport{
+		Partition:         table.PartitionName,
+		TableID:           table.ID,
+		NumRegions:        len(res.regions),
+		InvalidRegions:    res.regionsToSplit(),
+		InvalidBoundaries: make([]string, 0, len(res.invalidBoundaries)),
+		Operators:         []string{},
+	}
+	for key := range res.invalidBoundaries {
+		r.InvalidBoundaries = append(r.InvalidBoundaries, key)
+	}
+	sort.Strings(r.InvalidBoundaries)
+	if opts.mode == "merge" {
+		chains, unfixable := pd.PlanMerges(res.regions, res.invalidBoundaries)
+		for _, chain := range chains {
+			for _, op := range mergeOperators(chain) {
+				r.Operators = append(r.Operators, op.desc)
+			}
+		}
+		for _, b := range unfixable {
+			r.Unfixable = append(r.Unfixable, unfixableReport{Key: b.Key, Reason: b.Reason})
+		}
+	} else {
+		for _, regionID := range r.InvalidRegions {
+			r.Operators = append(r.Operators, fmt.Sprintf("operator add split-region %d --policy=scan", regionID))
+		}
+	}
+	return r
+}
+
+func (r *boundaryReport) Header() []string {
+	return []string{"partition", "table id", "invalid regions", "total regions"}
+}
+
+func (r *boundaryReport) Rows() [][]string {
+	rows := make([][]string, 0, len(r.Tables))
+	for _, t := range r.Tables {
+		rows = append(rows, []string{t.Partition, fmt.Sprint(t.TableID), fmt.Sprint(len(t.InvalidRegions)), fmt.Sprint(t.NumRegions)})
+	}
+	return rows
+}
+
+// checkBoundaryOfTable checks the boundary of Regions in the table (or the
+// partition) with the table id, and dumps the pd-ctl commands to fix them.
+// It returns the result of scanning.
+func checkBoundaryOfTable(opts checkRegionBoundaryOpts, pdClient *pd.Client, tableID int64, isCommonHandle bool) (boundaryScanResult, error) {
+	res, err := scanBoundaryOfTable(opts, pdClient, tableID, isCommonHandle)
+	if err != nil {
+		return res, err
+	}
+
+	if opts.mode == "split" || opts.mode == "" {
+		fmt.Fprintf(opts.out, "\nRun these command through pd-ctl to split Regions with an exist key:\n")
+		for _, regionID := range res.regionsToSplit() {
+			fmt.Fprintf(opts.out, "operator add split-region %d --policy=scan\n", regionID)
+		}
+	} else if opts.mode == "merge" {
+		chains, unfixable := pd.PlanMerges(res.regions, res.invalidBoundaries)
+		for _, chain := range chains {
+			fmt.Fprintf(opts.out, "Need to merge the Regions %v sharing invalid boundaries: %v\n", chain.Regions, chain.Boundaries)
+		}
+		printUnfixableBoundaries(opts.out, unfixable)
+
+		fmt.Fprintf(opts.out, "\nRun these command through pd-ctl to merge Regions that share invalid boundary.\n")
+		fmt.Fprintf(opts.out, "The commands of the same Regions should be run one by one, after the previous one is finished:\n")
+		for _, chain := range chains {
+			for _, op := range mergeOperators(chain) {
+				fmt.Fprintln(opts.out, op.desc)
+			}
+		}
+	}
+
+	return res, nil
+}
+
+// boundaryScanResult is the Regions of a table and the invalid boundaries
+type boundaryScanResult struct {
+	regions []pd.Region
+	// RegionID -> Region
+	invalidRegions map[int64]pd.Region
+	// The invalid boundary -> the ids of Regions sharing the boundary
+	invalidBoundaries map[string][]int64
+}
+
+// regionsToSplit returns the ids of Regions with invalid boundary, splitting
+// them with an exist key makes the Regions sharing the invalid boundary small
+// enough to be merged
+func (r *boundaryScanResult) regionsToSplit() []int64 {
+	ids := make([]int64, 0, len(r.invalidRegions))
+	for id := range r.invalidRegions {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// mergeOperators returns the operators to merge the Regions of the chain into
+// the first one. The target Region is extended after each merge, so that the
+// next Region is adjacent to it, and the operators should run one by one.
+func mergeOperators(chain pd.MergeChain) []pendingOperator {
+	ops := make([]pendingOperator, 0, len(chain.Regions)-1)
+	target := chain.Regions[0]
+	for _, source := range chain.Regions[1:] {
+		ops = append(ops, pendingOperator{
+			regionID: source,
+			desc:     fmt.Sprintf("operator add merge-region %d %d", source, target),
+		})
+	}
+	return ops
+}
 
+func printUnfixableBoundaries(out io.Writer, unfixable []pd.UnfixableBoundary) {
+	for _, b := range unfixable {
+		fmt.Fprintf(out, "Can not fix the invalid boundary %s by merging, %s\n", b.Key, b.Reason)
+	}
+}
+
+// scanBoundaryOfTable scans all Regions in the table (or the partition) with
+// the table id, and finds the Regions with invalid boundary.
+func scanBoundaryOfTable(opts checkRegionBoundaryOpts, pdClient *pd.Client, tableID int64, isCommonHandle bool) (boundaryScanResult, error) {
+	var res boundaryScanResult
 	startKey, endKey := tidb.NewTableStartAsKey(tableID), tidb.NewTableEndAsKey(tableID)
 
 	numRegions, err := pdClient.GetNumRegionBetweenKey(startKey, endKey)
 	if err != nil {
-		return err
+		return res, err
 	}
 
-	fmt.Printf("The expected total num of Regions is %d, table: `%s`.`%s`, table id: %d\n",
+	logger.Infof("The expected total num of Regions is %d, table: `%s`.`%s`, table id: %d",
 		numRegions, opts.dbName, opts.tableName, tableID)
-	fmt.Printf("Scanning all Regions with batch size: %d\n", opts.numPerBatch)
+	logger.Infof("Scanning all Regions with batch size: %d", opts.numPerBatch)
 
 	// The numRegions may be not accurate cause there could be region merge/split
 	// cause by other reason
@@ -76,7 +278,7 @@ func checkBoundary(opts checkRegionBoundaryOpts) error {
 	for {
 		regions, err := pdClient.GetRegions(queryStartKey, opts.numPerBatch)
 		if err != nil {
-			return err
+			return res, err
 		}
 		if len(regions) == 0 {
 			break
@@ -87,7 +289,7 @@ func checkBoundary(opts checkRegionBoundaryOpts) error {
 		)
 		allRegions, needMore, nextQueryKey, err = concatRegionsWithSameTableID(allRegions, regions, tableID)
 		if err != nil {
-			return err
+			return res, err
 		}
 		if !needMore {
 			break
@@ -95,73 +297,171 @@ func checkBoundary(opts checkRegionBoundaryOpts) error {
 		queryStartKey = nextQueryKey
 	}
 
-	fmt.Printf("The actual total num of Regions is %d, table: `%s`.`%s`, table id: %d\n",
+	logger.Infof("The actual total num of Regions is %d, table: `%s`.`%s`, table id: %d",
 		len(allRegions), opts.dbName, opts.tableName, tableID)
 
-	// RegionID -> Region
-	regionsWithInvalidBoundary := make(map[int64]pd.Region)
-	invalidBoundaryRegions := make(map[string][]int64)
+	res.regions = allRegions
+	res.invalidRegions = make(map[int64]pd.Region)
+	res.invalidBoundaries = make(map[string][]int64)
 	for _, region := range allRegions {
 		startKey, err := tidb.FromPDKey(region.StartKey)
 		if err != nil {
-			return err
+			return res, err
 		}
-		_, err = startKey.GetTableRow()
+		_, err = decodeBoundary(startKey, isCommonHandle)
 		if err != nil {
-			fmt.Printf("Region %d, start key: %s, err: %s\n", region.Id, region.StartKey, err)
-			regionsWithInvalidBoundary[region.Id] = region
-			if _, ok := invalidBoundaryRegions[region.StartKey]; !ok {
-				invalidBoundaryRegions[region.StartKey] = []int64{region.Id}
-			} else {
-				invalidBoundaryRegions[region.StartKey] = append(invalidBoundaryRegions[region.StartKey], region.Id)
-			}
+			fmt.Fprintf(opts.out, "Region %d, start key: %s, err: %s\n", region.Id, region.StartKey, err)
+			res.invalidRegions[region.Id] = region
+			res.invalidBoundaries[region.StartKey] = append(res.invalidBoundaries[region.StartKey], region.Id)
 		}
 		endKey, err := tidb.FromPDKey(region.EndKey)
 		if err != nil {
-			return err
+			return res, err
 		}
-		_, err = endKey.GetTableRow()
+		_, err = decodeBoundary(endKey, isCommonHandle)
 		if err != nil {
-			fmt.Printf("Region %d, end   key: %s, err: %s\n", region.Id, region.EndKey, err)
-			regionsWithInvalidBoundary[region.Id] = region
-			if _, ok := invalidBoundaryRegions[region.EndKey]; !ok {
-				invalidBoundaryRegions[region.EndKey] = []int64{region.Id}
-			} else {
-				invalidBoundaryRegions[region.EndKey] = append(invalidBoundaryRegions[region.EndKey], region.Id)
-			}
+			fmt.Fprintf(opts.out, "Region %d, end   key: %s, err: %s\n", region.Id, region.EndKey, err)
+			res.invalidRegions[region.Id] = region
+			res.invalidBoundaries[region.EndKey] = append(res.invalidBoundaries[region.EndKey], region.Id)
 		}
 	}
 
-	fmt.Printf("The num of Regions have invalid boundary is: %d, total Region num is: %d\n", len(regionsWithInvalidBoundary), len(allRegions))
+	fmt.Fprintf(opts.out, "The num of Regions have invalid boundary is: %d, total Region num is: %d\n", len(res.invalidRegions), len(allRegions))
+	return res, nil
+}
 
-	if opts.mode == "split" || opts.mode == "" {
-		fmt.Printf("\nRun these command through pd-ctl to split Regions with an exist key:\n")
-		for _, region := range regionsWithInvalidBoundary {
-			fmt.Printf("operator add split-region %d --policy=scan\n", region.Id)
+// fixBoundaryOfTable fixes the invalid boundaries of Regions in the table (or
+// the partition) through PD. The Regions with invalid boundary are split with
+// an exist key first, then the Regions sharing the invalid boundary are merged.
+// It returns the result of scanning after fixing.
+func fixBoundaryOfTable(opts checkRegionBoundaryOpts, pdClient *pd.Client, tableID int64, isCommonHandle bool) (boundaryScanResult, error) {
+	logger.Infof("[1/4] Scanning the Regions of table id %d", tableID)
+	res, err := scanBoundaryOfTable(opts, pdClient, tableID, isCommonHandle)
+	if err != nil {
+		return res, err
+	}
+	if len(res.invalidRegions) == 0 {
+		fmt.Fprintln(opts.out, "No invalid boundary, nothing to fix")
+		return res, nil
+	}
+
+	toSplit := res.regionsToSplit()
+	logger.Infof("[2/4] Splitting %d Regions with invalid boundary", len(toSplit))
+	var ops []pendingOperator
+	for _, regionID := range toSplit {
+		ops = append(ops, pendingOperator{regionID: regionID, desc: fmt.Sprintf("operator add split-region %d --policy=scan", regionID)})
+	}
+	if err = submitOperators(opts, pdClient, ops, func(op pendingOperator) error {
+		return pdClient.AddSplitRegionOperator(op.regionID, pd.SplitPolicyScan)
+	}); err != nil {
+		return res, err
+	}
+
+	logger.Infof("[3/4] Re-scanning the Regions after splitting")
+	if res, err = scanBoundaryOfTable(opts, pdClient, tableID, isCommonHandle); err != nil {
+		return res, err
+	}
+	chains, unfixable := pd.PlanMerges(res.regions, res.invalidBoundaries)
+	printUnfixableBoundaries(opts.out, unfixable)
+	if err = mergeChains(opts, pdClient, chains); err != nil {
+		return res, err
+	}
+
+	logger.Infof("[4/4] Verifying the Region boundaries after merging")
+	if res, err = scanBoundaryOfTable(opts, pdClient, tableID, isCommonHandle); err != nil {
+		return res, err
+	}
+	if len(res.invalidRegions) > 0 {
+		fmt.Fprintf(opts.out, "There are still %d Regions with invalid boundary, run `check boundary --fix` again to fix them\n", len(res.invalidRegions))
+	} else {
+		fmt.Fprintln(opts.out, "All the invalid boundaries are fixed")
+	}
+	return res, nil
+}
+
+// mergeChains merges the Regions of each chain into its first Region. The
+// chains are merged concurrently, while the Regions in a chain are merged one
+// by one in steps.
+func mergeChains(opts checkRegionBoundaryOpts, pdClient *pd.Client, chains []pd.MergeChain) error {
+	logger.Infof("Merging %d chains of Regions sharing invalid boundary", len(chains))
+	var (
+		steps  [][]pendingOperator
+		allOps []pendingOperator
+	)
+	targets := make(map[int64]int64)
+	for _, chain := range chains {
+		ops := mergeOperators(chain)
+		for i, op := range ops {
+			if i >= len(steps) {
+				steps = append(steps, nil)
+			}
+			steps[i] = append(steps[i], op)
+			targets[op.regionID] = chain.Regions[0]
 		}
-	} else if opts.mode == "merge" {
-		mergeRegionSet := make(map[int64]int64)
-		for k, regions := range invalidBoundaryRegions {
-			fmt.Printf("Need to merge the Regions with invalid boundary: %s, Regions: %d %d\n", k, regions[0], regions[1])
+		allOps = append(allOps, ops...)
+	}
+	if len(allOps) == 0 {
+		return nil
+	}
+	if !confirmOperators(opts, allOps) {
+		return fmt.Errorf("operators are not submitted")
+	}
+	for i, ops := range steps {
+		logger.Infof("Merge step %d/%d, %d operators", i+1, len(steps), len(ops))
+		err := runOperators(opts, pdClient, ops, func(op pendingOperator) error {
+			return pdClient.AddMergeRegionOperator(op.regionID, targets[op.regionID])
+		})
+		if err != nil {
+			return err
 		}
+	}
+	return nil
+}
 
-		fmt.Printf("\nRun these command through pd-ctl to merge Regions that share invalid boundary:\n")
-		for _, regions := range invalidBoundaryRegions {
-			// Already apply merge with region id
-			if _, ok := mergeRegionSet[regions[0]]; ok {
-				continue
-			}
-			if _, ok := mergeRegionSet[regions[1]]; ok {
-				continue
-			}
+func confirmOperators(opts checkRegionBoundaryOpts, ops []pendingOperator) bool {
+	for _, op := range ops {
+		fmt.Fprintln(opts.out, op.desc)
+	}
+	return opts.assumeYes || confirm("Submit these operators to PD?")
+}
+
+// submitOperators submits the operators to PD after confirmation, then waits
+// for them to finish
+func submitOperators(opts checkRegionBoundaryOpts, pdClient *pd.Client, ops []pendingOperator, submit func(op pendingOperator) error) error {
+	if len(ops) == 0 {
+		return nil
+	}
+	if !confirmOperators(opts, ops) {
+		return fmt.Errorf("operators are not submitted")
+	}
+	return runOperators(opts, pdClient, ops, submit)
+}
 
-			fmt.Printf("operator add merge-region %d %d\n", regions[0], regions[1])
-			mergeRegionSet[regions[0]] = 1
-			mergeRegionSet[regions[1]] = 1
+// runOperators submits the operators to PD and waits for them to finish
+func runOperators(opts checkRegionBoundaryOpts, pdClient *pd.Client, ops []pendingOperator, submit func(op pendingOperator) error) error {
+	for _, op := range ops {
+		if err := submit(op); err != nil {
+			return err
 		}
+		logger.Infof("%s => submitted", op.desc)
 	}
+	return waitOperators(pdClient, ops, opts.opTimeout)
+}
 
-	return nil
+// decodeBoundary decodes the Region boundary as a row key with the handle type
+// of the table. The key that can not be decoded is an invalid boundary.
+func decodeBoundary(key tidb.TiKVKey, isCommonHandle bool) (tidb.TableRow, error) {
+	if isCommonHandle {
+		return key.GetTableRowAsCommonHandle()
+	}
+	row, err := key.GetTableRow()
+	if err != nil {
+		return row, err
+	}
+	if row.IsCommonHandle() {
+		return row, fmt.Errorf("not a row key of int handle, %s", key.GetPDKey())
+	}
+	return row, nil
 }
 
 func concatRegionsWithSameTableID(allRegions, newRegions []pd.Region, tableID int64) ([]pd.Region, bool, tidb.TiKVKey, error) {
@@ -196,10 +496,10 @@ func concatRegionsWithSameTableID(allRegions, newRegions []pd.Region, tableID in
 	}
 
 	if allWithInOneTable {
-		fmt.Printf("The start key of Region %d is %s, table id: %d. continue with the end key: %s\n",
+		logger.Debugf("The start key of Region %d is %s, table id: %d. continue with the end key: %s",
 			lastRegionID, lastStartKey.GetPDKey(), tableID, lastEndKey.GetPDKey())
 	} else {
-		fmt.Printf("The start key of Region %d is %s, table id: %d. All finished, break.\n",
+		logger.Debugf("The start key of Region %d is %s, table id: %d. All finished, break.",
 			lastRegionID, lastStartKey.GetPDKey(), lastTblID)
 	}
 	return allRegions, allWithInOneTable, lastEndKey, nil
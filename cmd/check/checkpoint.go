@@ -0,0 +1,192 @@
+package check
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/logger"
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/pd"
+)
+
+// The stages of checking the rows of a physical table
+const (
+	// Narrowing down the inconsistent range by bisecting the query ranges
+	stageBisect = "bisect"
+	// Checking the rows Region by Region
+	stageRegion = "region"
+	stageDone   = "done"
+)
+
+// checkpoint is the progress of checking rows, it is saved to a file so that
+// the check can be resumed after it is interrupted
+type checkpoint struct {
+	SnapshotTS uint64             `json:"snapshot_ts"`
+	Tables     []*tableCheckpoint `json:"tables"`
+}
+
+// tableCheckpoint is the progress of checking the rows of a table or a partition
+type tableCheckpoint struct {
+	DBName    string `json:"db_name"`
+	TableName string `json:"table_name"`
+	Partition string `json:"partition,omitempty"`
+	TableID   int64  `json:"table_id"`
+	Stage     string `json:"stage"`
+
+	// The pending query ranges to bisect
+	QueryRanges []QueryRange `json:"query_ranges,omitempty"`
+	// The last checked range by bisecting
+	CurRange          QueryRange `json:"cur_range"`
+	CurRangeIsConsist bool       `json:"cur_range_is_consist"`
+
+	// The PD key of the next Region to check
+	NextKey          string          `json:"next_key,omitempty"`
+	NumSuccess       int             `json:"num_success"`
+	InconsistRegions []pd.Region     `json:"inconsist_regions,omitempty"`
+	SkippedRegions   []skippedRegion `json:"skipped_regions,omitempty"`
+}
+
+// checkpointer saves the checkpoint to the file on each update. A nil
+// checkpointer means checkpoint is disabled, all of its methods are no-op.
+type checkpointer struct {
+	path  string
+	mu    sync.Mutex
+	state checkpoint
+}
+
+// newCheckpointer returns the checkpointer saving to the file, the checkpoint
+// in the file is loaded if resuming.
+func newCheckpointer(path string, resume bool) (*checkpointer, error) {
+	if path == "" {
+		if resume {
+			return nil, fmt.Errorf("should set the checkpoint file for resuming")
+		}
+		return nil, nil
+	}
+	c := &checkpointer{path: path}
+	if !resume {
+		return c, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		logger.Infof("Checkpoint file %s does not exist, start a new check", path)
+		return c, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &c.state); err != nil {
+		return nil, fmt.Errorf("parse checkpoint file %s fail: %s", path, err)
+	}
+	logger.Infof("Resume from checkpoint file %s, %d tables in progress or done", path, len(c.state.Tables))
+	return c, nil
+}
+
+func (c *checkpointer) snapshotTS() uint64 {
+	if c == nil {
+		return 0
+	}
+	return c.state.SnapshotTS
+}
+
+func (c *checkpointer) setSnapshotTS(tso uint64) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state.SnapshotTS != 0 && c.state.SnapshotTS != tso {
+		logger.Warnf("The snapshot TSO %d is different from %d in checkpoint, the resumed results are checked at different snapshots", tso, c.state.SnapshotTS)
+	}
+	c.state.SnapshotTS = tso
+	return c.save()
+}
+
+// table returns the checkpoint of the physical table being checked, a new one
+// in bisect stage is added if it does not exist.
+func (c *checkpointer) table(opts checkRowsOpts, tableID int64) *tableCheckpoint {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, t := range c.state.Tables {
+		if t.DBName == opts.dbName && t.TableName == opts.tableName && t.Partition == opts.partition && t.TableID == tableID {
+			return t
+		}
+	}
+	t := &tableCheckpoint{
+		DBName:    opts.dbName,
+		TableName: opts.tableName,
+		Partition: opts.partition,
+		TableID:   tableID,
+		Stage:     stageBisect,
+	}
+	c.state.Tables = append(c.state.Tables, t)
+	return t
+}
+
+// update modifies the checkpoint of the table by f and saves it to the file
+func (c *checkpointer) update(t *tableCheckpoint, f func(t *tableCheckpoint)) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f(t)
+	return c.save()
+}
+
+func (c *checkpointer) save() error {
+	data, err := json.MarshalIndent(&c.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file then rename it, so the checkpoint file is
+	// always complete even if the process is killed while saving
+	tmp := c.path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
+
+// queryRangeJSON is the format of QueryRange in the checkpoint file
+type queryRangeJSON struct {
+	Min      int64    `json:"min"`
+	Max      int64    `json:"max"`
+	MinInf   bool     `json:"min_inf,omitempty"`
+	MaxInf   bool     `json:"max_inf,omitempty"`
+	IsTuple  bool     `json:"is_tuple,omitempty"`
+	MinTuple []string `json:"min_tuple,omitempty"`
+	MaxTuple []string `json:"max_tuple,omitempty"`
+}
+
+func (m QueryRange) MarshalJSON() ([]byte, error) {
+	return json.Marshal(queryRangeJSON{
+		Min:      m.min,
+		Max:      m.max,
+		MinInf:   m.minInf,
+		MaxInf:   m.maxInf,
+		IsTuple:  m.isTuple,
+		MinTuple: m.minTuple,
+		MaxTuple: m.maxTuple,
+	})
+}
+
+func (m *QueryRange) UnmarshalJSON(data []byte) error {
+	var r queryRangeJSON
+	if err := json.Unmarshal(data, &r); err != nil {
+		return err
+	}
+	*m = QueryRange{
+		min:      r.Min,
+		max:      r.Max,
+		minInf:   r.MinInf,
+		maxInf:   r.MaxInf,
+		isTuple:  r.IsTuple,
+		minTuple: r.MinTuple,
+		maxTuple: r.MaxTuple,
+	}
+	return nil
+}
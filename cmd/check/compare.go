@@ -0,0 +1,228 @@
+package check
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/tidb"
+)
+
+// The modes of comparing the rows between TiKV and TiFlash
+const (
+	// Compare the num of rows
+	compareModeCount = "count"
+	// Compare the num of rows and the checksum of all columns
+	compareModeChecksum = "checksum"
+	// Compare by checksum, then compare the inconsistent Regions row by row
+	compareModeFull = "full"
+)
+
+func isValidCompareMode(mode string) bool {
+	switch mode {
+	case compareModeCount, compareModeChecksum, compareModeFull:
+		return true
+	}
+	return false
+}
+
+// buildChecksumExpr returns the aggregated hash over all columns of the rows
+func buildChecksumExpr(cols []tidb.Column) string {
+	names := make([]string, 0, len(cols))
+	nullFlags := make([]string, 0, len(cols))
+	for _, col := range cols {
+		name := "`" + col.Name + "`"
+		names = append(names, name)
+		nullFlags = append(nullFlags, fmt.Sprintf("isnull(%s)", name))
+	}
+	// concat_ws skips the NULL values, append the NULL flags of columns to
+	// tell the NULL values from the empty values
+	return fmt.Sprintf("bit_xor(crc32(concat_ws(',', %s, concat(%s))))",
+		strings.Join(names, ", "), strings.Join(nullFlags, ", "))
+}
+
+// rowDiff is a row that is different between TiKV and TiFlash
+type rowDiff struct {
+	handle []string
+	// The engine that the row only exists in, empty if the row exists in
+	// both engines but some columns are different
+	onlyIn string
+	// The columns with different values
+	columns []string
+	details []string
+}
+
+func (d *rowDiff) String() string {
+	handle := "(" + strings.Join(d.handle, ", ") + ")"
+	if d.onlyIn != "" {
+		return fmt.Sprintf("Row %s only exists in %s", handle, d.onlyIn)
+	}
+	return fmt.Sprintf("Row %s has different columns: %s; %s", handle, strings.Join(d.columns, ", "), strings.Join(d.details, "; "))
+}
+
+// orderedRows is the rows in a range ordered by handle, read from one engine
+type orderedRows struct {
+	engine  string
+	handle  tableHandle
+	numCols int
+	conn    *sql.Conn
+	rows    *sql.Rows
+
+	// The current row, the handle values are followed by the column values
+	vals  []sql.NullString
+	key   []byte
+	valid bool
+	err   error
+}
+
+func openOrderedRows(ctx context.Context, db *sql.DB, opts checkRowsOpts, handle tableHandle, engine string, queryRange QueryRange) (*orderedRows, error) {
+	conn, err := newCheckConn(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = execContext(ctx, conn, "set tidb_isolation_read_engines="+engine); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	names := make([]string, 0, len(opts.columns))
+	for _, col := range opts.columns {
+		names = append(names, "`"+col.Name+"`")
+	}
+	query := fmt.Sprintf("select %s, %s from %s %s order by %s",
+		handle.String(), strings.Join(names, ", "), opts.tableRef(), queryRange.toWhereFilter(handle), handle.orderBy())
+	start := time.Now()
+	rows, err := conn.QueryContext(ctx, query)
+	// The rows are read in streaming, only the latency of the first response is logged
+	tidb.LogSQL(query, engine, start)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	r := &orderedRows{
+		engine:  engine,
+		handle:  handle,
+		numCols: len(opts.columns),
+		conn:    conn,
+		rows:    rows,
+		vals:    make([]sql.NullString, len(handle.colNames())+len(opts.columns)),
+	}
+	r.next()
+	return r, nil
+}
+
+func (r *orderedRows) numHandleCols() int {
+	return len(r.vals) - r.numCols
+}
+
+func (r *orderedRows) handleVals() []string {
+	vals := make([]string, 0, r.numHandleCols())
+	for _, v := range r.vals[:r.numHandleCols()] {
+		vals = append(vals, v.String)
+	}
+	return vals
+}
+
+func (r *orderedRows) next() {
+	if r.valid = r.rows.Next(); !r.valid {
+		r.err = r.rows.Err()
+		return
+	}
+	dest := make([]interface{}, len(r.vals))
+	for i := range r.vals {
+		dest[i] = &r.vals[i]
+	}
+	if r.err = r.rows.Scan(dest...); r.err != nil {
+		r.valid = false
+		return
+	}
+	r.key = r.handle.sortKey(r.handleVals())
+}
+
+func (r *orderedRows) close() {
+	r.rows.Close()
+	r.conn.Close()
+}
+
+func nullStringToString(v sql.NullString) string {
+	const maxLen = 64
+	if !v.Valid {
+		return "NULL"
+	}
+	if len(v.String) > maxLen {
+		return fmt.Sprintf("%q...", v.String[:maxLen])
+	}
+	return fmt.Sprintf("%q", v.String)
+}
+
+// compareRowsFully reads the rows in the range ordered by handle from TiKV and
+// TiFlash, and returns the rows that are different between them. It stops
+// after finding maxDiffs different rows if maxDiffs > 0.
+// Note that the rows are read in two transactions, the rows written during
+// comparing could be reported as different rows.
+func compareRowsFully(out io.Writer, db *sql.DB, opts checkRowsOpts, handle tableHandle, queryRange QueryRange, maxDiffs int) ([]rowDiff, error) {
+	ctx := context.Background()
+	tikv, err := openOrderedRows(ctx, db, opts, handle, "tikv", queryRange)
+	if err != nil {
+		return nil, err
+	}
+	defer tikv.close()
+	tiflash, err := openOrderedRows(ctx, db, opts, handle, "tiflash", queryRange)
+	if err != nil {
+		return nil, err
+	}
+	defer tiflash.close()
+
+	var diffs []rowDiff
+	for (tikv.valid || tiflash.valid) && (maxDiffs <= 0 || len(diffs) < maxDiffs) {
+		var cmp int
+		if !tiflash.valid {
+			cmp = -1
+		} else if !tikv.valid {
+			cmp = 1
+		} else {
+			cmp = bytes.Compare(tikv.key, tiflash.key)
+		}
+		switch {
+		case cmp < 0:
+			diffs = append(diffs, rowDiff{handle: tikv.handleVals(), onlyIn: tikv.engine})
+			tikv.next()
+		case cmp > 0:
+			diffs = append(diffs, rowDiff{handle: tiflash.handleVals(), onlyIn: tiflash.engine})
+			tiflash.next()
+		default:
+			diff := rowDiff{handle: tikv.handleVals()}
+			for i, col := range opts.columns {
+				v1, v2 := tikv.vals[tikv.numHandleCols()+i], tiflash.vals[tiflash.numHandleCols()+i]
+				if v1 != v2 {
+					diff.columns = append(diff.columns, "`"+col.Name+"`")
+					diff.details = append(diff.details, fmt.Sprintf("`%s`: tikv %s, tiflash %s", col.Name, nullStringToString(v1), nullStringToString(v2)))
+				}
+			}
+			if len(diff.columns) > 0 {
+				diffs = append(diffs, diff)
+			}
+			tikv.next()
+			tiflash.next()
+		}
+	}
+	if tikv.err != nil {
+		return diffs, tikv.err
+	}
+	if tiflash.err != nil {
+		return diffs, tiflash.err
+	}
+
+	fmt.Fprintf(out, "Found %d different rows in range %s", len(diffs), queryRange.String())
+	if maxDiffs > 0 && len(diffs) >= maxDiffs {
+		fmt.Fprintf(out, " (stop after finding %d rows)", maxDiffs)
+	}
+	fmt.Fprintln(out)
+	for i := range diffs {
+		fmt.Fprintln(out, diffs[i].String())
+	}
+	return diffs, nil
+}
@@ -2,14 +2,22 @@ package check
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"io"
+	"os"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/format"
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/logger"
 	"github.com/JaySon-Huang/tiflash-ctl/pkg/options"
 	"github.com/JaySon-Huang/tiflash-ctl/pkg/pd"
 	"github.com/JaySon-Huang/tiflash-ctl/pkg/tidb"
+	"github.com/olekukonko/tablewriter"
 	"github.com/spf13/cobra"
 )
 
@@ -19,6 +27,8 @@ func NewRowConsistencyCmd() *cobra.Command {
 		Use:   "consistency",
 		Short: "Check the consistency betweeen TiKV && TiFlash",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			opt.output = options.GetOutput(cmd)
+			opt.out = format.TextWriter(opt.output)
 			return checkRows(opt)
 		},
 	}
@@ -26,16 +36,30 @@ func NewRowConsistencyCmd() *cobra.Command {
 	// Flags for "consistency"
 	options.AddTiDBConnFlags(c, &opt.tidb)
 
-	c.Flags().StringVar(&opt.dbName, "database", "", "The database name of query table")
+	c.Flags().StringVar(&opt.dbName, "database", "", "The database name of query table, check all tables with TiFlash replica in the database if table is not set")
 	c.Flags().StringVar(&opt.tableName, "table", "", "The table name of query table")
-	c.Flags().IntVar(&opt.numReplica, "num_replica", 2, "The number of TiFlash replica for the query table")
+	c.Flags().StringVar(&opt.partitions, "partition", "", "The comma separated partition names to check (check all partitions by default)")
+	c.Flags().BoolVar(&opt.all, "all", false, "Check all tables with TiFlash replica in the cluster")
+	c.Flags().IntVar(&opt.numReplica, "num_replica", 2, "The number of TiFlash replica for the query table (the replica count of each table is used when checking multiple tables)")
 
 	c.Flags().StringVar(&opt.rowIdColName, "row_id_col_name", "_tidb_rowid", "The TiDB row id column name")
 	c.Flags().Int64Var(&opt.minNumInRange, "min_num_in_range", 1, "The minimal number of ids in a query range to search")
 	c.Flags().BoolVar(&opt.forceCheckByKey, "force", false, "Force run checking rows by Region")
 	c.Flags().Int64Var(&opt.numRegionsLimit, "regions_limit", 20, "The limited number of Regions to check")
+	c.Flags().IntVar(&opt.concurrency, "concurrency", 1, "The num of workers to check Regions concurrently")
+	c.Flags().IntVar(&opt.rateLimit, "rate_limit", 0, "The max num of Regions to check per second among all workers, 0 means no limit")
+	c.Flags().Int64Var(&opt.numPerBatch, "batch", 16, "The batch size for fetching Region info when checking Regions concurrently")
 	c.Flags().Int64Var(&opt.queryLowerBound, "lower_bound", 0, "The lower bound of query (leave it to be default)")
 	c.Flags().Int64Var(&opt.queryUpperBound, "upper_bound", 0, "The upper bound of query (leave it to be default)")
+	c.Flags().StringVar(&opt.compareMode, "compare", compareModeCount, "How to compare the rows between TiKV and TiFlash, one of count|checksum|full")
+	c.Flags().Uint64Var(&opt.snapshotTS, "snapshot_ts", 0, "The TSO of snapshot to check the rows at, use the current TSO by default. It should be later than the GC safe point")
+	c.Flags().StringVar(&opt.checkpointPath, "checkpoint", "", "The file to save the progress of checking, so that the check can be resumed by --resume")
+	c.Flags().BoolVar(&opt.resume, "resume", false, "Resume the check from the checkpoint file, the results in the file are merged into the results")
+	c.Flags().BoolVar(&opt.apply, "apply", false, "Remove the TiFlash peers of the inconsistent Regions through PD, then check the Regions again")
+	c.Flags().IntVar(&opt.applyRounds, "apply_rounds", 3, "The max rounds of removing peers and checking again in apply mode")
+	c.Flags().BoolVar(&opt.assumeYes, "yes", false, "Submit the operators in apply mode without confirmation")
+	c.Flags().DurationVar(&opt.opTimeout, "operator_timeout", 10*time.Minute, "The timeout of waiting for the operators to finish and the TiFlash peers to be added back in apply mode")
+	c.Flags().IntVar(&opt.maxDiffs, "max_diffs", 100, "The max num of different rows to report for each Region in full compare mode, 0 means no limit")
 	return c
 }
 
@@ -43,35 +67,540 @@ type checkRowsOpts struct {
 	tidb            tidb.TiDBClientOpts
 	dbName          string
 	tableName       string
+	partitions      string
+	all             bool
 	numReplica      int
 	rowIdColName    string
 	forceCheckByKey bool
 	minNumInRange   int64
 	numRegionsLimit int64
+	concurrency     int
+	rateLimit       int
+	numPerBatch     int64
 	queryLowerBound int64
 	queryUpperBound int64
+	compareMode     string
+	maxDiffs        int
+	snapshotTS      uint64
+	checkpointPath  string
+	resume          bool
+	apply           bool
+	applyRounds     int
+	assumeYes       bool
+	opTimeout       time.Duration
+	output          string
+	// Where the results are printed as text, see format.TextWriter
+	out io.Writer
+
+	// The partition being checked, empty if the table is not partitioned
+	partition string
+	// The columns and the checksum expression of the table being checked,
+	// only set when comparing by checksum
+	columns      []tidb.Column
+	checksumExpr string
+	// Save the progress of checking, nil if checkpoint is disabled
+	checkpoint *checkpointer
 }
 
 func checkRows(opts checkRowsOpts) error {
-	client, err := tidb.NewClientFromOpts(opts.tidb)
+	if opts.all && (opts.dbName != "" || opts.tableName != "") {
+		return fmt.Errorf("can not set the database or table name with --all")
+	}
+	if !opts.all && opts.dbName == "" {
+		return fmt.Errorf("should set the database name, or check all tables by --all")
+	}
+	if opts.tableName == "" && opts.partitions != "" {
+		return fmt.Errorf("should set the table name for checking partitions")
+	}
+	if !isValidCompareMode(opts.compareMode) {
+		return fmt.Errorf("unknown compare mode %s, should be one of count|checksum|full", opts.compareMode)
+	}
+
+	checkpoint, err := newCheckpointer(opts.checkpointPath, opts.resume)
+	if err != nil {
+		return err
+	}
+	opts.checkpoint = checkpoint
+	if opts.snapshotTS == 0 {
+		// Check at the same snapshot as the checkpoint if not specified
+		opts.snapshotTS = checkpoint.snapshotTS()
+	}
+
+	client, err := newSnapshotClient(opts.tidb, &opts.snapshotTS)
 	if err != nil {
 		return err
 	}
 	defer client.Close()
+	if err = checkpoint.setSnapshotTS(opts.snapshotTS); err != nil {
+		return err
+	}
 
 	if err = client.ExecWithElapsed("set tidb_allow_batch_cop = 0"); err != nil {
-		fmt.Println("tidb_allow_batch_cop is ignored")
+		logger.Warnf("tidb_allow_batch_cop is ignored")
 	}
 	if err = client.ExecWithElapsed("set tidb_allow_mpp = 0"); err != nil {
-		fmt.Println("tidb_allow_mpp = 0 is ignored")
+		logger.Warnf("tidb_allow_mpp = 0 is ignored")
 	}
 
-	queryRanges, err := getInitQueryRange(client.Db, opts)
+	if opts.tableName != "" {
+		results, err := checkRowsOfTable(&client, opts)
+		if err != nil {
+			return err
+		}
+		if len(results) > 1 || results[0].table.IsPartition() || len(results[0].skippedRegions) > 0 {
+			fmt.Fprintln(opts.out)
+			renderRowsCheckResults(opts.out, results)
+		}
+		return finishCheckRows(&client, opts, results)
+	}
+	results, err := checkRowsOfTables(&client, opts)
 	if err != nil {
 		return err
 	}
-	fmt.Printf("Init query ranges: %s\n", queryRanges)
+	return finishCheckRows(&client, opts, results)
+}
+
+// finishCheckRows repairs the inconsistent Regions in apply mode, and renders
+// the report if the output is structured
+func finishCheckRows(client *tidb.Client, opts checkRowsOpts, results []rowsCheckResult) error {
+	printSnapshot(opts.out, opts.snapshotTS)
+	printSkippedRegions(opts.out, results)
+	var err error
+	if opts.apply {
+		err = applyRemovePeers(client, opts, results)
+	}
+	if format.IsStructured(opts.output) {
+		if renderErr := format.Render(os.Stdout, opts.output, newConsistencyReport(opts, results)); renderErr != nil {
+			return renderErr
+		}
+	}
+	return err
+}
+
+// newSnapshotClient returns a client that all of the queries on TiKV and
+// TiFlash read the data at the same snapshot, so the rows are comparable even
+// if the table is being written. If the TSO is 0, the current TSO is used and
+// set to it. All the sessions use UTC for comparing with the timestamp in row
+// keys, which is in UTC.
+func newSnapshotClient(opts tidb.TiDBClientOpts, tso *uint64) (tidb.Client, error) {
+	opts.TimeZone = "+00:00"
+	if *tso == 0 {
+		client, err := tidb.NewClientFromOpts(opts)
+		if err != nil {
+			return tidb.Client{}, err
+		}
+		*tso, err = client.GetCurrentTSO()
+		client.Close()
+		if err != nil {
+			return tidb.Client{}, fmt.Errorf("get current TSO fail: %s", err)
+		}
+	}
+	logger.Infof("Check the rows at snapshot TSO %d (%s)", *tso, formatTSO(*tso))
+	return tidb.NewSnapshotClientFromOpts(opts, *tso)
+}
+
+// printSkippedRegions prints the Regions not checked, they should be checked
+// again after their TiFlash peers catch up
+func printSkippedRegions(out io.Writer, results []rowsCheckResult) {
+	var num int
+	for i := range results {
+		num += len(results[i].skippedRegions)
+	}
+	if num == 0 {
+		return
+	}
+	fmt.Fprintf(out, "%d Regions are skipped since their TiFlash peers are not healthy, check them again later:\n", num)
+	for i := range results {
+		for _, r := range results[i].skippedRegions {
+			fmt.Fprintf(out, "Region %v of `%s`.`%s`, %s\n", r.Region, results[i].dbName, results[i].tableName, r.Reason)
+		}
+	}
+}
+
+func printSnapshot(out io.Writer, tso uint64) {
+	fmt.Fprintf(out, "Check the rows at snapshot TSO %d (%s)\n", tso, formatTSO(tso))
+}
+
+func formatTSO(tso uint64) string {
+	return tidb.TSOToTime(tso).Format("2006-01-02 15:04:05.000 -0700")
+}
+
+// checkRowsOfTables checks all tables with TiFlash replica in the database (or
+// in the cluster), then reports the summary of all tables and the operators to
+// remove the TiFlash peers with not consist num of rows.
+func checkRowsOfTables(client *tidb.Client, opts checkRowsOpts) ([]rowsCheckResult, error) {
+	replicas, err := client.GetTiFlashReplicas(opts.dbName)
+	if err != nil {
+		return nil, err
+	}
+	if len(replicas) == 0 {
+		fmt.Fprintln(opts.out, "No table with TiFlash replica to check")
+		return nil, nil
+	}
+
+	var results []rowsCheckResult
+	for _, replica := range replicas {
+		tableOpts := opts
+		tableOpts.dbName, tableOpts.tableName = replica.DBName, replica.TableName
+		tableOpts.numReplica = replica.ReplicaCount
+		logger.Infof("Checking table `%s`.`%s`", replica.DBName, replica.TableName)
+		if !replica.Available {
+			logger.Warnf("Skip checking `%s`.`%s`, the TiFlash replica is not available", replica.DBName, replica.TableName)
+			results = append(results, rowsCheckResult{dbName: replica.DBName, tableName: replica.TableName, skipReason: "TiFlash replica is not available"})
+			continue
+		}
+		if tableOpts.rowIdColName, err = client.GetIntHandleColumn(replica.DBName, replica.TableName); err != nil {
+			results = append(results, rowsCheckResult{dbName: replica.DBName, tableName: replica.TableName, err: err})
+			continue
+		}
+		tableResults, err := checkRowsOfTable(client, tableOpts)
+		if err != nil {
+			// Keep checking the other tables
+			logger.Errorf("Check `%s`.`%s` failed: %s", replica.DBName, replica.TableName, err)
+			results = append(results, rowsCheckResult{dbName: replica.DBName, tableName: replica.TableName, err: err})
+			continue
+		}
+		results = append(results, tableResults...)
+	}
+
+	fmt.Fprintln(opts.out)
+	renderRowsCheckResults(opts.out, results)
+
+	var numInconsist int
+	for _, r := range results {
+		numInconsist += len(r.inconsistRegions)
+	}
+	if numInconsist > 0 && !opts.apply {
+		fmt.Fprintf(opts.out, "\nRun these command through pd-ctl to remove the TiFlash peers that have not consist num of rows:\n")
+		for _, r := range results {
+			for _, region := range r.inconsistRegions {
+				for _, storeID := range region.GetLearnerStoreIDs() {
+					fmt.Fprintf(opts.out, "operator add remove-peer %d %d\n", region.Id, storeID)
+				}
+			}
+		}
+	}
+	return results, nil
+}
+
+// checkRowsOfTable checks the rows of each partition of the table, or the
+// table itself if it is not partitioned.
+func checkRowsOfTable(client *tidb.Client, opts checkRowsOpts) ([]rowsCheckResult, error) {
+	commonCols, err := client.GetCommonHandleColumns(opts.dbName, opts.tableName)
+	if err != nil {
+		return nil, err
+	}
+	handle := newIntHandle(opts.rowIdColName)
+	if len(commonCols) > 0 {
+		handle = newCommonHandle(commonCols)
+		logger.Infof("The table is clustered by common handle: (%s)", handle.String())
+	}
+
+	if opts.compareMode != compareModeCount {
+		if opts.columns, err = client.GetColumns(opts.dbName, opts.tableName); err != nil {
+			return nil, err
+		}
+		opts.checksumExpr = buildChecksumExpr(opts.columns)
+	}
+
+	tables, err := client.GetPhysicalTables(opts.dbName, opts.tableName)
+	if err != nil {
+		return nil, err
+	}
+	if tables, err = tidb.FilterPartitions(tables, opts.partitions); err != nil {
+		return nil, err
+	}
+
+	var results []rowsCheckResult
+	for _, table := range tables {
+		tableOpts := opts
+		tableOpts.partition = table.PartitionName
+		if table.IsPartition() {
+			logger.Infof("Checking %s", table.String())
+		}
+		res := rowsCheckResult{dbName: opts.dbName, tableName: opts.tableName, table: table, opts: tableOpts, handle: handle}
+		res.queryRange, res.isConsist, res.inconsistRegions, res.skippedRegions, res.err = checkRowsOfPhysicalTable(client, tableOpts, handle, table.ID)
+		if res.err != nil {
+			if !table.IsPartition() {
+				return nil, res.err
+			}
+			// Keep checking the other partitions
+			logger.Errorf("Check %s failed: %s", table.String(), res.err)
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+// rowsCheckResult is the result of checking the rows of a table or a partition
+type rowsCheckResult struct {
+	dbName     string
+	tableName  string
+	table      tidb.PhysicalTable
+	queryRange QueryRange
+	isConsist  bool
+	// The Regions have not consist num of rows
+	inconsistRegions []pd.Region
+	// The Regions not checked, the result of the table is not complete
+	skippedRegions []skippedRegion
+	skipReason     string
+	err            error
+
+	// For checking the inconsistent Regions again after removing the peers
+	opts   checkRowsOpts
+	handle tableHandle
+}
+
+func (r *rowsCheckResult) status() string {
+	if r.err != nil {
+		return "ERROR"
+	} else if r.skipReason != "" {
+		return "SKIP"
+	} else if !r.isConsist || len(r.inconsistRegions) > 0 {
+		return "FAIL"
+	} else if len(r.skippedRegions) > 0 {
+		return "INCOMPLETE"
+	}
+	return "OK"
+}
+
+func (r *rowsCheckResult) toRow() []string {
+	detail := r.queryRange.String()
+	if r.err != nil {
+		detail = r.err.Error()
+	} else if r.skipReason != "" {
+		detail = r.skipReason
+	}
+	tableID := ""
+	if r.table.ID != 0 {
+		tableID = fmt.Sprint(r.table.ID)
+	}
+	return []string{
+		fmt.Sprintf("`%s`.`%s`", r.dbName, r.tableName), r.table.PartitionName, tableID,
+		r.status(), detail, fmt.Sprint(len(r.inconsistRegions)), fmt.Sprint(len(r.skippedRegions)),
+	}
+}
+
+// consistencyReport is the result of `check consistency` for the structured
+// output
+type consistencyReport struct {
+	SnapshotTS uint64             `json:"snapshot_ts"`
+	Applied    bool               `json:"applied"`
+	Tables     []tableConsistency `json:"tables"`
+	// The pd-ctl commands to remove the TiFlash peers of inconsistent Regions
+	Operators []string `json:"operators"`
+}
+
+type tableConsistency struct {
+	Database         string         `json:"database"`
+	Table            string         `json:"table"`
+	Partition        string         `json:"partition,omitempty"`
+	TableID          int64          `json:"table_id,omitempty"`
+	Status           string         `json:"status"`
+	Range            string         `json:"range,omitempty"`
+	SkipReason       string         `json:"skip_reason,omitempty"`
+	Error            string         `json:"error,omitempty"`
+	InconsistRegions []regionReport `json:"inconsistent_regions"`
+	SkippedRegions   []regionReport `json:"skipped_regions"`
+}
+
+// regionReport is a Region in the structured output, the keys are in PD format
+type regionReport struct {
+	ID             int64   `json:"id"`
+	StartKey       string  `json:"start_key"`
+	EndKey         string  `json:"end_key"`
+	TiFlashStores  []int64 `json:"tiflash_stores,omitempty"`
+	ApproximateMiB int64   `json:"approximate_size_mib,omitempty"`
+	// Why the Region is not checked, only for the skipped Regions
+	SkipReason string `json:"skip_reason,omitempty"`
+}
+
+func newRegionReport(region *pd.Region) regionReport {
+	return regionReport{
+		ID:             region.Id,
+		StartKey:       region.StartKey,
+		EndKey:         region.EndKey,
+		TiFlashStores:  region.GetLearnerStoreIDs(),
+		ApproximateMiB: region.ApproximateSize,
+	}
+}
+
+func newConsistencyReport(opts checkRowsOpts, results []rowsCheckResult) *consistencyReport {
+	report := &consistencyReport{SnapshotTS: opts.snapshotTS, Applied: opts.apply, Tables: []tableConsistency{}, Operators: []string{}}
+	for i := range results {
+		r := &results[i]
+		t := tableConsistency{
+			Database:         r.dbName,
+			Table:            r.tableName,
+			Partition:        r.table.PartitionName,
+			TableID:          r.table.ID,
+			Status:           r.status(),
+			SkipReason:       r.skipReason,
+			InconsistRegions: []regionReport{},
+			SkippedRegions:   []regionReport{},
+		}
+		if r.err != nil {
+			t.Error = r.err.Error()
+		} else if r.skipReason == "" {
+			t.Range = r.queryRange.String()
+		}
+		for j := range r.inconsistRegions {
+			region := &r.inconsistRegions[j]
+			t.InconsistRegions = append(t.InconsistRegions, newRegionReport(region))
+			for _, storeID := range region.GetLearnerStoreIDs() {
+				report.Operators = append(report.Operators, fmt.Sprintf("operator add remove-peer %d %d", region.Id, storeID))
+			}
+		}
+		for j := range r.skippedRegions {
+			region := newRegionReport(&r.skippedRegions[j].Region)
+			region.SkipReason = r.skippedRegions[j].Reason
+			t.SkippedRegions = append(t.SkippedRegions, region)
+		}
+		report.Tables = append(report.Tables, t)
+	}
+	return report
+}
+
+func (r *consistencyReport) Header() []string {
+	return []string{"database", "table", "partition", "table id", "status", "range", "inconsistent regions", "skipped regions"}
+}
+
+func (r *consistencyReport) Rows() [][]string {
+	rows := make([][]string, 0, len(r.Tables))
+	for _, t := range r.Tables {
+		detail := t.Range
+		if t.Error != "" {
+			detail = t.Error
+		} else if t.SkipReason != "" {
+			detail = t.SkipReason
+		}
+		rows = append(rows, []string{t.Database, t.Table, t.Partition, strconv.FormatInt(t.TableID, 10), t.Status, detail,
+			joinRegionIDs(t.InconsistRegions), joinRegionIDs(t.SkippedRegions)})
+	}
+	return rows
+}
+
+func joinRegionIDs(regions []regionReport) string {
+	ids := make([]string, 0, len(regions))
+	for _, region := range regions {
+		ids = append(ids, strconv.FormatInt(region.ID, 10))
+	}
+	return strings.Join(ids, " ")
+}
+
+func renderRowsCheckResults(out io.Writer, results []rowsCheckResult) {
+	table := tablewriter.NewWriter(out)
+	table.SetHeader([]string{"table", "partition", "table id", "status", "range", "inconsistent regions", "skipped regions"})
+	for i := range results {
+		table.Append(results[i].toRow())
+	}
+	table.Render()
+}
+
+// checkRowsOfPhysicalTable checks the rows of the table (or the partition) with
+// the table id. It returns the last checked query range and whether it is
+// consistent, and the Regions with not consist num of rows and the skipped
+// Regions if the rows are checked by Regions.
+func checkRowsOfPhysicalTable(client *tidb.Client, opts checkRowsOpts, handle tableHandle, tableID int64) (QueryRange, bool, []pd.Region, []skippedRegion, error) {
+	cp := opts.checkpoint.table(opts, tableID)
+	if cp != nil && cp.Stage == stageDone {
+		logger.Infof("Skip checking table id %d, it is done in the checkpoint", tableID)
+		return cp.CurRange, cp.CurRangeIsConsist, cp.InconsistRegions, cp.SkippedRegions, nil
+	}
+
+	var (
+		curRange          QueryRange
+		curRangeIsConsist bool
+		checkKey          tidb.TiKVKey
+		err               error
+	)
+	if cp != nil && cp.Stage == stageRegion {
+		curRange, curRangeIsConsist = cp.CurRange, cp.CurRangeIsConsist
+		if checkKey, err = tidb.FromPDKey(cp.NextKey); err != nil {
+			return curRange, curRangeIsConsist, nil, nil, err
+		}
+		logger.Infof("Resume checking the rows of Region from key %s", cp.NextKey)
+	} else {
+		var queryRanges []QueryRange
+		if cp != nil && len(cp.QueryRanges) > 0 {
+			queryRanges = cp.QueryRanges
+			logger.Infof("Resume query ranges from checkpoint: %s", queryRanges)
+		} else {
+			if queryRanges, err = getInitQueryRange(client.Db, opts, handle); err != nil {
+				return QueryRange{}, false, nil, nil, err
+			}
+			logger.Infof("Init query ranges: %s", queryRanges)
+		}
+
+		saveProgress := func(pending []QueryRange, cur QueryRange, isConsist bool) error {
+			return opts.checkpoint.update(cp, func(t *tableCheckpoint) {
+				t.QueryRanges, t.CurRange, t.CurRangeIsConsist = pending, cur, isConsist
+			})
+		}
+		curRange, curRangeIsConsist, err = bisectQueryRanges(client.Db, opts, handle, "", queryRanges, saveProgress)
+		if err != nil {
+			return curRange, false, nil, nil, err
+		}
+
+		if !opts.forceCheckByKey && curRangeIsConsist {
+			err = opts.checkpoint.update(cp, func(t *tableCheckpoint) { t.Stage = stageDone })
+			return curRange, true, nil, nil, err
+		}
+
+		// else force check by key or curRange is not consist
+		logger.Infof("Checking the rows of Region with left boundary=%s", curRange.lowerString())
+		checkKey = tidb.NewTableRowAsKey(tableID, curRange.min)
+		if handle.isCommon() {
+			if curRange.minInf {
+				checkKey = tidb.NewTableStartAsKey(tableID)
+			} else if checkKey, err = handle.toKey(tableID, curRange.minTuple); err != nil {
+				return curRange, curRangeIsConsist, nil, nil, err
+			}
+		}
+		err = opts.checkpoint.update(cp, func(t *tableCheckpoint) {
+			t.Stage, t.NextKey = stageRegion, checkKey.GetPDKey()
+		})
+		if err != nil {
+			return curRange, curRangeIsConsist, nil, nil, err
+		}
+	}
+
+	pdClient, err := newPDClient(opts.tidb, client)
+	if err != nil {
+		return curRange, curRangeIsConsist, nil, nil, err
+	}
+	logger.Debugf("table id: %d, min: %s", tableID, checkKey.GetPDKey())
+
+	var (
+		inconsistRegions []pd.Region
+		skippedRegions   []skippedRegion
+	)
+	if opts.concurrency > 1 || opts.rateLimit > 0 {
+		inconsistRegions, skippedRegions, err = checkRowsByKeyConcurrently(client.Db, opts, &pdClient, handle, tableID, checkKey, cp)
+	} else {
+		inconsistRegions, skippedRegions, err = checkRowsByKey(client.Db, opts, &pdClient, handle, tableID, checkKey, cp)
+	}
+	if err != nil {
+		return curRange, curRangeIsConsist, inconsistRegions, skippedRegions, err
+	}
+	err = opts.checkpoint.update(cp, func(t *tableCheckpoint) {
+		t.Stage, t.InconsistRegions, t.SkippedRegions = stageDone, inconsistRegions, skippedRegions
+	})
+	return curRange, curRangeIsConsist, inconsistRegions, skippedRegions, err
+}
 
+func newPDClient(opts tidb.TiDBClientOpts, client *tidb.Client) (pd.Client, error) {
+	return pd.NewPDClientFromTiDB(client, opts)
+}
+
+// bisectQueryRanges checks the num of rows of the query ranges between TiKV and
+// TiFlash. Once a range is not consistent, it is split in the middle to narrow
+// down the inconsistent range. It returns the last checked range and whether
+// it is consistent. If tikvIndex is not empty, the rows on TiKV are counted by
+// scanning the index. If saveProgress is not nil, it is called with the pending
+// query ranges after checking each range.
+func bisectQueryRanges(db *sql.DB, opts checkRowsOpts, handle tableHandle, tikvIndex string, queryRanges []QueryRange,
+	saveProgress func(pending []QueryRange, cur QueryRange, isConsist bool) error) (QueryRange, bool, error) {
 	var (
 		curRange          QueryRange
 		curRangeIsConsist bool
@@ -81,10 +610,29 @@ func checkRows(opts checkRowsOpts) error {
 		min, max := queryRanges[0].min, queryRanges[0].max
 		queryRanges = queryRanges[1:]
 
-		if ok, err := haveConsistNumOfRows(client.Db, opts.dbName, opts.tableName, opts.rowIdColName, curRange, opts.numReplica); err != nil {
-			return err
-		} else if ok {
+		tikv, tiflash, err := getNumOfRowsOnEngines(opts.out, db, opts.tableRef(), handle, tikvIndex, opts.checksumExpr, curRange, opts.numReplica)
+		if err != nil {
+			return curRange, false, err
+		} else if tikv == tiflash {
 			curRangeIsConsist = true
+		} else if handle.isCommon() {
+			queryRanges = nil
+			// split the range by the handle of the row in the middle
+			engine, numRows := "tikv", tikv.count
+			if tiflash.count > tikv.count {
+				engine, numRows = "tiflash", tiflash.count
+			}
+			if numRows > uint64(opts.minNumInRange) {
+				mid, err := getHandleAtOffset(db, opts.tableRef(), handle, engine, curRange, numRows/2)
+				if err != nil {
+					return curRange, false, err
+				}
+				queryRanges = append(queryRanges, NewTupleRange(curRange.minTuple, mid), NewTupleRange(mid, curRange.maxTuple))
+				logger.Debugf("New query ranges: %v", queryRanges)
+			} else {
+				logger.Debugf("Skip generating new query range, current num of rows=%d", numRows)
+			}
+			curRangeIsConsist = false
 		} else {
 			queryRanges = nil
 			nids := max - min
@@ -94,59 +642,54 @@ func checkRows(opts checkRowsOpts) error {
 				if mid > min && mid < max {
 					queryRanges = append(queryRanges, NewMinMax(min, mid), NewMinMax(mid, max))
 				}
-				fmt.Printf("New query ranges: %v\n", queryRanges)
+				logger.Debugf("New query ranges: %v", queryRanges)
 			} else {
-				fmt.Printf("Skip generating new query range, current max-min=%d-%d=%d\n", max, min, nids)
+				logger.Debugf("Skip generating new query range, current max-min=%d-%d=%d", max, min, nids)
 			}
 			curRangeIsConsist = false
 		}
+		if saveProgress != nil {
+			if err = saveProgress(queryRanges, curRange, curRangeIsConsist); err != nil {
+				return curRange, curRangeIsConsist, err
+			}
+		}
 	}
+	return curRange, curRangeIsConsist, nil
+}
 
-	if !opts.forceCheckByKey && curRangeIsConsist {
-		return nil
-	}
-
-	// else force check by key or curRange is not consist
-	fmt.Printf("\n========\nChecking the rows of Region with left boundary=%d\n", curRange.min)
-	pdInstances, err := client.GetInstances("pd")
-	if err != nil {
-		return err
-	}
-	pdClient := pd.NewPDClient(pdInstances[0]) // FIXME: can not get instances
+// sqlConn is the TiDB connection pool or a dedicated connection to TiDB
+type sqlConn interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
 
-	tableID, err := client.GetTableID(opts.dbName, opts.tableName)
-	if err != nil {
-		return err
+// tableRef returns the table (or the partition) to query in SQL
+func (opts *checkRowsOpts) tableRef() string {
+	if opts.partition == "" {
+		return fmt.Sprintf("`%s`.`%s`", opts.dbName, opts.tableName)
 	}
-	checkKey := tidb.NewTableRowAsKey(tableID, curRange.min)
-	fmt.Printf("table id: %d, min: %s\n", tableID, checkKey.GetPDKey())
-
-	err = checkRowsByKey(client.Db, opts, &pdClient, checkKey)
-
-	return err
+	return fmt.Sprintf("`%s`.`%s` partition(`%s`)", opts.dbName, opts.tableName, opts.partition)
 }
 
 func setEngine(db *sql.DB, engine string) error {
 	sql := "set tidb_isolation_read_engines=" + engine
+	defer tidb.LogSQL(sql, "", time.Now())
 	_, err := db.Exec(sql)
 	return err
 }
 
 func setEngineOnTxn(txn *sql.Tx, engine string) error {
 	sql := "set tidb_isolation_read_engines=" + engine
+	defer tidb.LogSQL(sql, "", time.Now())
 	_, err := txn.Exec(sql)
 	return err
 }
 
-func getMinMaxTiDBRowID(db *sql.DB, database, table string, rowIdColName string, engine string) (int64, int64, error) {
+func getMinMaxTiDBRowID(db *sql.DB, table string, rowIdColName string, engine string) (int64, int64, error) {
 	if err := setEngine(db, engine); err != nil {
 		return 0, 0, err
 	}
-	sql := fmt.Sprintf("select min(%s), max(%s) from `%s`.`%s`", rowIdColName, rowIdColName, database, table)
-	defer func(start time.Time) {
-		elapsed := time.Since(start)
-		fmt.Printf("%s => %dms (%s)\n", sql, elapsed.Milliseconds(), engine)
-	}(time.Now())
+	sql := fmt.Sprintf("select min(%s), max(%s) from %s", rowIdColName, rowIdColName, table)
+	defer tidb.LogSQL(sql, engine, time.Now())
 
 	rows, err := db.Query(sql)
 	if err != nil {
@@ -162,25 +705,81 @@ func getMinMaxTiDBRowID(db *sql.DB, database, table string, rowIdColName string,
 	return minRowID, maxRowID, err
 }
 
-func getNumOfRows(txn *sql.Tx, database, table, rowIdColName string, engine string, checkRange QueryRange) (uint64, error) {
+func getNumOfRows(txn *sql.Tx, table string, handle tableHandle, index string, checksumExpr string, engine string, checkRange QueryRange) (rowsSummary, error) {
+	var summary rowsSummary
 	if err := setEngineOnTxn(txn, engine); err != nil {
-		return 0, err
+		return summary, err
 	}
-	sql := fmt.Sprintf("select count(*) from `%s`.`%s` %s", database, table, checkRange.toWhereFilter(rowIdColName))
-	defer func(start time.Time) {
-		elapsed := time.Since(start)
-		fmt.Printf("%s => %dms (%s)\n", sql, elapsed.Milliseconds(), engine)
-	}(time.Now())
+	indexHint := ""
+	if index != "" {
+		indexHint = fmt.Sprintf(" force index(`%s`)", index)
+	}
+	fields := "count(*)"
+	if checksumExpr != "" {
+		fields += ", " + checksumExpr
+	}
+	sql := fmt.Sprintf("select %s from %s%s %s", fields, table, indexHint, checkRange.toWhereFilter(handle))
+	defer tidb.LogSQL(sql, engine, time.Now())
 
 	rows, err := txn.Query(sql)
 	if err != nil {
-		return 0, err
+		return summary, err
 	}
-	var count uint64
+	defer rows.Close()
 	for rows.Next() {
-		rows.Scan(&count)
+		if checksumExpr != "" {
+			err = rows.Scan(&summary.count, &summary.checksum)
+		} else {
+			err = rows.Scan(&summary.count)
+		}
+		if err != nil {
+			return summary, err
+		}
 	}
-	return count, nil
+	return summary, rows.Err()
+}
+
+// getHandleAtOffset returns the handle values of the row at `offset` in the range
+// ordered by the handle columns. The engine is set in the same transaction as
+// the query, so the offset is applied to the rows of the engine.
+func getHandleAtOffset(db sqlConn, table string, handle tableHandle, engine string, checkRange QueryRange, offset uint64) ([]string, error) {
+	txn, err := db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return nil, err
+	}
+	// Only reading, nothing to commit
+	defer txn.Rollback()
+	if err = setEngineOnTxn(txn, engine); err != nil {
+		return nil, err
+	}
+	vals := make([]sql.RawBytes, len(handle.commonCols))
+	sql := fmt.Sprintf("select %s from %s %s order by %s limit 1 offset %d",
+		handle.String(), table, checkRange.toWhereFilter(handle), handle.String(), offset)
+	defer tidb.LogSQL(sql, engine, time.Now())
+
+	rows, err := txn.Query(sql)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	dest := make([]interface{}, len(vals))
+	for i := range vals {
+		dest[i] = &vals[i]
+	}
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("no row at offset %d of range %s", offset, checkRange.String())
+	}
+	if err = rows.Scan(dest...); err != nil {
+		return nil, err
+	}
+	tuple := make([]string, 0, len(vals))
+	for _, v := range vals {
+		tuple = append(tuple, string(v))
+	}
+	return tuple, nil
 }
 
 type QueryRange struct {
@@ -188,6 +787,11 @@ type QueryRange struct {
 	max    int64
 	minInf bool
 	maxInf bool
+	// For the tables with common handle, the bounds are the tuples of the
+	// clustered primary key values instead of min and max
+	isTuple  bool
+	minTuple []string
+	maxTuple []string
 }
 
 func NewMinMax(min, max int64) QueryRange {
@@ -206,30 +810,67 @@ func NewMinMaxTo(max int64) QueryRange {
 	return QueryRange{max: max, minInf: true}
 }
 
-func (m QueryRange) String() string {
-	var buffer bytes.Buffer
-	buffer.WriteString("[")
+// NewTupleRange returns the range of common handle, an empty tuple means
+// the bound is infinite.
+func NewTupleRange(minTuple, maxTuple []string) QueryRange {
+	return QueryRange{isTuple: true, minTuple: minTuple, maxTuple: maxTuple, minInf: len(minTuple) == 0, maxInf: len(maxTuple) == 0}
+}
+
+func tupleString(tuple []string) string {
+	vals := make([]string, 0, len(tuple))
+	for _, v := range tuple {
+		vals = append(vals, strconv.Quote(v))
+	}
+	return "(" + strings.Join(vals, ", ") + ")"
+}
+
+func (m QueryRange) lowerString() string {
 	if m.minInf {
-		buffer.WriteString("-Inf")
-	} else {
-		buffer.WriteString(strconv.FormatInt(m.min, 10))
+		return "-Inf"
+	} else if m.isTuple {
+		return tupleString(m.minTuple)
 	}
-	buffer.WriteString(", ")
+	return strconv.FormatInt(m.min, 10)
+}
+
+func (m QueryRange) upperString() string {
 	if m.maxInf {
-		buffer.WriteString("+Inf")
-	} else {
-		buffer.WriteString(strconv.FormatInt(m.max, 10))
+		return "+Inf"
+	} else if m.isTuple {
+		return tupleString(m.maxTuple)
 	}
+	return strconv.FormatInt(m.max, 10)
+}
+
+func (m QueryRange) String() string {
+	var buffer bytes.Buffer
+	buffer.WriteString("[")
+	buffer.WriteString(m.lowerString())
+	buffer.WriteString(", ")
+	buffer.WriteString(m.upperString())
 	buffer.WriteString(")")
 	return buffer.String()
 }
 
-func (m *QueryRange) toWhereFilter(rowIdColName string) string {
+func (m *QueryRange) toWhereFilter(handle tableHandle) string {
 	var buffer bytes.Buffer
 	if m.minInf && m.maxInf {
 		return buffer.String()
 	}
 	buffer.WriteString("where ")
+	if m.isTuple {
+		if !m.minInf {
+			buffer.WriteString(handle.tupleCompare(m.minTuple, ">="))
+			if !m.maxInf {
+				buffer.WriteString(" and ")
+			}
+		}
+		if !m.maxInf {
+			buffer.WriteString(handle.tupleCompare(m.maxTuple, "<"))
+		}
+		return buffer.String()
+	}
+	rowIdColName := handle.intColName
 	if !m.minInf {
 		buffer.WriteString(strconv.FormatInt(m.min, 10))
 		buffer.WriteString(" <= " + rowIdColName)
@@ -244,7 +885,10 @@ func (m *QueryRange) toWhereFilter(rowIdColName string) string {
 	return buffer.String()
 }
 
-func getCheckRangeFromRegion(region *pd.Region) (QueryRange, error) {
+func getCheckRangeFromRegion(region *pd.Region, handle tableHandle, tableID int64) (QueryRange, error) {
+	if handle.isCommon() {
+		return getCheckTupleRangeFromRegion(region, handle, tableID)
+	}
 	l, _ := tidb.FromPDKey(region.StartKey)
 	r, _ := tidb.FromPDKey(region.EndKey)
 	// fmt.Printf("low:%s, high:%s\n", l.GetPDKey(), r.GetPDKey())
@@ -256,6 +900,9 @@ func getCheckRangeFromRegion(region *pd.Region) (QueryRange, error) {
 	if err != nil {
 		return QueryRange{}, err
 	}
+	if lRow.IsCommonHandle() || rRow.IsCommonHandle() {
+		return QueryRange{}, fmt.Errorf("the boundary of Region %d is not a row key of int handle, %v, %v", region.Id, lRow, rRow)
+	}
 	// fmt.Printf("low:%v, high:%v\n", lRow, rRow)
 	var queryRange QueryRange
 	if lRow.Status == tidb.MinInf && rRow.Status == tidb.MaxInf {
@@ -271,36 +918,104 @@ func getCheckRangeFromRegion(region *pd.Region) (QueryRange, error) {
 	return queryRange, nil
 }
 
-func haveConsistNumOfRows(db *sql.DB, database, table, rowIdColName string, queryRange QueryRange, numCheckTimes int) (bool, error) {
+// decodeCommonHandleBoundary decodes the Region boundary into the common handle
+// values. The boundaries that are out of the table are regarded as -Inf or +Inf.
+func decodeCommonHandleBoundary(pdKey string, handle tableHandle, tableID int64) ([]string, error) {
+	key, err := tidb.FromPDKey(pdKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(key.GetBytes()) == 0 {
+		return nil, nil
+	}
+	if keyTableID, err := key.GetTableID(); err != nil {
+		return nil, err
+	} else if keyTableID != tableID {
+		return nil, nil
+	}
+	row, err := key.GetTableRowAsCommonHandle()
+	if err != nil {
+		return nil, err
+	}
+	if row.Status != 0 {
+		return nil, nil
+	}
+	tuple := make([]string, 0, row.CommonHandle.NumCols())
+	for i, d := range row.CommonHandle.Datums() {
+		v, err := handle.fromDatum(i, d)
+		if err != nil {
+			return nil, err
+		}
+		tuple = append(tuple, v)
+	}
+	return tuple, nil
+}
+
+func getCheckTupleRangeFromRegion(region *pd.Region, handle tableHandle, tableID int64) (QueryRange, error) {
+	lTuple, err := decodeCommonHandleBoundary(region.StartKey, handle, tableID)
+	if err != nil {
+		return QueryRange{}, err
+	}
+	rTuple, err := decodeCommonHandleBoundary(region.EndKey, handle, tableID)
+	if err != nil {
+		return QueryRange{}, err
+	}
+	return NewTupleRange(lTuple, rTuple), nil
+}
+
+func haveConsistRows(out io.Writer, db sqlConn, table string, handle tableHandle, checksumExpr string, queryRange QueryRange, numCheckTimes int) (bool, error) {
+	tikv, tiflash, err := getNumOfRowsOnEngines(out, db, table, handle, "", checksumExpr, queryRange, numCheckTimes)
+	return tikv == tiflash, err
+}
+
+// rowsSummary is the num of rows in a range, and the checksum of the rows if
+// comparing by checksum
+type rowsSummary struct {
+	count    uint64
+	checksum uint64
+}
+
+// getNumOfRowsOnEngines returns the num of rows in the range on TiKV and TiFlash.
+// If tikvIndex is not empty, the rows on TiKV are counted by scanning the index.
+// If checksumExpr is not empty, the checksum of rows are also returned.
+func getNumOfRowsOnEngines(out io.Writer, db sqlConn, table string, handle tableHandle, tikvIndex string, checksumExpr string, queryRange QueryRange, numCheckTimes int) (rowsSummary, rowsSummary, error) {
 	var (
-		numRowsTiKV    uint64 = 0
-		numRowsTiFlash uint64 = 0
-		err            error
+		tikv    rowsSummary
+		tiflash rowsSummary
+		err     error
 	)
 
-	// Compare the tikv and tiflash # of rows under the same transaction
-	txn, err := db.Begin()
+	// Compare the tikv and tiflash # of rows under the same transaction. If
+	// `tidb_snapshot` is set, all the reads are at the snapshot instead.
+	txn, err := db.BeginTx(context.Background(), nil)
 	if err != nil {
-		return false, err
+		return tikv, tiflash, err
 	}
-	for i := 0; i < numCheckTimes && numRowsTiKV == numRowsTiFlash; i++ {
-		if numRowsTiKV, err = getNumOfRows(txn, database, table, rowIdColName, "tikv", queryRange); err != nil {
-			return false, err
+	for i := 0; i < numCheckTimes && tikv == tiflash; i++ {
+		if tikv, err = getNumOfRows(txn, table, handle, tikvIndex, checksumExpr, "tikv", queryRange); err != nil {
+			txn.Rollback()
+			return tikv, tiflash, err
 		}
-		if numRowsTiFlash, err = getNumOfRows(txn, database, table, rowIdColName, "tiflash", queryRange); err != nil {
-			return false, err
+		if tiflash, err = getNumOfRows(txn, table, handle, "", checksumExpr, "tiflash", queryRange); err != nil {
+			txn.Rollback()
+			return tikv, tiflash, err
 		}
 	}
 	if err = txn.Commit(); err != nil {
-		fmt.Printf("Ignore error on commit txn, %v\n", err)
+		logger.Warnf("Ignore error on commit txn, %v", err)
 	}
 
-	if numRowsTiKV != numRowsTiFlash {
-		fmt.Printf("Range %s, num of rows: tikv %d, tiflash %d. FAIL\n", queryRange.String(), numRowsTiKV, numRowsTiFlash)
+	result := "OK"
+	if tikv != tiflash {
+		result = "FAIL"
+	}
+	if checksumExpr != "" {
+		fmt.Fprintf(out, "Range %s, num of rows: tikv %d, tiflash %d, checksum: tikv %d, tiflash %d. %s\n",
+			queryRange.String(), tikv.count, tiflash.count, tikv.checksum, tiflash.checksum, result)
 	} else {
-		fmt.Printf("Range %s, num of rows: tikv %d, tiflash %d. OK\n", queryRange.String(), numRowsTiKV, numRowsTiFlash)
+		fmt.Fprintf(out, "Range %s, num of rows: tikv %d, tiflash %d. %s\n", queryRange.String(), tikv.count, tiflash.count, result)
 	}
-	return numRowsTiKV == numRowsTiFlash, err
+	return tikv, tiflash, nil
 }
 
 func min(x, y int64) int64 {
@@ -317,27 +1032,32 @@ func max(x, y int64) int64 {
 	return y
 }
 
-func getInitQueryRange(db *sql.DB, opts checkRowsOpts) ([]QueryRange, error) {
+func getInitQueryRange(db *sql.DB, opts checkRowsOpts, handle tableHandle) ([]QueryRange, error) {
 	var queryRanges []QueryRange
-	if opts.queryLowerBound == 0 && opts.queryUpperBound == 0 {
-		tikvMinID, tikvMaxID, err := getMinMaxTiDBRowID(db, opts.dbName, opts.tableName, opts.rowIdColName, "tikv")
+	if handle.isCommon() {
+		if opts.queryLowerBound != 0 || opts.queryUpperBound != 0 {
+			logger.Warnf("lower_bound and upper_bound are ignored for the table with common handle")
+		}
+		queryRanges = append(queryRanges, NewTupleRange(nil, nil))
+	} else if opts.queryLowerBound == 0 && opts.queryUpperBound == 0 {
+		tikvMinID, tikvMaxID, err := getMinMaxTiDBRowID(db, opts.tableRef(), opts.rowIdColName, "tikv")
 		if err != nil {
 			return nil, err
 		}
-		tiflashMinID, tiflashMaxID, err := getMinMaxTiDBRowID(db, opts.dbName, opts.tableName, opts.rowIdColName, "tiflash")
+		tiflashMinID, tiflashMaxID, err := getMinMaxTiDBRowID(db, opts.tableRef(), opts.rowIdColName, "tiflash")
 		if err != nil {
 			return nil, err
 		}
 
-		fmt.Printf("RowID range: [%d, %d] (tikv)\n", tikvMinID, tikvMaxID)
-		fmt.Printf("RowID range: [%d, %d] (tiflash)\n", tiflashMinID, tiflashMaxID)
+		fmt.Fprintf(opts.out, "RowID range: [%d, %d] (tikv)\n", tikvMinID, tikvMaxID)
+		fmt.Fprintf(opts.out, "RowID range: [%d, %d] (tiflash)\n", tiflashMinID, tiflashMaxID)
 		allMinID := min(tikvMinID, tiflashMinID)
 		allMaxID := max(tikvMaxID, tiflashMaxID)
 		if tikvMinID != tiflashMinID {
-			fmt.Printf("tikv min id %d != tiflash min id %d, use %d as begin\n", tikvMinID, tiflashMinID, allMinID)
+			fmt.Fprintf(opts.out, "tikv min id %d != tiflash min id %d, use %d as begin\n", tikvMinID, tiflashMinID, allMinID)
 		}
 		if tikvMaxID != tiflashMaxID {
-			fmt.Printf("tikv max id %d != tiflash max id %d, use %d as end\n", tikvMaxID, tiflashMaxID, allMaxID)
+			fmt.Fprintf(opts.out, "tikv max id %d != tiflash max id %d, use %d as end\n", tikvMaxID, tiflashMaxID, allMaxID)
 		}
 
 		queryRanges = append(queryRanges, NewMinMax(allMinID, allMaxID+1))
@@ -351,48 +1071,132 @@ func getInitQueryRange(db *sql.DB, opts checkRowsOpts) ([]QueryRange, error) {
 	return queryRanges, nil
 }
 
-func checkRowsByKey(db *sql.DB, opts checkRowsOpts, pdClient *pd.Client, key tidb.TiKVKey) error {
+func isEndOfTable(key tidb.TiKVKey, handle tableHandle, tableID int64) (bool, error) {
+	if handle.isCommon() {
+		if len(key.GetBytes()) == 0 {
+			return true, nil
+		}
+		keyTableID, err := key.GetTableID()
+		return keyTableID != tableID, err
+	}
+	tableRow, err := key.GetTableRow()
+	if err != nil {
+		return false, err
+	}
+	return tableRow.Status == tidb.MaxInf, nil
+}
+
+// checkRowsByKey checks the num of rows of the Regions from the key, and
+// returns the Regions that have not consist num of rows and the Regions that
+// are skipped.
+func checkRowsByKey(db *sql.DB, opts checkRowsOpts, pdClient *pd.Client, handle tableHandle, tableID int64, key tidb.TiKVKey, cp *tableCheckpoint) ([]pd.Region, []skippedRegion, error) {
 	numSuccess := 0
+	var (
+		inconsistRegions []pd.Region
+		skippedRegions   []skippedRegion
+	)
+	if cp != nil {
+		// Continue with the progress in checkpoint
+		numSuccess, inconsistRegions, skippedRegions = cp.NumSuccess, cp.InconsistRegions, cp.SkippedRegions
+	}
 	for {
-		tableRow, err := key.GetTableRow()
-		if err != nil {
-			return err
-		} else if tableRow.Status == tidb.MaxInf {
+		if isEnd, err := isEndOfTable(key, handle, tableID); err != nil {
+			return inconsistRegions, skippedRegions, err
+		} else if isEnd {
 			// meet the end of this table, done
 			break
 		}
 
 		region, err := pdClient.GetRegionByKey(key)
-		if err != nil {
-			return err
+		if errors.Is(err, pd.ErrRegionNotFound) {
+			return inconsistRegions, skippedRegions, fmt.Errorf("no Region contains the key %s, run `check region-chain` to find the holes between Regions: %w", key.GetPDKey(), err)
+		} else if err != nil {
+			return inconsistRegions, skippedRegions, err
 		}
-		queryRange, err := getCheckRangeFromRegion(&region)
+		queryRange, err := getCheckRangeFromRegion(&region, handle, tableID)
 		if err != nil {
-			return err
+			return inconsistRegions, skippedRegions, err
 		}
-		fmt.Printf("Config: regionsLimit=%d,numSuccess=%d\n", opts.numRegionsLimit, numSuccess)
-		fmt.Printf("The query range of Region %d is %s\n", region.Id, queryRange.String())
-		isConsist, err := haveConsistNumOfRows(db, opts.dbName, opts.tableName, opts.rowIdColName, queryRange, opts.numReplica)
-		if err != nil {
-			return err
-		}
-		if isConsist {
-			numSuccess += 1
-			fmt.Printf("Region %v have consist num of rows\n", region)
-			// If numRegionsLimit <= 0, continue to check all regions
-			if opts.numRegionsLimit > 0 && numSuccess > int(opts.numRegionsLimit) {
-				break
-			}
+		logger.Debugf("Config: regionsLimit=%d,numSuccess=%d", opts.numRegionsLimit, numSuccess)
+		logger.Debugf("The query range of Region %d is %s", region.Id, queryRange.String())
+		if reason := getSkipReason(&region); reason != "" {
+			// Neither consist nor inconsist, keep numSuccess as it is
+			fmt.Fprintf(opts.out, "Skip checking Region %v, %s\n", region, reason)
+			skippedRegions = append(skippedRegions, skippedRegion{Region: region, Reason: reason})
 		} else {
-			numSuccess = 0
-			fmt.Printf("Region %v have not consist num of rows\n", region)
-			for _, storeID := range region.GetLearnerStoreIDs() {
-				fmt.Printf("operator add remove-peer %d %d\n", region.Id, storeID)
+			isConsist, err := haveConsistRows(opts.out, db, opts.tableRef(), handle, opts.checksumExpr, queryRange, opts.numReplica)
+			if err != nil {
+				return inconsistRegions, skippedRegions, err
+			}
+			if !isConsist && opts.compareMode == compareModeFull {
+				if _, err = compareRowsFully(opts.out, db, opts, handle, queryRange, opts.maxDiffs); err != nil {
+					return inconsistRegions, skippedRegions, err
+				}
 			}
+			if countRegionCheckResult(opts, region, isConsist, &numSuccess, &inconsistRegions) {
+				break
+			}
+		}
+		err = opts.checkpoint.update(cp, func(t *tableCheckpoint) {
+			t.NextKey, t.NumSuccess = region.EndKey, numSuccess
+			t.InconsistRegions, t.SkippedRegions = inconsistRegions, skippedRegions
+		})
+		if err != nil {
+			return inconsistRegions, skippedRegions, err
 		}
 		if key, err = tidb.FromPDKey(region.EndKey); err != nil {
-			return err
+			return inconsistRegions, skippedRegions, err
+		}
+	}
+	return inconsistRegions, skippedRegions, nil
+}
+
+// skippedRegion is a Region not checked, see getSkipReason
+type skippedRegion struct {
+	Region pd.Region `json:"region"`
+	Reason string    `json:"reason"`
+}
+
+// getSkipReason returns the reason to skip checking the Region, or empty if it
+// should be checked. The data on TiFlash peers that are pending or down is not
+// up to date, reading them may block or report false inconsistency.
+func getSkipReason(region *pd.Region) string {
+	unhealthy := region.GetUnhealthyLearners()
+	if len(unhealthy) == 0 {
+		return ""
+	}
+	descs := make([]string, 0, len(unhealthy))
+	for _, p := range unhealthy {
+		state := "pending"
+		if region.IsPeerDown(p.Id) {
+			state = "down"
 		}
+		descs = append(descs, fmt.Sprintf("peer %d on store %d is %s", p.Id, p.StoreId, state))
+	}
+	return "TiFlash " + strings.Join(descs, ", ")
+}
+
+// countRegionCheckResult prints the result of checking the Region and counts
+// it. It returns true if reaching the limit of num of consist Regions.
+func countRegionCheckResult(opts checkRowsOpts, region pd.Region, isConsist bool, numSuccess *int, inconsistRegions *[]pd.Region) bool {
+	printRegionCheckResult(opts.out, region, isConsist)
+	if isConsist {
+		*numSuccess += 1
+		// If numRegionsLimit <= 0, continue to check all regions
+		return opts.numRegionsLimit > 0 && *numSuccess > int(opts.numRegionsLimit)
+	}
+	*numSuccess = 0
+	*inconsistRegions = append(*inconsistRegions, region)
+	return false
+}
+
+func printRegionCheckResult(out io.Writer, region pd.Region, isConsist bool) {
+	if isConsist {
+		fmt.Fprintf(out, "Region %v have consist num of rows\n", region)
+		return
+	}
+	fmt.Fprintf(out, "Region %v have not consist num of rows\n", region)
+	for _, storeID := range region.GetLearnerStoreIDs() {
+		fmt.Fprintf(out, "operator add remove-peer %d %d\n", region.Id, storeID)
 	}
-	return nil
 }
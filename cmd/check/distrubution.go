@@ -3,13 +3,18 @@ package check
 import (
 	"database/sql"
 	"fmt"
+	"io"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/format"
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/logger"
 	"github.com/JaySon-Huang/tiflash-ctl/pkg/options"
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/pd"
 	"github.com/JaySon-Huang/tiflash-ctl/pkg/tidb"
-	"github.com/olekukonko/tablewriter"
 	"github.com/spf13/cobra"
 )
 
@@ -19,6 +24,8 @@ func NewDistributionCmd() *cobra.Command {
 		Use:   "dist",
 		Short: "Check the Region distribution of a table",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			opt.output = options.GetOutput(cmd)
+			opt.out = format.TextWriter(opt.output)
 			return checkDistribution(cmd, opt)
 		},
 	}
@@ -37,17 +44,20 @@ type checkDistributionOpts struct {
 	dbName    string
 	tableName string
 	dryRun    bool
+	output    string
+	// Where the results are printed as text, see format.TextWriter
+	out io.Writer
 }
 
 func checkDistribution(cmd *cobra.Command, opts checkDistributionOpts) error {
 	if len(opts.dbName) == 0 || len(opts.tableName) == 0 {
-		fmt.Println("You must set the database and table name")
+		logger.Errorf("You must set the database and table name")
 		return cmd.Help()
 	}
 
 	if opts.dryRun {
 		sql := getDistQuery(opts.dbName, opts.tableName)
-		fmt.Println(strings.ReplaceAll(strings.ReplaceAll(sql, "\t", ""), "\n", " "))
+		fmt.Fprintln(opts.out, strings.ReplaceAll(strings.ReplaceAll(sql, "\t", ""), "\n", " "))
 		return nil
 	}
 
@@ -57,53 +67,85 @@ func checkDistribution(cmd *cobra.Command, opts checkDistributionOpts) error {
 	}
 	defer client.Close()
 
-	dists, err := execGetDist(client.Db, opts.dbName, opts.tableName)
+	pdClient, err := newPDClient(opts.tidb, &client)
+	if err != nil {
+		return err
+	}
+	dists, err := execGetDist(client.Db, &pdClient, opts.dbName, opts.tableName)
 	if err != nil {
 		return err
 	}
 
 	avgTiKVLeaderRegions, avgTiKVFollowerRegions, avgTiFlashRegions := getDistAvg(dists)
 
-	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"store type", "store id", "address", "is leader", "num regions", "diff per"})
-
+	report := &distReport{Database: opts.dbName, Table: opts.tableName, Stores: []distReportRow{}}
 	for _, v := range dists {
 		if v.storeType == "tikv" {
 			if v.isLeader {
-				table.Append(v.toRow(avgTiKVLeaderRegions))
+				report.Stores = append(report.Stores, v.toReportRow(avgTiKVLeaderRegions))
 			} else {
-				table.Append(v.toRow(avgTiKVFollowerRegions))
+				report.Stores = append(report.Stores, v.toReportRow(avgTiKVFollowerRegions))
 			}
 		} else if v.storeType == "tiflash" {
-			table.Append(v.toRow(avgTiFlashRegions))
+			report.Stores = append(report.Stores, v.toReportRow(avgTiFlashRegions))
 		}
 	}
-	table.Render() // Send output
+	if format.IsStructured(opts.output) {
+		return format.Render(os.Stdout, opts.output, report)
+	}
+	return format.Render(os.Stdout, format.Text, report)
+}
+
+// distReport is the result of `check dist` for the structured output
+type distReport struct {
+	Database string          `json:"database"`
+	Table    string          `json:"table"`
+	Stores   []distReportRow `json:"stores"`
+}
+
+type distReportRow struct {
+	StoreType     string `json:"store_type"`
+	StoreID       int64  `json:"store_id"`
+	Address       string `json:"address"`
+	IsLeader      bool   `json:"is_leader"`
+	NumRegions    int64  `json:"num_regions"`
+	RegionSizeMiB int64  `json:"region_size_mib"`
+	// The percentage of num of Regions different from the average of the same
+	// kind of stores
+	DiffPercent float32 `json:"diff_percent"`
+}
 
-	return nil
+func (r *distReport) Header() []string {
+	return []string{"store type", "store id", "address", "is leader", "num regions", "region size (MiB)", "diff per"}
+}
+
+func (r *distReport) Rows() [][]string {
+	rows := make([][]string, 0, len(r.Stores))
+	for _, d := range r.Stores {
+		rows = append(rows, []string{
+			d.StoreType,
+			strconv.FormatInt(d.StoreID, 10),
+			d.Address,
+			strconv.FormatBool(d.IsLeader),
+			strconv.FormatInt(d.NumRegions, 10),
+			strconv.FormatInt(d.RegionSizeMiB, 10),
+			fmt.Sprintf("%6.2f%%", d.DiffPercent),
+		})
+	}
+	return rows
 }
 
 func getDistQuery(database, table string) string {
-	return fmt.Sprintf(`select c.type, a.store_id, a.address, a.db_name, a.table_name, a.is_leader, a.cnt 
-from (
-	select r.db_name, r.table_name, r.store_id, s.address, r.is_leader, count(*) as cnt
-	from (
-		select s.region_id, s.db_name, s.table_name, p.store_id, p.is_leader, p.status 
-		from
-			information_schema.tikv_region_status s,
-			information_schema.tikv_region_peers p
-		where 1=1
-			and db_name ='%s' and table_name='%s'
-			and s.region_id = p.region_id
-		order by p.store_id
-		) as r,
-		information_schema.tikv_store_status s
-	where r.store_id=s.store_id
-	group by
-		r.db_name, r.table_name, r.store_id, r.is_leader, s.address
-) a, information_schema.cluster_info c
-where c.instance = a.address
-order by c.type desc, a.store_id;`, database, table)
+	return fmt.Sprintf(`select s.db_name, s.table_name, p.store_id, p.is_leader, count(*) as cnt, sum(s.approximate_size) as size
+from
+	information_schema.tikv_region_status s,
+	information_schema.tikv_region_peers p
+where 1=1
+	and s.db_name ='%s' and s.table_name='%s'
+	and s.region_id = p.region_id
+group by
+	s.db_name, s.table_name, p.store_id, p.is_leader
+order by p.store_id;`, database, table)
 }
 
 type distribution struct {
@@ -114,34 +156,59 @@ type distribution struct {
 	tableName  string
 	isLeader   bool
 	numRegions int64
+	// The sum of approximate size of the Regions in MiB
+	regionSize int64
 }
 
-func (d *distribution) toRow(avg float32) []string {
-	s := make([]string, 0)
-	s = append(s, d.storeType)
-	s = append(s, strconv.FormatInt(d.storeId, 10))
-	s = append(s, d.address)
-	s = append(s, strconv.FormatBool(d.isLeader))
-	s = append(s, strconv.FormatInt(d.numRegions, 10))
-	diff := (float32(d.numRegions) - avg) / avg * 100
-	s = append(s, fmt.Sprintf("%6.2f%%", diff))
-
-	return s
+func (d *distribution) toReportRow(avg float32) distReportRow {
+	return distReportRow{
+		StoreType:     d.storeType,
+		StoreID:       d.storeId,
+		Address:       d.address,
+		IsLeader:      d.isLeader,
+		NumRegions:    d.numRegions,
+		RegionSizeMiB: d.regionSize,
+		DiffPercent:   (float32(d.numRegions) - avg) / avg * 100,
+	}
 }
 
-func execGetDist(db *sql.DB, database, table string) ([]distribution, error) {
+// execGetDist counts the Regions of the table on each store. The store type
+// and address are got from PD.
+func execGetDist(db *sql.DB, pdClient *pd.Client, database, table string) ([]distribution, error) {
+	stores, err := pdClient.GetStoreMap()
+	if err != nil {
+		return nil, err
+	}
 	sql := getDistQuery(database, table)
+	start := time.Now()
 	rows, err := db.Query(sql)
+	tidb.LogSQL(sql, "", start)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 	var dists []distribution
 	var dist distribution
 	for rows.Next() {
-		rows.Scan(&dist.storeType, &dist.storeId, &dist.address, &dist.dbName, &dist.tableName, &dist.isLeader, &dist.numRegions)
+		if err = rows.Scan(&dist.dbName, &dist.tableName, &dist.storeId, &dist.isLeader, &dist.numRegions, &dist.regionSize); err != nil {
+			return nil, err
+		}
+		store, ok := stores[dist.storeId]
+		if !ok {
+			// The store is tombstone or removed
+			dist.storeType, dist.address = "unknown", ""
+		} else if store.IsTiFlash() {
+			dist.storeType, dist.address = "tiflash", store.Address
+		} else {
+			dist.storeType, dist.address = "tikv", store.Address
+		}
 		dists = append(dists, dist)
 	}
-	return dists, nil
+	// TiKV stores first, then TiFlash stores
+	sort.SliceStable(dists, func(i, j int) bool {
+		return dists[i].storeType > dists[j].storeType
+	})
+	return dists, rows.Err()
 }
 
 func getDistAvg(dists []distribution) (float32, float32, float32) {
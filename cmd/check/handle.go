@@ -0,0 +1,265 @@
+package check
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/codec"
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/tidb"
+)
+
+// tableHandle describes the columns that the rows of a table are keyed by in TiKV.
+// The rows are keyed by an int handle (`_tidb_rowid` or the int primary key), or
+// by a common handle, which is the tuple of the clustered primary key columns.
+type tableHandle struct {
+	intColName string
+	commonCols []tidb.Column
+}
+
+func newIntHandle(colName string) tableHandle {
+	return tableHandle{intColName: colName}
+}
+
+func newCommonHandle(cols []tidb.Column) tableHandle {
+	return tableHandle{commonCols: cols}
+}
+
+func (h *tableHandle) isCommon() bool {
+	return len(h.commonCols) > 0
+}
+
+func (h *tableHandle) colNames() []string {
+	if !h.isCommon() {
+		return []string{h.intColName}
+	}
+	names := make([]string, 0, len(h.commonCols))
+	for _, col := range h.commonCols {
+		names = append(names, "`"+col.Name+"`")
+	}
+	return names
+}
+
+func (h *tableHandle) String() string {
+	return strings.Join(h.colNames(), ", ")
+}
+
+func isBinaryStringType(dataType string) bool {
+	switch dataType {
+	case "binary", "varbinary", "tinyblob", "blob", "mediumblob", "longblob":
+		return true
+	}
+	return false
+}
+
+func isStringType(dataType string) bool {
+	switch dataType {
+	case "char", "varchar", "tinytext", "text", "mediumtext", "longtext":
+		return true
+	}
+	return false
+}
+
+func isIntLikeType(dataType string) bool {
+	return tidb.IsIntType(dataType) || dataType == "year"
+}
+
+func isBinCollation(collation string) bool {
+	return collation == "binary" || strings.HasSuffix(collation, "_bin")
+}
+
+// getFsp returns the fractional seconds precision of time columns
+func getFsp(col tidb.Column) int {
+	if args := col.TypeArgs(); len(args) == 1 {
+		return args[0]
+	}
+	return 0
+}
+
+// getDecimalPrecision returns the precision and frac of decimal columns
+func getDecimalPrecision(col tidb.Column) (int, int) {
+	args := col.TypeArgs()
+	switch len(args) {
+	case 1:
+		return args[0], 0
+	case 2:
+		return args[0], args[1]
+	}
+	return 10, 0
+}
+
+var literalEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`, "\x00", `\0`)
+
+// toLiteral returns the SQL literal of the value of i-th handle column
+func (h *tableHandle) toLiteral(i int, val string) string {
+	col := h.commonCols[i]
+	switch {
+	case isIntLikeType(col.DataType), col.DataType == "float", col.DataType == "double", col.DataType == "decimal":
+		return val
+	case isBinaryStringType(col.DataType), col.DataType == "bit":
+		return "x'" + hex.EncodeToString([]byte(val)) + "'"
+	}
+	return "'" + literalEscaper.Replace(val) + "'"
+}
+
+// fromDatum converts the datum decoded from a row key to the value of i-th
+// handle column in the same form as it is returned by a query
+func (h *tableHandle) fromDatum(i int, d codec.Datum) (string, error) {
+	if i >= len(h.commonCols) {
+		return "", fmt.Errorf("the num of datums exceeds the num of handle columns %d", len(h.commonCols))
+	}
+	col := h.commonCols[i]
+	switch {
+	case isIntLikeType(col.DataType) && d.Kind() == codec.KindInt64:
+		return strconv.FormatInt(d.GetInt64(), 10), nil
+	case isIntLikeType(col.DataType) && d.Kind() == codec.KindUint64:
+		return strconv.FormatUint(d.GetUint64(), 10), nil
+	case (col.DataType == "float" || col.DataType == "double") && d.Kind() == codec.KindFloat64:
+		return strconv.FormatFloat(d.GetFloat64(), 'g', -1, 64), nil
+	case col.DataType == "decimal" && d.Kind() == codec.KindMysqlDecimal:
+		return d.GetMysqlDecimal(), nil
+	case col.DataType == "date" && d.Kind() == codec.KindUint64:
+		return codec.FromPackedUint(d.GetUint64()).DateString(), nil
+	case (col.DataType == "datetime" || col.DataType == "timestamp") && d.Kind() == codec.KindUint64:
+		// The timestamp is stored in UTC, the session time zone should be UTC too
+		return codec.FromPackedUint(d.GetUint64()).DatetimeString(getFsp(col)), nil
+	case col.DataType == "time" && d.Kind() == codec.KindMysqlDuration:
+		return codec.FormatDuration(d.GetMysqlDuration(), getFsp(col)), nil
+	case col.DataType == "bit" && d.Kind() == codec.KindUint64:
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], d.GetUint64())
+		return string(b[:]), nil
+	case isBinaryStringType(col.DataType) && d.Kind() == codec.KindBytes:
+		return string(d.GetBytes()), nil
+	case isStringType(col.DataType) && d.Kind() == codec.KindBytes:
+		// The key of a string with non-binary collation is the sort key
+		// instead of the original value
+		if !isBinCollation(col.Collation) {
+			return "", fmt.Errorf("can not decode column `%s` with collation %s", col.Name, col.Collation)
+		}
+		return string(d.GetBytes()), nil
+	}
+	return "", fmt.Errorf("can not decode column `%s` with type %s from %v", col.Name, col.ColumnType, d)
+}
+
+// toDatum converts the value of i-th handle column to the datum stored in row key.
+// It returns false if the datum can not be built from the value.
+func (h *tableHandle) toDatum(i int, val string) (codec.Datum, bool) {
+	col := h.commonCols[i]
+	switch {
+	case tidb.IsIntType(col.DataType) && col.IsUnsigned():
+		v, err := strconv.ParseUint(val, 10, 64)
+		return codec.NewUintDatum(v), err == nil
+	case isIntLikeType(col.DataType):
+		v, err := strconv.ParseInt(val, 10, 64)
+		return codec.NewIntDatum(v), err == nil
+	case col.DataType == "float":
+		v, err := strconv.ParseFloat(val, 32)
+		return codec.NewFloatDatum(v), err == nil
+	case col.DataType == "double":
+		v, err := strconv.ParseFloat(val, 64)
+		return codec.NewFloatDatum(v), err == nil
+	case col.DataType == "decimal":
+		precision, frac := getDecimalPrecision(col)
+		return codec.NewDecimalDatum(val, precision, frac), true
+	case col.DataType == "date" || col.DataType == "datetime" || col.DataType == "timestamp":
+		t, err := codec.ParseCoreTime(val)
+		return codec.NewUintDatum(t.ToPackedUint()), err == nil
+	case col.DataType == "time":
+		nanos, err := codec.ParseDuration(val)
+		return codec.NewDurationDatum(nanos, getFsp(col)), err == nil
+	case col.DataType == "bit":
+		var v uint64
+		for _, c := range []byte(val) {
+			v = v<<8 | uint64(c)
+		}
+		return codec.NewUintDatum(v), len(val) <= 8
+	case isBinaryStringType(col.DataType):
+		return codec.NewBytesDatum([]byte(val)), true
+	case isStringType(col.DataType) && isBinCollation(col.Collation):
+		// The padding spaces are trimmed from the key with new collation enabled
+		return codec.NewBytesDatum([]byte(strings.TrimRight(val, " "))), true
+	}
+	return codec.Datum{}, false
+}
+
+// toKey builds a row key that is not greater than the row key of the handle values.
+// Only the leading columns that can be converted to datums are encoded into the key.
+func (h *tableHandle) toKey(tableID int64, vals []string) (tidb.TiKVKey, error) {
+	datums := make([]codec.Datum, 0, len(vals))
+	for i, val := range vals {
+		d, ok := h.toDatum(i, val)
+		if !ok {
+			break
+		}
+		datums = append(datums, d)
+	}
+	commonHandle, err := tidb.NewCommonHandle(datums...)
+	if err != nil {
+		return tidb.TiKVKey{}, err
+	}
+	return tidb.NewCommonHandleRowAsKey(tableID, commonHandle), nil
+}
+
+// tupleCompare builds the lexicographic comparison between the handle columns
+// and the tuple values. `op` should be ">=" or "<".
+func (h *tableHandle) tupleCompare(vals []string, op string) string {
+	cols := h.colNames()
+	var build func(i int) string
+	build = func(i int) string {
+		lit := h.toLiteral(i, vals[i])
+		if i == len(vals)-1 {
+			return fmt.Sprintf("%s %s %s", cols[i], op, lit)
+		}
+		return fmt.Sprintf("(%s %s %s or (%s = %s and %s))", cols[i], op[:1], lit, cols[i], lit, build(i+1))
+	}
+	return build(0)
+}
+
+// orderBy returns the expressions to order the rows by the handle columns in
+// the same order as sortKey. The string columns without binary collation and
+// the enum or set columns are ordered by their bytes, which is how sortKey
+// compares them, instead of by the collation or the element index.
+func (h *tableHandle) orderBy() string {
+	if !h.isCommon() {
+		return h.String()
+	}
+	exprs := make([]string, 0, len(h.commonCols))
+	for i, col := range h.commonCols {
+		name := h.colNames()[i]
+		if (isStringType(col.DataType) && !isBinCollation(col.Collation)) || col.DataType == "enum" || col.DataType == "set" {
+			name = "binary " + name
+		}
+		exprs = append(exprs, name)
+	}
+	return strings.Join(exprs, ", ")
+}
+
+// sortKey returns a key of the handle values, which keeps the order of the
+// rows ordered by orderBy.
+func (h *tableHandle) sortKey(vals []string) []byte {
+	if !h.isCommon() {
+		// The unsigned int primary key can be larger than max int64, sort it
+		// after all the int64 values
+		if v, err := strconv.ParseInt(vals[0], 10, 64); err == nil {
+			return codec.EncodeInt([]byte{0}, v)
+		}
+		v, _ := strconv.ParseUint(vals[0], 10, 64)
+		return codec.EncodeUint([]byte{1}, v)
+	}
+	var key []byte
+	for i, val := range vals {
+		d, ok := h.toDatum(i, val)
+		if !ok {
+			d = codec.NewBytesDatum([]byte(val))
+		}
+		if encoded, err := codec.EncodeKey(key, d); err == nil {
+			key = encoded
+		} else {
+			key, _ = codec.EncodeKey(key, codec.NewBytesDatum([]byte(val)))
+		}
+	}
+	return key
+}
@@ -0,0 +1,240 @@
+package check
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/format"
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/logger"
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/options"
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/tidb"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+func NewCheckIndexCmd() *cobra.Command {
+	var opt checkIndexOpts
+	c := &cobra.Command{
+		Use:   "index",
+		Short: "Check the num of rows of indexes on TiKV with the rows on TiFlash",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opt.output = options.GetOutput(cmd)
+			opt.out = format.TextWriter(opt.output)
+			return checkIndexes(opt)
+		},
+	}
+
+	// Flags for "index"
+	options.AddTiDBConnFlags(c, &opt.tidb)
+
+	c.Flags().StringVar(&opt.dbName, "database", "", "The database name of query table")
+	c.Flags().StringVar(&opt.tableName, "table", "", "The table name of query table")
+	c.Flags().StringVar(&opt.indexName, "index", "", "Only check the index with this name (check all indexes by default)")
+	c.Flags().StringVar(&opt.partitions, "partition", "", "The comma separated partition names to check (check all partitions by default)")
+	c.Flags().IntVar(&opt.numReplica, "num_replica", 2, "The number of TiFlash replica for the query table")
+
+	c.Flags().StringVar(&opt.rowIdColName, "row_id_col_name", "_tidb_rowid", "The TiDB row id column name")
+	c.Flags().Int64Var(&opt.minNumInRange, "min_num_in_range", 1, "The minimal number of ids in a query range to search")
+	c.Flags().Uint64Var(&opt.snapshotTS, "snapshot_ts", 0, "The TSO of snapshot to check the rows at, use the current TSO by default. It should be later than the GC safe point")
+	c.Flags().Int64Var(&opt.queryLowerBound, "lower_bound", 0, "The lower bound of query (leave it to be default)")
+	c.Flags().Int64Var(&opt.queryUpperBound, "upper_bound", 0, "The upper bound of query (leave it to be default)")
+	return c
+}
+
+type checkIndexOpts struct {
+	checkRowsOpts
+	indexName string
+}
+
+type indexCheckResult struct {
+	table          tidb.PhysicalTable
+	index          tidb.Index
+	isConsist      bool
+	numRowsTiKV    uint64
+	numRowsTiFlash uint64
+	queryRange     QueryRange
+	err            error
+}
+
+func (r *indexCheckResult) toRow() []string {
+	row := []string{r.table.PartitionName, r.index.Name, fmt.Sprint(r.index.ID)}
+	if r.err != nil {
+		return append(row, "ERROR", "", "", r.err.Error())
+	}
+	status := "OK"
+	if !r.isConsist {
+		status = "FAIL"
+	}
+	return append(row, status, fmt.Sprint(r.numRowsTiKV), fmt.Sprint(r.numRowsTiFlash), r.queryRange.String())
+}
+
+// indexReport is the result of `check index` for the structured output
+type indexReport struct {
+	Database   string            `json:"database"`
+	Table      string            `json:"table"`
+	SnapshotTS uint64            `json:"snapshot_ts"`
+	Indexes    []indexReportItem `json:"indexes"`
+}
+
+type indexReportItem struct {
+	Partition   string `json:"partition,omitempty"`
+	TableID     int64  `json:"table_id"`
+	Index       string `json:"index"`
+	IndexID     int64  `json:"index_id"`
+	Status      string `json:"status"`
+	TiKVRows    uint64 `json:"tikv_rows"`
+	TiFlashRows uint64 `json:"tiflash_rows"`
+	Range       string `json:"range,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+func newIndexReport(opts checkIndexOpts, results []indexCheckResult) *indexReport {
+	report := &indexReport{Database: opts.dbName, Table: opts.tableName, SnapshotTS: opts.snapshotTS, Indexes: []indexReportItem{}}
+	for _, r := range results {
+		item := indexReportItem{
+			Partition: r.table.PartitionName,
+			TableID:   r.table.ID,
+			Index:     r.index.Name,
+			IndexID:   r.index.ID,
+		}
+		if r.err != nil {
+			item.Status, item.Error = "ERROR", r.err.Error()
+		} else {
+			item.Status = "OK"
+			if !r.isConsist {
+				item.Status = "FAIL"
+			}
+			item.TiKVRows, item.TiFlashRows, item.Range = r.numRowsTiKV, r.numRowsTiFlash, r.queryRange.String()
+		}
+		report.Indexes = append(report.Indexes, item)
+	}
+	return report
+}
+
+func (r *indexReport) Header() []string {
+	return []string{"partition", "index", "index id", "status", "tikv rows", "tiflash rows", "range", "error"}
+}
+
+func (r *indexReport) Rows() [][]string {
+	rows := make([][]string, 0, len(r.Indexes))
+	for _, i := range r.Indexes {
+		rows = append(rows, []string{i.Partition, i.Index, fmt.Sprint(i.IndexID), i.Status, fmt.Sprint(i.TiKVRows), fmt.Sprint(i.TiFlashRows), i.Range, i.Error})
+	}
+	return rows
+}
+
+func checkIndexes(opts checkIndexOpts) error {
+	client, err := newSnapshotClient(opts.tidb, &opts.snapshotTS)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if err = client.ExecWithElapsed("set tidb_allow_batch_cop = 0"); err != nil {
+		logger.Warnf("tidb_allow_batch_cop is ignored")
+	}
+	if err = client.ExecWithElapsed("set tidb_allow_mpp = 0"); err != nil {
+		logger.Warnf("tidb_allow_mpp = 0 is ignored")
+	}
+
+	indexes, err := client.GetIndexes(opts.dbName, opts.tableName)
+	if err != nil {
+		return err
+	}
+	if opts.indexName != "" {
+		var filtered []tidb.Index
+		for _, idx := range indexes {
+			if strings.EqualFold(idx.Name, opts.indexName) {
+				filtered = append(filtered, idx)
+			}
+		}
+		if len(filtered) == 0 {
+			return fmt.Errorf("can not find index `%s` in `%s`.`%s`", opts.indexName, opts.dbName, opts.tableName)
+		}
+		indexes = filtered
+	}
+	if len(indexes) == 0 {
+		fmt.Fprintf(opts.out, "No index to check in `%s`.`%s`\n", opts.dbName, opts.tableName)
+		return nil
+	}
+
+	commonCols, err := client.GetCommonHandleColumns(opts.dbName, opts.tableName)
+	if err != nil {
+		return err
+	}
+	handle := newIntHandle(opts.rowIdColName)
+	if len(commonCols) > 0 {
+		handle = newCommonHandle(commonCols)
+		logger.Infof("The table is clustered by common handle: (%s)", handle.String())
+	}
+
+	tables, err := client.GetPhysicalTables(opts.dbName, opts.tableName)
+	if err != nil {
+		return err
+	}
+	if tables, err = tidb.FilterPartitions(tables, opts.partitions); err != nil {
+		return err
+	}
+
+	var results []indexCheckResult
+	for _, table := range tables {
+		tableOpts := opts.checkRowsOpts
+		tableOpts.partition = table.PartitionName
+		for _, idx := range indexes {
+			if table.IsPartition() {
+				logger.Infof("Checking index `%s` (id=%d, columns=%s) of %s", idx.Name, idx.ID, strings.Join(idx.Columns, ","), table.String())
+			} else {
+				logger.Infof("Checking index `%s` (id=%d, columns=%s)", idx.Name, idx.ID, strings.Join(idx.Columns, ","))
+			}
+			res := indexCheckResult{table: table, index: idx}
+			res.queryRange, res.isConsist, res.numRowsTiKV, res.numRowsTiFlash, res.err = checkIndex(client.Db, tableOpts, handle, idx)
+			if res.err != nil {
+				// Keep checking the other indexes
+				logger.Errorf("Check index `%s` failed: %s", idx.Name, res.err)
+			}
+			results = append(results, res)
+		}
+	}
+
+	if format.IsStructured(opts.output) {
+		printSnapshot(opts.out, opts.snapshotTS)
+		return format.Render(os.Stdout, opts.output, newIndexReport(opts, results))
+	}
+
+	fmt.Fprintln(opts.out)
+	table := tablewriter.NewWriter(opts.out)
+	header := []string{"index", "index id", "status", "tikv rows", "tiflash rows", "range"}
+	if tables[0].IsPartition() {
+		header = append([]string{"partition"}, header...)
+	}
+	table.SetHeader(header)
+	for i := range results {
+		row := results[i].toRow()
+		if !tables[0].IsPartition() {
+			row = row[1:]
+		}
+		table.Append(row)
+	}
+	table.Render()
+	printSnapshot(opts.out, opts.snapshotTS)
+	return nil
+}
+
+// checkIndex compares the num of rows of the index on TiKV with the num of rows
+// on TiFlash, it returns the narrowed down range and the num of rows in it.
+func checkIndex(db *sql.DB, opts checkRowsOpts, handle tableHandle, idx tidb.Index) (QueryRange, bool, uint64, uint64, error) {
+	// The init query range is the same for all indexes, but it is cheap
+	// comparing to scanning the index, get it for each index for simplicity
+	queryRanges, err := getInitQueryRange(db, opts, handle)
+	if err != nil {
+		return QueryRange{}, false, 0, 0, err
+	}
+	queryRange, _, err := bisectQueryRanges(db, opts, handle, idx.Name, queryRanges, nil)
+	if err != nil {
+		return queryRange, false, 0, 0, err
+	}
+	tikv, tiflash, err := getNumOfRowsOnEngines(opts.out, db, opts.tableRef(), handle, idx.Name, "", queryRange, opts.numReplica)
+	return queryRange, tikv == tiflash, tikv.count, tiflash.count, err
+}
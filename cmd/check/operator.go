@@ -0,0 +1,66 @@
+package check
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/logger"
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/pd"
+)
+
+// confirm asks the question on stderr, so that it is shown even if stdout is
+// redirected
+func confirm(question string) bool {
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// pendingOperator is an operator submitted to PD, desc is the pd-ctl command
+// of it for printing the progress
+type pendingOperator struct {
+	regionID int64
+	desc     string
+}
+
+// waitOperators waits for all the operators to finish. It returns error if any
+// of them is not success or timeout.
+func waitOperators(pdClient *pd.Client, ops []pendingOperator, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for len(ops) > 0 {
+		var running []pendingOperator
+		for _, op := range ops {
+			status, err := pdClient.GetOperatorStatus(op.regionID)
+			if errors.Is(err, pd.ErrOperatorNotFound) {
+				return fmt.Errorf("%s is not found in PD, it could be finished long ago or never created: %w", op.desc, err)
+			} else if err != nil {
+				return err
+			}
+			if !pd.IsOperatorFinished(status) {
+				running = append(running, op)
+				continue
+			}
+			logger.Infof("%s => %s", op.desc, status)
+			if status != pd.OperatorStatusSuccess {
+				return fmt.Errorf("%s is not success, status: %s", op.desc, status)
+			}
+		}
+		if ops = running; len(ops) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timeout waiting for %d operators to finish after %s", len(ops), timeout)
+		}
+		logger.Infof("Waiting for %d operators to finish", len(ops))
+		time.Sleep(time.Second)
+	}
+	return nil
+}
@@ -0,0 +1,261 @@
+package check
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/logger"
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/pd"
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/tidb"
+)
+
+type regionCheckTask struct {
+	seq    int
+	region pd.Region
+}
+
+type regionCheckResult struct {
+	seq        int
+	region     pd.Region
+	queryRange QueryRange
+	isConsist  bool
+	// Not empty if the Region is skipped
+	skipReason string
+	// The output of checking, printed in the order of Regions
+	output bytes.Buffer
+	err    error
+}
+
+// rateLimiter limits the num of events per second, a nil rateLimiter means
+// no limit.
+type rateLimiter struct {
+	ticker *time.Ticker
+}
+
+func newRateLimiter(numPerSecond int) *rateLimiter {
+	if numPerSecond <= 0 {
+		return nil
+	}
+	return &rateLimiter{ticker: time.NewTicker(time.Second / time.Duration(numPerSecond))}
+}
+
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	select {
+	case <-l.ticker.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *rateLimiter) stop() {
+	if l != nil {
+		l.ticker.Stop()
+	}
+}
+
+// newCheckConn returns a dedicated connection to TiDB. The session variables
+// are bound to the connection, so they are set for each of them.
+func newCheckConn(ctx context.Context, db *sql.DB) (*sql.Conn, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = execContext(ctx, conn, "set tidb_allow_batch_cop = 0"); err != nil {
+		logger.Warnf("tidb_allow_batch_cop is ignored")
+	}
+	if _, err = execContext(ctx, conn, "set tidb_allow_mpp = 0"); err != nil {
+		logger.Warnf("tidb_allow_mpp = 0 is ignored")
+	}
+	return conn, nil
+}
+
+func execContext(ctx context.Context, conn *sql.Conn, query string) (sql.Result, error) {
+	defer tidb.LogSQL(query, "", time.Now())
+	return conn.ExecContext(ctx, query)
+}
+
+// checkRowsByKeyConcurrently is the same as checkRowsByKey, but the Regions are
+// fetched in batches and checked by `opts.concurrency` workers, each of them
+// runs on a separate connection. The results are printed in the order of
+// Regions, and the Regions checked after reaching the limit are discarded.
+func checkRowsByKeyConcurrently(db *sql.DB, opts checkRowsOpts, pdClient *pd.Client, handle tableHandle, tableID int64, key tidb.TiKVKey, cp *tableCheckpoint) ([]pd.Region, []skippedRegion, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	concurrency := opts.concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	conns := make([]*sql.Conn, 0, concurrency)
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	for i := 0; i < concurrency; i++ {
+		conn, err := newCheckConn(ctx, db)
+		if err != nil {
+			return nil, nil, err
+		}
+		conns = append(conns, conn)
+	}
+	logger.Infof("Checking Regions with %d workers, rate limit: %d Regions/s", concurrency, opts.rateLimit)
+
+	limiter := newRateLimiter(opts.rateLimit)
+	defer limiter.stop()
+
+	tasks := make(chan regionCheckTask, concurrency)
+	results := make(chan *regionCheckResult, concurrency)
+	producerErr := make(chan error, 1)
+	go func() {
+		defer close(tasks)
+		producerErr <- produceRegionTasks(ctx, pdClient, opts.numPerBatch, handle, tableID, key, tasks)
+	}()
+
+	var wg sync.WaitGroup
+	for _, conn := range conns {
+		wg.Add(1)
+		go func(conn *sql.Conn) {
+			defer wg.Done()
+			for task := range tasks {
+				if ctx.Err() != nil {
+					// Stopped, drain the tasks
+					continue
+				}
+				results <- checkRegion(ctx, db, conn, limiter, opts, handle, tableID, task)
+			}
+		}(conn)
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var (
+		numSuccess       int
+		inconsistRegions []pd.Region
+		skippedRegions   []skippedRegion
+		firstErr         error
+		done             bool
+		nextSeq          int
+		pending          = make(map[int]*regionCheckResult)
+	)
+	if cp != nil {
+		// Continue with the progress in checkpoint
+		numSuccess, inconsistRegions, skippedRegions = cp.NumSuccess, cp.InconsistRegions, cp.SkippedRegions
+	}
+	for res := range results {
+		pending[res.seq] = res
+		for {
+			r, ok := pending[nextSeq]
+			if !ok {
+				break
+			}
+			delete(pending, nextSeq)
+			nextSeq++
+			if done {
+				continue
+			}
+
+			logger.Debugf("Config: regionsLimit=%d,numSuccess=%d", opts.numRegionsLimit, numSuccess)
+			fmt.Fprint(opts.out, r.output.String())
+			if r.err != nil {
+				firstErr = r.err
+				done = true
+				cancel()
+				continue
+			}
+			if r.skipReason != "" {
+				// Neither consist nor inconsist, keep numSuccess as it is
+				fmt.Fprintf(opts.out, "Skip checking Region %v, %s\n", r.region, r.skipReason)
+				skippedRegions = append(skippedRegions, skippedRegion{Region: r.region, Reason: r.skipReason})
+			} else if countRegionCheckResult(opts, r.region, r.isConsist, &numSuccess, &inconsistRegions) {
+				done = true
+				cancel()
+			}
+			err := opts.checkpoint.update(cp, func(t *tableCheckpoint) {
+				t.NextKey, t.NumSuccess = r.region.EndKey, numSuccess
+				t.InconsistRegions, t.SkippedRegions = inconsistRegions, skippedRegions
+			})
+			if err != nil {
+				firstErr = err
+				done = true
+				cancel()
+			}
+		}
+	}
+	if firstErr != nil {
+		return inconsistRegions, skippedRegions, firstErr
+	}
+	if err := <-producerErr; err != nil && !done {
+		return inconsistRegions, skippedRegions, err
+	}
+	return inconsistRegions, skippedRegions, nil
+}
+
+// produceRegionTasks fetches the Regions from the key to the end of table in
+// batches, and sends them to tasks in the order of key.
+func produceRegionTasks(ctx context.Context, pdClient *pd.Client, numPerBatch int64, handle tableHandle, tableID int64, key tidb.TiKVKey, tasks chan<- regionCheckTask) error {
+	if numPerBatch <= 0 {
+		numPerBatch = 16
+	}
+	seq := 0
+	for {
+		regions, err := pdClient.GetRegions(key, numPerBatch)
+		if err != nil {
+			return err
+		}
+		if len(regions) == 0 {
+			return fmt.Errorf("no Region contains the key %s, run `check region-chain` to find the holes between Regions: %w", key.GetPDKey(), pd.ErrRegionNotFound)
+		}
+		for _, region := range regions {
+			// key is the start of this Region, or in the first Region
+			if isEnd, err := isEndOfTable(key, handle, tableID); err != nil {
+				return err
+			} else if isEnd {
+				// meet the end of this table, done
+				return nil
+			}
+			select {
+			case tasks <- regionCheckTask{seq: seq, region: region}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			seq++
+			if region.EndKey == "" {
+				// The last Region
+				return nil
+			}
+			if key, err = tidb.FromPDKey(region.EndKey); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func checkRegion(ctx context.Context, db *sql.DB, conn *sql.Conn, limiter *rateLimiter, opts checkRowsOpts, handle tableHandle, tableID int64, task regionCheckTask) *regionCheckResult {
+	res := &regionCheckResult{seq: task.seq, region: task.region}
+	if res.err = limiter.wait(ctx); res.err != nil {
+		return res
+	}
+	if res.queryRange, res.err = getCheckRangeFromRegion(&res.region, handle, tableID); res.err != nil {
+		return res
+	}
+	logger.Debugf("The query range of Region %d is %s", res.region.Id, res.queryRange.String())
+	if res.skipReason = getSkipReason(&res.region); res.skipReason != "" {
+		return res
+	}
+	res.isConsist, res.err = haveConsistRows(&res.output, conn, opts.tableRef(), handle, opts.checksumExpr, res.queryRange, opts.numReplica)
+	if res.err == nil && !res.isConsist && opts.compareMode == compareModeFull {
+		_, res.err = compareRowsFully(&res.output, db, opts, handle, res.queryRange, opts.maxDiffs)
+	}
+	return res
+}
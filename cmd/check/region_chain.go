@@ -0,0 +1,191 @@
+package check
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/format"
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/logger"
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/options"
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/pd"
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/tidb"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+func NewCheckRegionChainCmd() *cobra.Command {
+	var opt checkRegionChainOpts
+	c := &cobra.Command{
+		Use:   "region-chain",
+		Short: "Check the Regions cover the key space without holes or overlaps",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opt.output = options.GetOutput(cmd)
+			opt.out = format.TextWriter(opt.output)
+			return checkRegionChain(opt)
+		},
+	}
+
+	// Flags for "region-chain"
+	options.AddTiDBConnFlags(c, &opt.tidb)
+
+	c.Flags().StringVar(&opt.dbName, "database", "", "The database name of query table, check the whole key space of the cluster if not set")
+	c.Flags().StringVar(&opt.tableName, "table", "", "The table name of query table")
+	c.Flags().StringVar(&opt.partitions, "partition", "", "The comma separated partition names to check (check all partitions by default)")
+	c.Flags().Int64Var(&opt.numPerBatch, "batch", 64, "The batch size for fetching Region info")
+	c.Flags().IntVar(&opt.maxRetry, "max_retry", 3, "The max times of re-scanning the range with Regions changed during scanning")
+	return c
+}
+
+type checkRegionChainOpts struct {
+	tidb       tidb.TiDBClientOpts
+	dbName     string
+	tableName  string
+	partitions string
+	output     string
+	// Where the results are printed as text, see format.TextWriter
+	out io.Writer
+
+	numPerBatch int64
+	maxRetry    int
+}
+
+// chainReport is the result of `check region-chain` for the structured output
+type chainReport struct {
+	NumRegions      int              `json:"num_regions"`
+	NumHoles        int              `json:"num_holes"`
+	NumOverlaps     int              `json:"num_overlaps"`
+	NumEpochChanged int              `json:"num_epoch_changed"`
+	Issues          []chainIssueItem `json:"issues"`
+}
+
+type chainIssueItem struct {
+	Kind     string  `json:"kind"`
+	StartKey string  `json:"start_key"`
+	EndKey   string  `json:"end_key"`
+	Regions  []int64 `json:"regions"`
+	Detail   string  `json:"detail"`
+}
+
+func newChainReport(numRegions int, issues []pd.ChainIssue) *chainReport {
+	report := &chainReport{NumRegions: numRegions, Issues: make([]chainIssueItem, 0, len(issues))}
+	for _, issue := range issues {
+		switch issue.Kind {
+		case pd.ChainIssueHole:
+			report.NumHoles++
+		case pd.ChainIssueOverlap:
+			report.NumOverlaps++
+		case pd.ChainIssueEpochChanged:
+			report.NumEpochChanged++
+		}
+		item := chainIssueItem{Kind: issue.Kind, StartKey: issue.StartKey, EndKey: issue.EndKey, Regions: []int64{}, Detail: issue.Detail}
+		for _, r := range issue.Regions {
+			item.Regions = append(item.Regions, r.Id)
+		}
+		report.Issues = append(report.Issues, item)
+	}
+	return report
+}
+
+func (r *chainReport) Header() []string {
+	return []string{"kind", "start key", "end key", "regions", "detail"}
+}
+
+func (r *chainReport) Rows() [][]string {
+	rows := make([][]string, 0, len(r.Issues))
+	for _, i := range r.Issues {
+		ids := make([]string, 0, len(i.Regions))
+		for _, id := range i.Regions {
+			ids = append(ids, fmt.Sprint(id))
+		}
+		rows = append(rows, []string{i.Kind, i.StartKey, i.EndKey, strings.Join(ids, ", "), i.Detail})
+	}
+	return rows
+}
+
+func chainIssueRow(i *pd.ChainIssue) []string {
+	ids := make([]string, 0, len(i.Regions))
+	for _, r := range i.Regions {
+		ids = append(ids, fmt.Sprintf("%d (ver %d)", r.Id, r.Epoch.Version))
+	}
+	return []string{i.Kind, i.StartKey, i.EndKey, strings.Join(ids, ", "), i.Detail}
+}
+
+func checkRegionChain(opts checkRegionChainOpts) error {
+	if opts.dbName == "" && opts.tableName != "" {
+		return fmt.Errorf("should set the database name of table %s", opts.tableName)
+	}
+	if opts.tableName == "" && opts.partitions != "" {
+		return fmt.Errorf("should set the table name for checking partitions")
+	}
+
+	client, err := tidb.NewClientFromOpts(opts.tidb)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	pdClient, err := newPDClient(opts.tidb, &client)
+	if err != nil {
+		return err
+	}
+
+	type keyRange struct {
+		desc       string
+		start, end tidb.TiKVKey
+	}
+	var ranges []keyRange
+	if opts.tableName == "" {
+		if opts.dbName != "" {
+			return fmt.Errorf("should set the table name, or leave both database and table empty to check the whole key space")
+		}
+		ranges = append(ranges, keyRange{desc: "the whole key space"})
+	} else {
+		tables, err := client.GetPhysicalTables(opts.dbName, opts.tableName)
+		if err != nil {
+			return err
+		}
+		if tables, err = tidb.FilterPartitions(tables, opts.partitions); err != nil {
+			return err
+		}
+		for _, t := range tables {
+			ranges = append(ranges, keyRange{
+				desc:  fmt.Sprintf("`%s`.`%s` %s", opts.dbName, opts.tableName, t.String()),
+				start: tidb.NewTableStartAsKey(t.ID),
+				end:   tidb.NewTableEndAsKey(t.ID),
+			})
+		}
+	}
+
+	var (
+		allIssues  []pd.ChainIssue
+		numRegions int
+	)
+	for _, r := range ranges {
+		logger.Infof("Checking the Region chain of %s", r.desc)
+		n, issues, err := pdClient.CheckRegionChain(r.start.GetPDKey(), r.end.GetPDKey(), opts.numPerBatch, opts.maxRetry)
+		if err != nil {
+			return err
+		}
+		numRegions += n
+		allIssues = append(allIssues, issues...)
+	}
+
+	report := newChainReport(numRegions, allIssues)
+	if format.IsStructured(opts.output) {
+		return format.Render(os.Stdout, opts.output, report)
+	}
+	fmt.Fprintf(opts.out, "\nScanned %d Regions, %d holes, %d overlaps, %d Regions changed during scanning\n",
+		report.NumRegions, report.NumHoles, report.NumOverlaps, report.NumEpochChanged)
+	if len(allIssues) == 0 {
+		fmt.Fprintln(opts.out, "The Regions cover the key space without holes or overlaps")
+		return nil
+	}
+	table := tablewriter.NewWriter(opts.out)
+	table.SetHeader([]string{"kind", "start key", "end key", "regions", "detail"})
+	for i := range allIssues {
+		table.Append(chainIssueRow(&allIssues[i]))
+	}
+	table.Render()
+	return nil
+}
@@ -0,0 +1,340 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/format"
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/logger"
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/tidb"
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/tiflash"
+)
+
+// The ways to compact the TiFlash replica
+const (
+	// Try compactAlter first, fall back to compactDBGInvoke if it is not
+	// supported by TiDB
+	compactAuto = "auto"
+	// `ALTER TABLE ... COMPACT TIFLASH REPLICA`, supported since TiDB v6.1
+	compactAlter = "alter"
+	// The manual compact function of `DBGInvoke` posted to each TiFlash
+	// instance, with the table id as the argument
+	compactDBGInvoke = "dbginvoke"
+	// `MANAGE TABLE ... MERGE DELTA` posted to each TiFlash instance, an
+	// alternative if the DBGInvoke function is not available
+	compactMergeDelta = "merge_delta"
+)
+
+// defaultCompactDBGFunc is the default DBGInvoke function to compact a table
+const defaultCompactDBGFunc = "manual_compact"
+
+// compactPollInterval is the interval of polling the stats of the table on each
+// instance while TiDB is compacting it
+const compactPollInterval = time.Second
+
+// compactFanoutOpts is the default flags for compacting, merging delta of a
+// large table could take a long time
+var compactFanoutOpts = fanoutOpts{concurrency: 8, timeout: 30 * time.Minute, deadline: 2 * time.Hour}
+
+type CompactOpts struct {
+	tidb       tidb.TiDBClientOpts
+	ports      tiflash.PortOpts
+	fanout     fanoutOpts
+	output     string
+	dbName     string
+	tableName  string
+	partitions string
+	method     string
+	// The DBGInvoke function for compactDBGInvoke
+	dbgFunc string
+}
+
+// compactReport is the result of compacting the TiFlash replica of a table
+type compactReport struct {
+	Database   string   `json:"database"`
+	Table      string   `json:"table"`
+	Partitions []string `json:"partitions,omitempty"`
+	Method     string   `json:"method"`
+	ElapsedMs  int64    `json:"elapsed_ms"`
+	// The num of instances failed to compact
+	NumFailed int                     `json:"num_failed"`
+	Instances []compactInstanceReport `json:"instances"`
+
+	elapsed time.Duration
+}
+
+type compactInstanceReport struct {
+	StoreID int64  `json:"store_id"`
+	Address string `json:"address"`
+	// The num of tables (or partitions) with replica on the instance
+	NumTables  int   `json:"num_tables"`
+	NumRegions int64 `json:"num_regions"`
+	// The num of segments after compacting
+	NumSegments     int64  `json:"num_segments"`
+	DeltaRowsBefore int64  `json:"delta_rows_before"`
+	DeltaRowsAfter  int64  `json:"delta_rows_after"`
+	ElapsedMs       int64  `json:"elapsed_ms"`
+	Error           string `json:"error,omitempty"`
+
+	elapsed time.Duration
+}
+
+func (r *compactReport) Header() []string {
+	return []string{"store id", "address", "tables", "regions", "segments", "delta rows before", "delta rows after", "elapsed", "error"}
+}
+
+func (r *compactReport) Rows() [][]string {
+	rows := make([][]string, 0, len(r.Instances))
+	for _, inst := range r.Instances {
+		rows = append(rows, []string{
+			strconv.FormatInt(inst.StoreID, 10), inst.Address, strconv.Itoa(inst.NumTables), strconv.FormatInt(inst.NumRegions, 10),
+			strconv.FormatInt(inst.NumSegments, 10), strconv.FormatInt(inst.DeltaRowsBefore, 10), strconv.FormatInt(inst.DeltaRowsAfter, 10),
+			inst.elapsed.Round(time.Millisecond).String(), inst.Error,
+		})
+	}
+	return rows
+}
+
+// compactTiFlashReplica compacts the TiFlash replica of the table by the
+// method, then reports the stats of the table on each instance
+func compactTiFlashReplica(opts CompactOpts) error {
+	if opts.dbName == "" || opts.tableName == "" {
+		return fmt.Errorf("should set the database name and table name for running")
+	}
+	switch opts.method {
+	case compactAuto, compactAlter, compactDBGInvoke, compactMergeDelta:
+	default:
+		return fmt.Errorf("unknown compact method %s, should be one of auto|alter|dbginvoke|merge_delta", opts.method)
+	}
+
+	client, err := tidb.NewClientFromOpts(opts.tidb)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	tables, err := client.GetPhysicalTables(opts.dbName, opts.tableName)
+	if err != nil {
+		return err
+	}
+	if tables, err = tidb.FilterPartitions(tables, opts.partitions); err != nil {
+		return err
+	}
+	var (
+		tableIDs   []int64
+		partitions []string
+	)
+	for _, t := range tables {
+		tableIDs = append(tableIDs, t.ID)
+		if opts.partitions != "" {
+			partitions = append(partitions, t.PartitionName)
+		}
+	}
+	instances, err := getTiFlashInstances(opts.tidb, opts.ports, &client)
+	if err != nil {
+		return err
+	}
+	before, err := client.GetTiFlashTableStats(tableIDs)
+	if err != nil {
+		return err
+	}
+
+	report := &compactReport{Database: opts.dbName, Table: opts.tableName, Partitions: partitions, Method: opts.method}
+	errs := make(map[int64]string)
+	elapsed := make(map[int64]time.Duration)
+	start := time.Now()
+	if opts.method == compactAuto || opts.method == compactAlter {
+		ctx := context.Background()
+		if opts.fanout.deadline > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, opts.fanout.deadline)
+			defer cancel()
+		}
+		logger.Infof("Compacting the TiFlash replica of `%s`.`%s` on %d TiFlash instances through TiDB", opts.dbName, opts.tableName, len(instances))
+		elapsed, err = compactThroughTiDB(ctx, &client, opts, partitions, tableIDs, instances, before)
+		if opts.method == compactAuto && tidb.IsSyntaxError(err) {
+			logger.Warnf("Compacting TiFlash replica is not supported by TiDB, fall back to DBGInvoke %s on each TiFlash instance: %s", opts.dbgFunc, err)
+			report.Method = compactDBGInvoke
+		} else if err != nil {
+			return err
+		} else {
+			report.Method = compactAlter
+		}
+	}
+	if report.Method == compactDBGInvoke || report.Method == compactMergeDelta {
+		start = time.Now()
+		if errs, elapsed, err = compactOnInstances(opts, report.Method, instances, before); err != nil {
+			return err
+		}
+	}
+	report.elapsed = time.Since(start)
+	report.ElapsedMs = report.elapsed.Milliseconds()
+
+	after, err := client.GetTiFlashTableStats(tableIDs)
+	if err != nil {
+		return err
+	}
+	numRegions, err := client.GetNumRegionsByStore(tableIDs)
+	if err != nil {
+		// Only for reporting, go on without it
+		logger.Warnf("Can not get the num of Regions on each store, err: %s", err)
+	}
+	for _, inst := range instances {
+		r := compactInstanceReport{
+			StoreID:    inst.store.Id,
+			Address:    inst.store.Address,
+			NumRegions: numRegions[inst.store.Id],
+			Error:      errs[inst.store.Id],
+			elapsed:    elapsed[inst.store.Id],
+		}
+		r.ElapsedMs = r.elapsed.Milliseconds()
+		for _, s := range before {
+			if s.Instance == inst.store.Address {
+				r.DeltaRowsBefore += s.NumDeltaRows
+			}
+		}
+		for _, s := range after {
+			if s.Instance == inst.store.Address {
+				r.NumTables++
+				r.NumSegments += s.NumSegments
+				r.DeltaRowsAfter += s.NumDeltaRows
+			}
+		}
+		if r.Error != "" {
+			report.NumFailed++
+		}
+		report.Instances = append(report.Instances, r)
+	}
+
+	if format.IsStructured(opts.output) {
+		if err = format.Render(os.Stdout, opts.output, report); err != nil {
+			return err
+		}
+	} else {
+		fmt.Printf("Compacted the TiFlash replica of `%s`.`%s` by %s in %s\n", opts.dbName, opts.tableName, report.Method, report.elapsed.Round(time.Millisecond))
+		if err = format.Render(os.Stdout, format.Text, report); err != nil {
+			return err
+		}
+	}
+	if report.NumFailed > 0 {
+		return fmt.Errorf("%d of %d TiFlash instances failed to compact", report.NumFailed, len(instances))
+	}
+	return nil
+}
+
+// compactThroughTiDB runs `ALTER TABLE ... COMPACT TIFLASH REPLICA` and polls
+// the stats of the tables on each instance meanwhile. TiDB only returns after
+// all the instances are done, so the time cost of an instance is the time its
+// num of delta rows is changed for the last time, it is accurate to the polling
+// interval. The time cost is 0 if the delta rows are never changed.
+func compactThroughTiDB(ctx context.Context, client *tidb.Client, opts CompactOpts, partitions []string, tableIDs []int64,
+	instances []tiflashInstance, before []tidb.TiFlashTableStat) (map[int64]time.Duration, error) {
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- client.CompactTiFlashReplica(ctx, opts.dbName, opts.tableName, partitions)
+	}()
+
+	deltaRows := sumDeltaRowsByInstance(before)
+	lastChanged := make(map[string]time.Duration)
+	poll := func() {
+		stats, err := client.GetTiFlashTableStats(tableIDs)
+		if err != nil {
+			// Only for reporting the progress, go on without it
+			logger.Warnf("Can not get the stats of the TiFlash replica, err: %s", err)
+			return
+		}
+		for addr, rows := range sumDeltaRowsByInstance(stats) {
+			if rows != deltaRows[addr] {
+				logger.Infof("The delta rows of `%s`.`%s` on %s: %d -> %d", opts.dbName, opts.tableName, addr, deltaRows[addr], rows)
+				deltaRows[addr], lastChanged[addr] = rows, time.Since(start)
+			}
+		}
+	}
+
+	ticker := time.NewTicker(compactPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				return nil, err
+			}
+			// Catch the changes after the last polling
+			poll()
+			elapsed := make(map[int64]time.Duration)
+			for _, inst := range instances {
+				elapsed[inst.store.Id] = lastChanged[inst.store.Address]
+			}
+			return elapsed, nil
+		case <-ticker.C:
+			poll()
+		}
+	}
+}
+
+func sumDeltaRowsByInstance(stats []tidb.TiFlashTableStat) map[string]int64 {
+	res := make(map[string]int64)
+	for _, s := range stats {
+		res[s.Instance] += s.NumDeltaRows
+	}
+	return res
+}
+
+// compactQuery returns the query posted to TiFlash to compact the table by the
+// method
+func compactQuery(opts CompactOpts, method string, s tidb.TiFlashTableStat) string {
+	if method == compactMergeDelta {
+		return fmt.Sprintf("manage table `%s`.`%s` merge delta", s.MappedDBName, s.MappedTableName)
+	}
+	return fmt.Sprintf("DBGInvoke %s(%d)", opts.dbgFunc, s.TableID)
+}
+
+// compactOnInstances posts the query to compact the tables by the method to the
+// TiFlash instances having their replica. It returns the error and the time
+// cost from the first request started to the last one finished of each
+// instance.
+func compactOnInstances(opts CompactOpts, method string, instances []tiflashInstance, stats []tidb.TiFlashTableStat) (map[int64]string, map[int64]time.Duration, error) {
+	httpClient, scheme, err := opts.tidb.TLS.NewHTTPClient()
+	if err != nil {
+		return nil, nil, err
+	}
+	var reqs []tiflashRequest
+	for i := range instances {
+		for _, s := range stats {
+			if s.Instance != instances[i].store.Address {
+				continue
+			}
+			reqs = append(reqs, tiflashRequest{
+				inst:  &instances[i],
+				desc:  fmt.Sprintf("table id: %d", s.TableID),
+				query: compactQuery(opts, method, s),
+			})
+		}
+	}
+	logger.Infof("Compacting `%s`.`%s` by %s with %d requests to %d TiFlash instances", opts.dbName, opts.tableName, method, len(reqs), len(instances))
+
+	errs := make(map[int64]string)
+	first := make(map[int64]time.Time)
+	last := make(map[int64]time.Time)
+	for _, r := range fanoutRequests(httpClient, scheme, opts.fanout, reqs) {
+		id := r.req.inst.store.Id
+		if r.err != nil && errs[id] == "" {
+			errs[id] = fmt.Sprintf("%s: %s", r.req.desc, r.err)
+		}
+		if s, ok := first[id]; !ok || r.start.Before(s) {
+			first[id] = r.start
+		}
+		if end := r.start.Add(r.elapsed); end.After(last[id]) {
+			last[id] = end
+		}
+	}
+	elapsed := make(map[int64]time.Duration)
+	for id, s := range first {
+		elapsed[id] = last[id].Sub(s)
+	}
+	return errs, elapsed, nil
+}
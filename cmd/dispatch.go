@@ -2,26 +2,31 @@ package cmd
 
 import (
 	"fmt"
-	"io"
-	"net/http"
-	"strings"
 
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/logger"
 	"github.com/JaySon-Huang/tiflash-ctl/pkg/options"
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/pd"
 	"github.com/JaySon-Huang/tiflash-ctl/pkg/tidb"
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/tiflash"
 	"github.com/spf13/cobra"
 )
 
 type FetchRegionsOpts struct {
-	tidb            tidb.TiDBClientOpts
-	tiflashHttpPort int
-	dbName          string
-	tableName       string
+	tidb       tidb.TiDBClientOpts
+	ports      tiflash.PortOpts
+	fanout     fanoutOpts
+	output     string
+	dbName     string
+	tableName  string
+	partitions string
 }
 
 type ExecCmdOpts struct {
-	tidb            tidb.TiDBClientOpts
-	tiflashHttpPort int
-	flashCmd        string
+	tidb     tidb.TiDBClientOpts
+	ports    tiflash.PortOpts
+	fanout   fanoutOpts
+	output   string
+	flashCmd string
 }
 
 func newDispatchCmd() *cobra.Command {
@@ -40,19 +45,44 @@ func newDispatchCmd() *cobra.Command {
 			Use:   "fetch_region",
 			Short: "Fetch Regions info for each TiFlash server",
 			RunE: func(cmd *cobra.Command, args []string) error {
+				opt.output = options.GetOutput(cmd)
 				return dumpTiFlashRegionInfo(opt)
 			},
 		}
 		// Flags for "fetch region"
 		options.AddTiDBConnFlags(c, &opt.tidb)
-		c.Flags().IntVar(&opt.tiflashHttpPort, "tiflash_http_port", 8123, "The port of TiFlash instance")
+		addTiFlashPortFlags(c, &opt.ports)
+		addFanoutFlags(c, &opt.fanout, defaultFanoutOpts)
 
 		c.Flags().StringVar(&opt.dbName, "database", "", "The database name of query table")
 		c.Flags().StringVar(&opt.tableName, "table", "", "The table name of query table")
+		c.Flags().StringVar(&opt.partitions, "partition", "", "The comma separated partition names to fetch (fetch all partitions by default)")
 		return c
 	}
 
-	/// TODO: Apply delta merge for a table for all TiFlash instances
+	/// Compact the TiFlash replica of a table on all TiFlash instances
+	newCompactCmd := func() *cobra.Command {
+		var opt CompactOpts
+		c := &cobra.Command{
+			Use:   "compact",
+			Short: "Compact the TiFlash replica of a table on each TiFlash server",
+			RunE: func(cmd *cobra.Command, args []string) error {
+				opt.output = options.GetOutput(cmd)
+				return compactTiFlashReplica(opt)
+			},
+		}
+		// Flags for "compact"
+		options.AddTiDBConnFlags(c, &opt.tidb)
+		addTiFlashPortFlags(c, &opt.ports)
+		addFanoutFlags(c, &opt.fanout, compactFanoutOpts)
+
+		c.Flags().StringVar(&opt.dbName, "database", "", "The database name of query table")
+		c.Flags().StringVar(&opt.tableName, "table", "", "The table name of query table")
+		c.Flags().StringVar(&opt.partitions, "partition", "", "The comma separated partition names to compact (compact all partitions by default)")
+		c.Flags().StringVar(&opt.method, "method", compactAuto, "'alter' runs \"ALTER TABLE ... COMPACT TIFLASH REPLICA\" through TiDB, 'dbginvoke' posts the DBGInvoke manual compact function to each TiFlash, 'auto' falls back to dbginvoke if alter is not supported. 'merge_delta' posts \"MANAGE TABLE ... MERGE DELTA\" to each TiFlash, an alternative if the DBGInvoke function is not available")
+		c.Flags().StringVar(&opt.dbgFunc, "dbginvoke_func", defaultCompactDBGFunc, "The DBGInvoke function to compact a table on TiFlash for the dbginvoke method, it is called with the table id")
+		return c
+	}
 
 	newExecCmd := func() *cobra.Command {
 		var opt ExecCmdOpts
@@ -60,49 +90,41 @@ func newDispatchCmd() *cobra.Command {
 			Use:   "exec",
 			Short: "Exec command",
 			RunE: func(cmd *cobra.Command, args []string) error {
+				opt.output = options.GetOutput(cmd)
 				return execTiFlashCmd(opt)
 			},
 		}
 		// Flags for "fetch region"
 		options.AddTiDBConnFlags(c, &opt.tidb)
-		c.Flags().IntVar(&opt.tiflashHttpPort, "tiflash_http_port", 8123, "The port of TiFlash instance")
+		addTiFlashPortFlags(c, &opt.ports)
+		addFanoutFlags(c, &opt.fanout, defaultFanoutOpts)
 
 		c.Flags().StringVar(&opt.flashCmd, "cmd", "", "The command executed in all TiFlash")
 		return c
 	}
 
-	cmd.AddCommand(newGetRegionCmd(), newExecCmd())
+	cmd.AddCommand(newGetRegionCmd(), newCompactCmd(), newExecCmd())
 
 	return cmd
 }
 
-func getTiFlashIPs(client *tidb.Client) ([]string, error) {
-	instances, err := client.GetInstances("tiflash")
+// getTiFlashStores returns the TiFlash stores registered in PD. The stores in
+// tombstone state are ignored.
+func getTiFlashStores(opts tidb.TiDBClientOpts, client *tidb.Client) ([]pd.Store, error) {
+	pdClient, err := pd.NewPDClientFromTiDB(client, opts)
 	if err != nil {
 		return nil, err
 	}
-	var IPs []string
-	for _, s := range instances {
-		sp := strings.Split(s, ":")
-		IPs = append(IPs, sp[0])
-	}
-	return IPs, nil
-}
-
-func curlTiFlash(ip string, httpPort int, query string) error {
-	// TODO: well-defined http interface that response data in JSON format is better
-	reqBodyReader := strings.NewReader(query)
-	resp, err := http.Post(fmt.Sprintf("http://%s:%d/post", ip, httpPort), "text/html", reqBodyReader)
+	stores, err := pdClient.GetTiFlashStores()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return err
+	for _, s := range stores {
+		if s.StateName != pd.StoreStateUp {
+			logger.Warnf("TiFlash store %d %s is %s", s.Id, s.Address, s.StateName)
+		}
 	}
-	fmt.Println(string(body))
-	return nil
+	return stores, nil
 }
 
 func dumpTiFlashRegionInfo(opts FetchRegionsOpts) error {
@@ -116,22 +138,32 @@ func dumpTiFlashRegionInfo(opts FetchRegionsOpts) error {
 	}
 	defer client.Close()
 
-	ips, err := getTiFlashIPs(&client)
+	instances, err := getTiFlashInstances(opts.tidb, opts.ports, &client)
+	if err != nil {
+		return err
+	}
+	httpClient, scheme, err := opts.tidb.TLS.NewHTTPClient()
 	if err != nil {
 		return err
 	}
-	tableID, err := client.GetTableID(opts.dbName, opts.tableName)
+	tables, err := client.GetPhysicalTables(opts.dbName, opts.tableName)
 	if err != nil {
 		return err
 	}
-	for _, ip := range ips {
-		fmt.Printf("TiFlash ip: %s:%d table: `%s`.`%s` table_id: %d; Dumping Regions of table\n", ip, opts.tiflashHttpPort, opts.dbName, opts.tableName, tableID)
-		// TODO: Find a way to get http port
-		if err = curlTiFlash(ip, opts.tiflashHttpPort, fmt.Sprintf("DBGInvoke dump_all_region(%d)", tableID)); err != nil {
-			fmt.Printf("err: %v", err)
+	if tables, err = tidb.FilterPartitions(tables, opts.partitions); err != nil {
+		return err
+	}
+	var reqs []tiflashRequest
+	for i := range instances {
+		for _, table := range tables {
+			reqs = append(reqs, tiflashRequest{
+				inst:  &instances[i],
+				desc:  fmt.Sprintf("table: `%s`.`%s` %s; Dumping Regions of table", opts.dbName, opts.tableName, table.String()),
+				query: fmt.Sprintf("DBGInvoke dump_all_region(%d)", table.ID),
+			})
 		}
 	}
-	return nil
+	return printResponses(opts.output, instances, fanoutRequests(httpClient, scheme, opts.fanout, reqs))
 }
 
 func execTiFlashCmd(opts ExecCmdOpts) error {
@@ -141,17 +173,17 @@ func execTiFlashCmd(opts ExecCmdOpts) error {
 	}
 	defer client.Close()
 
-	ips, err := getTiFlashIPs(&client)
+	instances, err := getTiFlashInstances(opts.tidb, opts.ports, &client)
 	if err != nil {
 		return err
 	}
-	for _, ip := range ips {
-		fmt.Printf("TiFlash ip: %s:%d\n", ip, opts.tiflashHttpPort)
-		// TODO: Find a way to get http port
-		if err = curlTiFlash(ip, opts.tiflashHttpPort, opts.flashCmd); err != nil {
-			fmt.Printf("err: %v\n", err)
-		}
+	httpClient, scheme, err := opts.tidb.TLS.NewHTTPClient()
+	if err != nil {
+		return err
 	}
-
-	return nil
+	var reqs []tiflashRequest
+	for i := range instances {
+		reqs = append(reqs, tiflashRequest{inst: &instances[i], query: opts.flashCmd})
+	}
+	return printResponses(opts.output, instances, fanoutRequests(httpClient, scheme, opts.fanout, reqs))
 }
@@ -0,0 +1,253 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/format"
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/logger"
+	"github.com/spf13/cobra"
+)
+
+type fanoutOpts struct {
+	concurrency int
+	// The timeout of each request
+	timeout time.Duration
+	// The timeout of all the requests
+	deadline time.Duration
+}
+
+// defaultFanoutOpts is the default flags for the requests that are finished
+// quickly by TiFlash
+var defaultFanoutOpts = fanoutOpts{concurrency: 8, timeout: time.Minute, deadline: 10 * time.Minute}
+
+func addFanoutFlags(c *cobra.Command, opts *fanoutOpts, defaults fanoutOpts) {
+	c.Flags().IntVar(&opts.concurrency, "concurrency", defaults.concurrency, "The max num of requests sent to TiFlash instances at the same time")
+	c.Flags().DurationVar(&opts.timeout, "timeout", defaults.timeout, "The timeout of each request to a TiFlash instance")
+	c.Flags().DurationVar(&opts.deadline, "deadline", defaults.deadline, "The timeout of all the requests, the requests not finished are failed after it")
+}
+
+// tiflashRequest is a query posted to a TiFlash instance, desc is printed with
+// the response
+type tiflashRequest struct {
+	inst  *tiflashInstance
+	desc  string
+	query string
+}
+
+type tiflashResponse struct {
+	req     tiflashRequest
+	body    string
+	err     error
+	start   time.Time
+	elapsed time.Duration
+}
+
+// fanoutRequests posts the requests to the TiFlash instances concurrently with
+// at most `opts.concurrency` workers. The responses are in the same order as
+// the requests. The progress is logged after each request is finished.
+func fanoutRequests(httpClient *http.Client, scheme string, opts fanoutOpts, reqs []tiflashRequest) []tiflashResponse {
+	ctx := context.Background()
+	if opts.deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.deadline)
+		defer cancel()
+	}
+	concurrency := opts.concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	responses := make([]tiflashResponse, len(reqs))
+	indexes := make(chan int)
+	var (
+		wg      sync.WaitGroup
+		numDone int32
+	)
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				res := postTiFlash(ctx, httpClient, scheme, opts.timeout, reqs[i])
+				n := atomic.AddInt32(&numDone, 1)
+				if res.err != nil {
+					logger.Warnf("[%d/%d] TiFlash store %d %s failed after %s: %s", n, len(reqs), res.req.inst.store.Id, res.req.query, res.elapsed.Round(time.Millisecond), res.err)
+				} else {
+					logger.Infof("[%d/%d] TiFlash store %d %s finished in %s", n, len(reqs), res.req.inst.store.Id, res.req.query, res.elapsed.Round(time.Millisecond))
+				}
+				responses[i] = res
+			}
+		}()
+	}
+	for i := range reqs {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return responses
+}
+
+func postTiFlash(ctx context.Context, httpClient *http.Client, scheme string, timeout time.Duration, req tiflashRequest) tiflashResponse {
+	start := time.Now()
+	res := tiflashResponse{req: req, start: start}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	res.body, res.err = curlTiFlash(ctx, httpClient, scheme, req.inst.ip, req.inst.httpPort, req.query)
+	res.elapsed = time.Since(start)
+	return res
+}
+
+// curlTiFlash posts the query to the TiFlash instance, scheme is "https" for
+// the TLS-enabled cluster. The request is logged with its latency at debug
+// level.
+func curlTiFlash(ctx context.Context, httpClient *http.Client, scheme string, ip string, httpPort int, query string) (string, error) {
+	// TODO: well-defined http interface that response data in JSON format is better
+	url := fmt.Sprintf("%s://%s:%d/post", scheme, ip, httpPort)
+	start := time.Now()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(query))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "text/html")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		logger.Debugf("[http] POST %s %q => %s, %dms (tiflash)", url, query, err, time.Since(start).Milliseconds())
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	logger.Debugf("[http] POST %s %q => %d, %dms (tiflash)", url, query, resp.StatusCode, time.Since(start).Milliseconds())
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return string(body), fmt.Errorf("post to %s fail, status: %d, response: %s", url, resp.StatusCode, body)
+	}
+	return string(body), nil
+}
+
+// dispatchReport is the responses of dispatching requests to the TiFlash
+// instances for the structured output
+type dispatchReport struct {
+	Instances []instanceReport `json:"instances"`
+	// The num of instances with any request failed
+	NumFailed int `json:"num_failed"`
+}
+
+type instanceReport struct {
+	StoreID    int64            `json:"store_id"`
+	Address    string           `json:"address"`
+	HttpPort   int              `json:"http_port"`
+	PortSource string           `json:"port_source"`
+	NumSuccess int              `json:"num_success"`
+	NumFailed  int              `json:"num_failed"`
+	Responses  []responseReport `json:"responses"`
+
+	maxElapsed time.Duration
+}
+
+type responseReport struct {
+	Desc      string `json:"desc,omitempty"`
+	Query     string `json:"query"`
+	Body      string `json:"body"`
+	Error     string `json:"error,omitempty"`
+	ElapsedMs int64  `json:"elapsed_ms"`
+}
+
+// newDispatchReport groups the responses by instance in the order of instances
+func newDispatchReport(instances []tiflashInstance, responses []tiflashResponse) *dispatchReport {
+	report := &dispatchReport{Instances: make([]instanceReport, 0, len(instances))}
+	index := make(map[int64]int)
+	for i := range instances {
+		inst := &instances[i]
+		index[inst.store.Id] = i
+		report.Instances = append(report.Instances, instanceReport{
+			StoreID:    inst.store.Id,
+			Address:    inst.store.Address,
+			HttpPort:   inst.httpPort,
+			PortSource: inst.portSource,
+			Responses:  []responseReport{},
+		})
+	}
+	for _, r := range responses {
+		inst := &report.Instances[index[r.req.inst.store.Id]]
+		res := responseReport{Desc: r.req.desc, Query: r.req.query, Body: r.body, ElapsedMs: r.elapsed.Milliseconds()}
+		if r.err != nil {
+			res.Error = r.err.Error()
+			inst.NumFailed++
+		} else {
+			inst.NumSuccess++
+		}
+		if r.elapsed > inst.maxElapsed {
+			inst.maxElapsed = r.elapsed
+		}
+		inst.Responses = append(inst.Responses, res)
+	}
+	for _, inst := range report.Instances {
+		if inst.NumFailed > 0 {
+			report.NumFailed++
+		}
+	}
+	return report
+}
+
+func (r *dispatchReport) Header() []string {
+	return []string{"store id", "address", "http port", "requests", "success", "failed", "max elapsed"}
+}
+
+func (r *dispatchReport) Rows() [][]string {
+	rows := make([][]string, 0, len(r.Instances))
+	for _, inst := range r.Instances {
+		rows = append(rows, []string{
+			strconv.FormatInt(inst.StoreID, 10), inst.Address, strconv.Itoa(inst.HttpPort),
+			strconv.Itoa(len(inst.Responses)), strconv.Itoa(inst.NumSuccess), strconv.Itoa(inst.NumFailed),
+			inst.maxElapsed.Round(time.Millisecond).String(),
+		})
+	}
+	return rows
+}
+
+// printResponses prints the responses grouped by instance, then the summary of
+// each instance. It returns error if any request is failed.
+func printResponses(output string, instances []tiflashInstance, responses []tiflashResponse) error {
+	report := newDispatchReport(instances, responses)
+	if format.IsStructured(output) {
+		if err := format.Render(os.Stdout, output, report); err != nil {
+			return err
+		}
+	} else {
+		for i := range instances {
+			fmt.Printf("\n========\n%s\n", instances[i].String())
+			for _, r := range report.Instances[i].Responses {
+				if r.Desc != "" {
+					fmt.Println(r.Desc)
+				}
+				if r.Error != "" {
+					fmt.Printf("err: %s\n", r.Error)
+				} else {
+					fmt.Println(r.Body)
+				}
+			}
+		}
+		fmt.Println("\nSummary:")
+		if err := format.Render(os.Stdout, format.Text, report); err != nil {
+			return err
+		}
+	}
+	if report.NumFailed > 0 {
+		return fmt.Errorf("%d of %d TiFlash instances failed", report.NumFailed, len(instances))
+	}
+	return nil
+}
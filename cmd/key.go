@@ -0,0 +1,288 @@
+package cmd
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/codec"
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/format"
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/options"
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/tidb"
+	"github.com/spf13/cobra"
+)
+
+type EncodeKeyOpts struct {
+	tableID int64
+	rowID   int64
+}
+
+func newKeyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "key",
+		Short: "Decode or encode the TiDB keys in TiKV",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cmd.Help()
+		},
+	}
+
+	/// Decode the key pasted from PD, TiKV or TiFlash
+	newDecodeCmd := func() *cobra.Command {
+		c := &cobra.Command{
+			Use:   "decode <key>",
+			Short: "Decode a key in hex, escaped string or base64 format",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				output := options.GetOutput(cmd)
+				res, err := decodeKey(args[0])
+				// The key is printed as text even if it is not a TiDB key
+				if res != nil && (err == nil || !format.IsStructured(output)) {
+					if renderErr := renderKey(output, res); renderErr != nil {
+						return renderErr
+					}
+				}
+				return err
+			},
+		}
+		return c
+	}
+
+	newEncodeCmd := func() *cobra.Command {
+		var opt EncodeKeyOpts
+		c := &cobra.Command{
+			Use:   "encode",
+			Short: "Encode the row key of a table",
+			RunE: func(cmd *cobra.Command, args []string) error {
+				if !cmd.Flags().Changed("table-id") {
+					return fmt.Errorf("should set the table id for encoding")
+				}
+				key := tidb.NewTableRowAsKey(opt.tableID, opt.rowID)
+				if !cmd.Flags().Changed("row-id") {
+					// Without row id, encode the start key of the table
+					key = tidb.NewTableStartAsKey(opt.tableID)
+				}
+				return renderKey(options.GetOutput(cmd), newKeyForms(key))
+			},
+		}
+		c.Flags().Int64Var(&opt.tableID, "table-id", 0, "The table id (or partition id)")
+		c.Flags().Int64Var(&opt.rowID, "row-id", 0, "The int handle of row, encode the start key of the table if not set")
+		return c
+	}
+
+	cmd.AddCommand(newDecodeCmd(), newEncodeCmd())
+
+	return cmd
+}
+
+// keyResult is the result of decoding or encoding a key, it is printed as
+// text, or rendered in the structured format
+type keyResult interface {
+	format.Table
+	print(w io.Writer)
+}
+
+func renderKey(output string, res keyResult) error {
+	if format.IsStructured(output) {
+		return format.Render(os.Stdout, output, res)
+	}
+	res.print(os.Stdout)
+	return nil
+}
+
+// keyForms is the key in the formats used by PD, TiDB and TiKV
+type keyForms struct {
+	PDKey      string `json:"pd_key"`
+	RawKey     string `json:"raw_key"`
+	EscapedKey string `json:"escaped_key"`
+}
+
+func newKeyForms(key tidb.TiKVKey) *keyForms {
+	raw, _ := key.GetRawKey()
+	return &keyForms{
+		PDKey:      key.GetPDKey(),
+		RawKey:     strings.ToUpper(hex.EncodeToString(raw)),
+		EscapedKey: tidb.EscapeKey(key.GetBytes()),
+	}
+}
+
+func (k *keyForms) Header() []string {
+	return []string{"pd key", "raw key", "escaped key"}
+}
+
+func (k *keyForms) Rows() [][]string {
+	return [][]string{{k.PDKey, k.RawKey, k.EscapedKey}}
+}
+
+func (k *keyForms) print(w io.Writer) {
+	fmt.Fprintf(w, "PD key:      %s\n", k.PDKey)
+	fmt.Fprintf(w, "Raw key:     %s\n", k.RawKey)
+	fmt.Fprintf(w, "Escaped key: %s\n", k.EscapedKey)
+}
+
+// decodedKey is the result of decoding a key
+type decodedKey struct {
+	InputFormat tidb.KeyFormat `json:"input_format"`
+	keyForms
+	TableID int64 `json:"table_id"`
+	// One of record|index|table prefix|unknown
+	KeyType string   `json:"key_type"`
+	IndexID int64    `json:"index_id,omitempty"`
+	Datums  []string `json:"datums,omitempty"`
+	// The handle of the record key
+	Handle string `json:"handle,omitempty"`
+	// Explain which rows the key lies between if the handle is incomplete
+	Notes []string `json:"notes,omitempty"`
+
+	// Whether the table id is decoded
+	hasTableID bool
+	// The escaped key without memcomparable encoding
+	escapedRaw string
+}
+
+func (k *decodedKey) Header() []string {
+	return append([]string{"input format"}, append(k.keyForms.Header(), "table id", "key type", "index id", "datums", "handle", "notes")...)
+}
+
+func (k *decodedKey) Rows() [][]string {
+	var indexID string
+	if k.KeyType == "index" {
+		indexID = strconv.FormatInt(k.IndexID, 10)
+	}
+	row := append([]string{string(k.InputFormat)}, k.keyForms.Rows()[0]...)
+	row = append(row, strconv.FormatInt(k.TableID, 10), k.KeyType, indexID, datumsString(k.Datums), k.Handle, strings.Join(k.Notes, "; "))
+	return [][]string{row}
+}
+
+func (k *decodedKey) print(w io.Writer) {
+	fmt.Fprintf(w, "Input format: %s\n", k.InputFormat)
+	k.keyForms.print(w)
+	if !k.hasTableID {
+		return
+	}
+	fmt.Fprintf(w, "Table ID:    %d\n", k.TableID)
+	switch k.KeyType {
+	case "record":
+		fmt.Fprintln(w, "Key type:    record")
+		fmt.Fprintf(w, "Handle:      %s\n", k.Handle)
+		if len(k.Notes) > 0 {
+			fmt.Fprintln(w, "This key is a boundary in the middle of a row:")
+			for _, note := range k.Notes {
+				fmt.Fprintf(w, "  * %s\n", note)
+			}
+		}
+	case "index":
+		fmt.Fprintln(w, "Key type:    index")
+		fmt.Fprintf(w, "Index ID:    %d\n", k.IndexID)
+		fmt.Fprintf(w, "Datums:      %s\n", datumsString(k.Datums))
+	case "table prefix":
+		// The start key of table is the end key of the previous table
+		fmt.Fprintf(w, "Key type:    table prefix, the end of table %d\n", k.TableID-1)
+	default:
+		fmt.Fprintf(w, "Key type:    %s, %s\n", k.KeyType, k.escapedRaw)
+	}
+}
+
+// decodeKey decodes the key in any format. The result is returned with the
+// error if the key is parsed but not a TiDB key.
+func decodeKey(input string) (*decodedKey, error) {
+	key, keyFormat, err := tidb.ParseKey(input)
+	if err != nil {
+		return nil, err
+	}
+	res := &decodedKey{InputFormat: keyFormat, keyForms: *newKeyForms(key)}
+
+	if res.TableID, err = key.GetTableID(); err != nil {
+		return res, err
+	}
+	res.hasTableID = true
+
+	raw, err := key.GetRawKey()
+	if err != nil {
+		return res, err
+	}
+	res.escapedRaw = tidb.EscapeKey(raw)
+	switch {
+	case key.IsRecordKey():
+		res.KeyType = "record"
+		describeRecordKey(res, raw[1+8+2:])
+	case key.IsIndexKey():
+		res.KeyType = "index"
+		idx, err := key.GetTableIndex()
+		if err != nil {
+			return res, err
+		}
+		res.IndexID = idx.IndexID
+		res.Datums = datumStrings(idx.Values)
+	case len(raw) == 1+8:
+		res.KeyType = "table prefix"
+	default:
+		res.KeyType = "unknown"
+	}
+	return res, nil
+}
+
+func datumStrings(datums []codec.Datum) []string {
+	vals := make([]string, 0, len(datums))
+	for _, d := range datums {
+		vals = append(vals, d.String())
+	}
+	return vals
+}
+
+func datumsString(vals []string) string {
+	return "{" + strings.Join(vals, ", ") + "}"
+}
+
+// describeRecordKey sets the handle in the record key. The Region boundary
+// can be in the middle of a row when the handle is not complete, explain
+// which rows the boundary lies between.
+func describeRecordKey(res *decodedKey, h []byte) {
+	if len(h) == 0 {
+		res.Handle = "-Inf, the start of the table"
+		return
+	}
+	if len(h) == 8 {
+		_, rowID, _ := codec.DecodeInt(h)
+		res.Handle = fmt.Sprintf("%d (int handle)", rowID)
+		return
+	}
+
+	var (
+		datums []codec.Datum
+		remain = h
+	)
+	for len(remain) > 0 {
+		b, d, err := codec.DecodeOne(remain)
+		if err != nil {
+			break
+		}
+		datums = append(datums, d)
+		remain = b
+	}
+	if len(remain) == 0 {
+		res.Datums = datumStrings(datums)
+		res.Handle = fmt.Sprintf("%s (common handle, %d columns)", datumsString(res.Datums), len(datums))
+		return
+	}
+
+	res.Handle = fmt.Sprintf("incomplete, %d bytes", len(h))
+	// Regard as int handle
+	padded := make([]byte, 8)
+	copy(padded, h)
+	_, rowID, _ := codec.DecodeInt(padded)
+	if len(h) < 8 {
+		res.Notes = append(res.Notes, fmt.Sprintf("As int handle, the handle is shorter than 8 bytes, the key sorts after row id %d and before (or equals to) row id %d", rowID-1, rowID))
+	} else {
+		res.Notes = append(res.Notes, fmt.Sprintf("As int handle, the handle has %d extra bytes, the key sorts after row id %d and before row id %d", len(h)-8, rowID, rowID+1))
+	}
+	// Regard as common handle
+	if len(datums) == 0 {
+		res.Notes = append(res.Notes, fmt.Sprintf("As common handle, the key is invalid since the flag of first column 0x%02X is unknown or the value is truncated", h[0]))
+		return
+	}
+	res.Notes = append(res.Notes, fmt.Sprintf("As common handle, the first %d column(s) %s are decoded, the remaining %d bytes %s are a truncated column value",
+		len(datums), datumsString(datumStrings(datums)), len(remain), strings.ToUpper(hex.EncodeToString(remain))))
+}
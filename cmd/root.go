@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/format"
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/options"
 	"github.com/spf13/cobra"
 )
 
@@ -14,11 +16,19 @@ func Execute() {
 		Use:   "tiflash-ctl",
 		Short: "TiFlash Controller",
 		Long:  "TiFlash Controller (tiflash-ctl) is a command line tool for TiFlash Server",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := options.InitLogger(cmd); err != nil {
+				return err
+			}
+			return format.Validate(options.GetOutput(cmd))
+		},
 	}
-	rootCmd.AddCommand(newDispatchCmd(), newCheckCmd())
+	options.AddOutputFlag(rootCmd)
+	options.AddLogFlags(rootCmd)
+	rootCmd.AddCommand(newDispatchCmd(), newCheckCmd(), newKeyCmd())
 
 	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 }
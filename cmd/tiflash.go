@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/logger"
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/pd"
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/tidb"
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/tiflash"
+	"github.com/spf13/cobra"
+)
+
+func addTiFlashPortFlags(c *cobra.Command, opts *tiflash.PortOpts) {
+	c.Flags().IntVar(&opts.HTTPPort, "tiflash_http_port", 0, "The HTTP port of all the TiFlash instances, found from information_schema.cluster_config for each instance if not set")
+	c.Flags().StringToIntVar(&opts.HTTPPorts, "tiflash_http_ports", nil, "The HTTP port of each TiFlash instance by its store address, for example \"10.0.0.1:3930=8123,10.0.0.1:3931=8124\"")
+}
+
+// tiflashInstance is a TiFlash store and the ports to request it
+type tiflashInstance struct {
+	store    pd.Store
+	ip       string
+	httpPort int
+	tcpPort  int
+	// Where the HTTP port is found
+	portSource string
+}
+
+func (i *tiflashInstance) String() string {
+	if i.tcpPort > 0 {
+		return fmt.Sprintf("TiFlash store %d ip: %s:%d (%s) tcp port: %d", i.store.Id, i.ip, i.httpPort, i.portSource, i.tcpPort)
+	}
+	return fmt.Sprintf("TiFlash store %d ip: %s:%d (%s)", i.store.Id, i.ip, i.httpPort, i.portSource)
+}
+
+// getTiFlashInstances returns the TiFlash stores registered in PD and their
+// HTTP ports, see tiflash.ResolveHTTPPorts for how the ports are resolved. The
+// instances are requested by the host of status address in PD.
+func getTiFlashInstances(opts tidb.TiDBClientOpts, portOpts tiflash.PortOpts, client *tidb.Client) ([]tiflashInstance, error) {
+	stores, err := getTiFlashStores(opts, client)
+	if err != nil {
+		return nil, err
+	}
+	portKey := "http_port"
+	if opts.TLS.IsEnabled() {
+		portKey = "https_port"
+	}
+	configs, err := client.GetClusterConfig("tiflash", []string{portKey, "tcp_port"})
+	if err != nil {
+		// Go on with the other ways, cluster_config is not supported by old versions
+		logger.Warnf("Can not get the config of TiFlash from cluster_config, err: %s", err)
+	}
+	addrs := make([]string, 0, len(stores))
+	configPorts := make(map[string]int)
+	for _, store := range stores {
+		addrs = append(addrs, store.Address)
+		if port, err := strconv.Atoi(configs[store.Address][portKey]); err == nil {
+			configPorts[store.Address] = port
+		}
+	}
+	ports, err := tiflash.ResolveHTTPPorts(portOpts, addrs, configPorts)
+	if err != nil {
+		return nil, err
+	}
+
+	instances := make([]tiflashInstance, 0, len(stores))
+	for i, store := range stores {
+		inst := tiflashInstance{store: store, ip: store.StatusHost(), httpPort: ports[i].Port, portSource: ports[i].Source}
+		if inst.portSource == tiflash.PortFromDefault {
+			logger.Warnf("Can not find the HTTP port of TiFlash store %d (%s), use the default port %d. Set it by --tiflash_http_port or --tiflash_http_ports if it is not right",
+				store.Id, store.Address, inst.httpPort)
+		}
+		if port, err := strconv.Atoi(configs[store.Address]["tcp_port"]); err == nil {
+			inst.tcpPort = port
+		}
+		instances = append(instances, inst)
+	}
+	return instances, nil
+}
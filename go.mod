@@ -10,4 +10,5 @@ require (
 	github.com/spf13/cobra v1.8.0
 	github.com/stretchr/testify v1.7.0
 	gopkg.in/check.v1 v1.0.0-20190902080502-41f04d3bba15 // indirect
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,172 @@
+// Copyright 2015 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"github.com/pingcap/errors"
+)
+
+// First byte in the encoded value which specifies the encoding type.
+const (
+	NilFlag          byte = 0
+	bytesFlag        byte = 1
+	compactBytesFlag byte = 2
+	intFlag          byte = 3
+	uintFlag         byte = 4
+	floatFlag        byte = 5
+	decimalFlag      byte = 6
+	durationFlag     byte = 7
+	varintFlag       byte = 8
+	uvarintFlag      byte = 9
+	jsonFlag         byte = 10
+	maxFlag          byte = 250
+)
+
+// EncodeKey appends the encoded values to byte slice b, returns the appended
+// slice. It guarantees the encoded value is in ascending order for comparison.
+func EncodeKey(b []byte, v ...Datum) ([]byte, error) {
+	for _, d := range v {
+		switch d.Kind() {
+		case KindInt64:
+			b = append(b, intFlag)
+			b = EncodeInt(b, d.GetInt64())
+		case KindUint64:
+			b = append(b, uintFlag)
+			b = EncodeUint(b, d.GetUint64())
+		case KindFloat64:
+			b = append(b, floatFlag)
+			b = EncodeFloat(b, d.GetFloat64())
+		case KindBytes:
+			b = append(b, bytesFlag)
+			b = EncodeBytes(b, d.GetBytes())
+		case KindMysqlDecimal:
+			var err error
+			b = append(b, decimalFlag)
+			if b, err = EncodeDecimal(b, d.GetMysqlDecimal(), d.Length(), d.Frac()); err != nil {
+				return b, errors.Trace(err)
+			}
+		case KindMysqlDuration:
+			b = append(b, durationFlag)
+			b = EncodeInt(b, d.GetMysqlDuration())
+		case KindMysqlJSON:
+			typeCode, value := d.GetMysqlJSON()
+			b = append(b, jsonFlag, typeCode)
+			b = append(b, value...)
+		case KindNull:
+			b = append(b, NilFlag)
+		case KindMaxValue:
+			b = append(b, maxFlag)
+		default:
+			return b, errors.Errorf("unsupported encode type %d", d.Kind())
+		}
+	}
+	return b, nil
+}
+
+// Decode decodes values from a byte slice generated with EncodeKey before.
+// size is the size of decoded datum slice.
+func Decode(b []byte, size int) ([]Datum, error) {
+	if len(b) < 1 {
+		return nil, errors.New("invalid encoded key")
+	}
+
+	var (
+		err    error
+		values = make([]Datum, 0, size)
+	)
+
+	for len(b) > 0 {
+		var d Datum
+		b, d, err = DecodeOne(b)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+
+		values = append(values, d)
+	}
+
+	return values, nil
+}
+
+// DecodeOne decodes one datum from a byte slice generated with EncodeKey.
+func DecodeOne(b []byte) (remain []byte, d Datum, err error) {
+	if len(b) < 1 {
+		return nil, d, errors.New("invalid encoded key")
+	}
+	flag := b[0]
+	b = b[1:]
+	switch flag {
+	case intFlag:
+		var v int64
+		b, v, err = DecodeInt(b)
+		d = NewIntDatum(v)
+	case uintFlag:
+		var v uint64
+		b, v, err = DecodeUint(b)
+		d = NewUintDatum(v)
+	case varintFlag:
+		var v int64
+		b, v, err = DecodeVarint(b)
+		d = NewIntDatum(v)
+	case uvarintFlag:
+		var v uint64
+		b, v, err = DecodeUvarint(b)
+		d = NewUintDatum(v)
+	case floatFlag:
+		var v float64
+		b, v, err = DecodeFloat(b)
+		d = NewFloatDatum(v)
+	case bytesFlag:
+		var v []byte
+		b, v, err = DecodeBytes(b, nil)
+		d = NewBytesDatum(v)
+	case compactBytesFlag:
+		var v []byte
+		b, v, err = DecodeCompactBytes(b)
+		d = NewBytesDatum(v)
+	case decimalFlag:
+		var (
+			dec             string
+			precision, frac int
+		)
+		b, dec, precision, frac, err = DecodeDecimal(b)
+		d = NewDecimalDatum(dec, precision, frac)
+	case durationFlag:
+		var v int64
+		b, v, err = DecodeInt(b)
+		// The fsp is not stored in key, keep all the fraction digits
+		d = NewDurationDatum(v, 6)
+	case jsonFlag:
+		if len(b) < 1 {
+			return b, d, errors.New("insufficient bytes to decode value")
+		}
+		typeCode := b[0]
+		var n int
+		if n, err = PeekJSONLength(typeCode, b[1:]); err == nil {
+			d = NewJSONDatum(typeCode, b[1:1+n])
+			b = b[1+n:]
+		}
+	case NilFlag:
+		d = NewNullDatum()
+	case maxFlag:
+		d = NewMaxValueDatum()
+	default:
+		return b, d, errors.Errorf("invalid encoded key flag %v", flag)
+	}
+	if err != nil {
+		return b, d, errors.Trace(err)
+	}
+	return b, d, nil
+}
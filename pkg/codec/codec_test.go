@@ -0,0 +1,199 @@
+package codec_test
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/codec"
+	"github.com/stretchr/testify/assert"
+)
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	assert.Equal(t, err, nil)
+	return b
+}
+
+func TestCommonHandleFromTiDB(t *testing.T) {
+	// The key from the example of `tidb_decode_key`, which is
+	// {"handle":{"a":"6","b":"c4038db2-d51c-11eb-8c75-80e65018a9be"},"table_id":62}
+	const pdKey = "7480000000000000FF3E5F720400000000FF0000000601633430FF3338646232FF2D64FF3531632D3131FF65FF622D386337352DFFFF3830653635303138FFFF61396265000000FF00FB000000000000F9"
+	_, raw, err := codec.DecodeBytes(mustDecodeHex(t, pdKey), nil)
+	assert.Equal(t, err, nil)
+	// skip "t{table_id}_r"
+	datums, err := codec.Decode(raw[11:], 2)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 2, len(datums))
+	assert.Equal(t, codec.KindUint64, datums[0].Kind())
+	assert.Equal(t, uint64(6), datums[0].GetUint64())
+	assert.Equal(t, codec.KindBytes, datums[1].Kind())
+	assert.Equal(t, "c4038db2-d51c-11eb-8c75-80e65018a9be", string(datums[1].GetBytes()))
+
+	encoded, err := codec.EncodeKey(nil, datums...)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, raw[11:], encoded)
+}
+
+func TestDecimal(t *testing.T) {
+	// The examples of `decimal2bin` in MySQL, decimal(14,4)
+	cases := []struct {
+		dec string
+		bin string
+	}{
+		{"1234567890.1234", "810DFB38D204D2"},
+		{"-1234567890.1234", "7EF204C72DFB2D"},
+	}
+	for _, c := range cases {
+		encoded, err := codec.EncodeKey(nil, codec.NewDecimalDatum(c.dec, 14, 4))
+		assert.Equal(t, err, nil)
+		assert.Equal(t, "060E04"+c.bin, strings.ToUpper(hex.EncodeToString(encoded)))
+
+		remain, d, err := codec.DecodeOne(encoded)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, 0, len(remain))
+		assert.Equal(t, codec.KindMysqlDecimal, d.Kind())
+		assert.Equal(t, c.dec, d.GetMysqlDecimal())
+		assert.Equal(t, 14, d.Length())
+		assert.Equal(t, 4, d.Frac())
+	}
+
+	// The encoded decimals keep the order
+	var prev []byte
+	for _, dec := range []string{"-99.99", "-1.50", "-0.01", "0.00", "0.01", "1.50", "99.99"} {
+		encoded, err := codec.EncodeKey(nil, codec.NewDecimalDatum(dec, 4, 2))
+		assert.Equal(t, err, nil)
+		assert.True(t, string(prev) < string(encoded), dec)
+		prev = encoded
+
+		_, d, err := codec.DecodeOne(encoded)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, dec, d.GetMysqlDecimal())
+	}
+
+	_, err := codec.EncodeKey(nil, codec.NewDecimalDatum("100.00", 4, 2))
+	assert.NotNil(t, err)
+}
+
+func TestDatetime(t *testing.T) {
+	// DATETIME(6) '2021-01-02 03:04:05.123456' encoded by `codec.EncodeKey` of
+	// TiDB, it is stored as the packed uint in key
+	tidbKey := mustDecodeHex(t, "0419A884310501E240")
+	remain, d, err := codec.DecodeOne(tidbKey)
+	assert.Equal(t, err, nil)
+	assert.Empty(t, remain)
+	assert.Equal(t, codec.KindUint64, d.Kind())
+	assert.Equal(t, uint64(0x19a884310501e240), d.GetUint64())
+
+	tm := codec.CoreTime{Year: 2021, Month: 1, Day: 2, Hour: 3, Minute: 4, Second: 5, Microsecond: 123456}
+	assert.Equal(t, tm, codec.FromPackedUint(d.GetUint64()))
+	assert.Equal(t, d.GetUint64(), tm.ToPackedUint())
+	assert.Equal(t, "2021-01-02 03:04:05.123", tm.DatetimeString(3))
+	assert.Equal(t, "2021-01-02", tm.DateString())
+
+	parsed, err := codec.ParseCoreTime("2021-01-02 03:04:05.123456")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, tm, parsed)
+	parsed, err = codec.ParseCoreTime("2021-01-02")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, codec.CoreTime{Year: 2021, Month: 1, Day: 2}, parsed)
+
+	encoded, err := codec.EncodeKey(nil, codec.NewUintDatum(tm.ToPackedUint()))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, tidbKey, encoded)
+}
+
+func TestDuration(t *testing.T) {
+	nanos := int64(-(838*time.Hour + 59*time.Minute + 59*time.Second + 500*time.Millisecond))
+	encoded, err := codec.EncodeKey(nil, codec.NewDurationDatum(nanos, 1))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, byte(7), encoded[0])
+	_, d, err := codec.DecodeOne(encoded)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, codec.KindMysqlDuration, d.Kind())
+	assert.Equal(t, nanos, d.GetMysqlDuration())
+	assert.Equal(t, "-838:59:59.500000", d.String())
+	assert.Equal(t, "-838:59:59.5", codec.FormatDuration(nanos, 1))
+
+	parsed, err := codec.ParseDuration("-838:59:59.5")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, nanos, parsed)
+}
+
+func TestJSON(t *testing.T) {
+	// The JSON {"a": 1, "b": [true, "x"]} and the int 1 encoded by
+	// `codec.EncodeKey` of TiDB, the JSON is in the binary format of TiDB
+	tidbKey := mustDecodeHex(t, "0A01020000003C0000001E00000001001F00000001000920000000032800000061620100000000000000"+
+		"020000001400000004010000000C120000000178038000000000000001")
+	datums, err := codec.Decode(tidbKey, 2)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 2, len(datums))
+	assert.Equal(t, codec.KindMysqlJSON, datums[0].Kind())
+	typeCode, value := datums[0].GetMysqlJSON()
+	assert.Equal(t, codec.JSONTypeCodeObject, typeCode)
+	assert.Equal(t, tidbKey[2:len(tidbKey)-9], value)
+	assert.Equal(t, `{"a": 1, "b": [true, "x"]}`, datums[0].String())
+	assert.Equal(t, int64(1), datums[1].GetInt64())
+
+	encoded, err := codec.EncodeKey(nil, codec.NewJSONDatum(typeCode, value), codec.NewIntDatum(1))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, tidbKey, encoded)
+}
+
+func TestMalformedJSON(t *testing.T) {
+	for _, c := range []struct {
+		typeCode byte
+		value    string
+	}{
+		// The size is less than the header
+		{codec.JSONTypeCodeObject, "0100000002000000"},
+		{codec.JSONTypeCodeArray, "0100000000000000"},
+		// The value offset points into the header
+		{codec.JSONTypeCodeArray, "010000000d0000000902000000"},
+		// The value offset is out of range
+		{codec.JSONTypeCodeArray, "010000000d00000009ff000000"},
+		// The key offset points into the header
+		{codec.JSONTypeCodeObject, "01000000140000000000000001000404000000ff"},
+		// The key is out of range
+		{codec.JSONTypeCodeObject, "0100000014000000130000000200040100000061"},
+		// The string length overflows
+		{codec.JSONTypeCodeString, "ffffffffffffffffff01"},
+	} {
+		_, err := codec.JSONToString(c.typeCode, mustDecodeHex(t, c.value))
+		assert.NotEqual(t, err, nil, c.value)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	datums := []codec.Datum{
+		codec.NewNullDatum(),
+		codec.NewIntDatum(-1),
+		codec.NewUintDatum(18446744073709551615),
+		codec.NewFloatDatum(-1.5),
+		codec.NewBytesDatum([]byte("tiflash\x00ctl")),
+		codec.NewDecimalDatum("-12345678901234567890.123456789012", 40, 12),
+		codec.NewDurationDatum(int64(time.Hour), 6),
+		codec.NewMaxValueDatum(),
+	}
+	encoded, err := codec.EncodeKey(nil, datums...)
+	assert.Equal(t, err, nil)
+	decoded, err := codec.Decode(encoded, len(datums))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(datums), len(decoded))
+	for i := range datums {
+		assert.Equal(t, datums[i].Kind(), decoded[i].Kind())
+		assert.Equal(t, datums[i].String(), decoded[i].String())
+	}
+
+	// Float keeps the order
+	a, _ := codec.EncodeKey(nil, codec.NewFloatDatum(-1.5))
+	b, _ := codec.EncodeKey(nil, codec.NewFloatDatum(-0.5))
+	c, _ := codec.EncodeKey(nil, codec.NewFloatDatum(1.5))
+	assert.Equal(t, "054007ffffffffffff", hex.EncodeToString(a))
+	assert.True(t, string(a) < string(b) && string(b) < string(c))
+
+	// Truncated keys can not be decoded
+	_, err = codec.Decode(encoded[:len(encoded)-3], len(datums))
+	assert.NotNil(t, err)
+}
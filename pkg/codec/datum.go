@@ -0,0 +1,154 @@
+package codec
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Kind is the kind of value a Datum holds
+type Kind byte
+
+const (
+	KindNull Kind = iota
+	KindInt64
+	KindUint64
+	KindFloat64
+	KindBytes
+	KindMysqlDecimal
+	KindMysqlDuration
+	KindMysqlJSON
+	KindMaxValue
+)
+
+// Datum is a value decoded from (or to be encoded into) a TiKV key.
+// It only keeps the value as it is stored in the key, the column type
+// is required to know what the value means in SQL. For example, the
+// DATE/DATETIME/TIMESTAMP values are stored as uint64 packed by CoreTime.
+type Datum struct {
+	k Kind
+	i int64
+	u uint64
+	f float64
+	b []byte
+	// length and frac are the precision and frac of decimal, frac is
+	// also the fsp of duration
+	length int
+	frac   int
+	// the type code of binary JSON stored in b
+	jsonTypeCode byte
+}
+
+func NewNullDatum() Datum {
+	return Datum{k: KindNull}
+}
+
+func NewIntDatum(v int64) Datum {
+	return Datum{k: KindInt64, i: v}
+}
+
+func NewUintDatum(v uint64) Datum {
+	return Datum{k: KindUint64, u: v}
+}
+
+func NewFloatDatum(v float64) Datum {
+	return Datum{k: KindFloat64, f: v}
+}
+
+func NewBytesDatum(v []byte) Datum {
+	return Datum{k: KindBytes, b: v}
+}
+
+// NewDecimalDatum returns a decimal datum, the precision and frac are
+// required for encoding the decimal into key.
+func NewDecimalDatum(dec string, precision, frac int) Datum {
+	return Datum{k: KindMysqlDecimal, b: []byte(dec), length: precision, frac: frac}
+}
+
+// NewDurationDatum returns a duration datum of nanoseconds
+func NewDurationDatum(nanos int64, fsp int) Datum {
+	return Datum{k: KindMysqlDuration, i: nanos, frac: fsp}
+}
+
+// NewJSONDatum returns a JSON datum of TiDB binary JSON
+func NewJSONDatum(typeCode byte, value []byte) Datum {
+	return Datum{k: KindMysqlJSON, jsonTypeCode: typeCode, b: value}
+}
+
+func NewMaxValueDatum() Datum {
+	return Datum{k: KindMaxValue}
+}
+
+func (d *Datum) Kind() Kind {
+	return d.k
+}
+
+func (d *Datum) IsNull() bool {
+	return d.k == KindNull
+}
+
+func (d *Datum) GetInt64() int64 {
+	return d.i
+}
+
+func (d *Datum) GetUint64() uint64 {
+	return d.u
+}
+
+func (d *Datum) GetFloat64() float64 {
+	return d.f
+}
+
+func (d *Datum) GetBytes() []byte {
+	return d.b
+}
+
+func (d *Datum) GetMysqlDecimal() string {
+	return string(d.b)
+}
+
+func (d *Datum) GetMysqlDuration() int64 {
+	return d.i
+}
+
+// GetMysqlJSON returns the type code and value of binary JSON
+func (d *Datum) GetMysqlJSON() (byte, []byte) {
+	return d.jsonTypeCode, d.b
+}
+
+// Length returns the precision of decimal
+func (d *Datum) Length() int {
+	return d.length
+}
+
+// Frac returns the frac of decimal or the fsp of duration
+func (d *Datum) Frac() int {
+	return d.frac
+}
+
+func (d Datum) String() string {
+	switch d.k {
+	case KindNull:
+		return "NULL"
+	case KindInt64:
+		return strconv.FormatInt(d.i, 10)
+	case KindUint64:
+		return strconv.FormatUint(d.u, 10)
+	case KindFloat64:
+		return strconv.FormatFloat(d.f, 'g', -1, 64)
+	case KindBytes:
+		return strconv.Quote(string(d.b))
+	case KindMysqlDecimal:
+		return string(d.b)
+	case KindMysqlDuration:
+		return FormatDuration(d.i, d.frac)
+	case KindMysqlJSON:
+		s, err := JSONToString(d.jsonTypeCode, d.b)
+		if err != nil {
+			return fmt.Sprintf("invalid json, %s", err)
+		}
+		return s
+	case KindMaxValue:
+		return "MaxValue"
+	}
+	return fmt.Sprintf("unknown kind %d", d.k)
+}
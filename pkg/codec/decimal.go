@@ -0,0 +1,227 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pingcap/errors"
+)
+
+const (
+	digitsPerWord   = 9
+	decimalWordSize = 4
+	// MaxDecimalScale is the maximum number of digits after the point
+	MaxDecimalScale = 30
+	// MaxDecimalWidth is the maximum number of digits of a decimal
+	MaxDecimalWidth = 65
+)
+
+var dig2bytes = [10]int{0, 1, 1, 2, 2, 3, 3, 4, 4, 4}
+
+// decimalBinSize returns the size of the binary format of a decimal with
+// precision and frac.
+func decimalBinSize(precision, frac int) int {
+	digitsInt := precision - frac
+	wordsInt := digitsInt / digitsPerWord
+	wordsFrac := frac / digitsPerWord
+	xInt := digitsInt - wordsInt*digitsPerWord
+	xFrac := frac - wordsFrac*digitsPerWord
+	return wordsInt*decimalWordSize + dig2bytes[xInt] + wordsFrac*decimalWordSize + dig2bytes[xFrac]
+}
+
+func checkDecimalPrecision(precision, frac int) error {
+	if precision <= 0 || precision > MaxDecimalWidth || frac < 0 || frac > MaxDecimalScale || frac > precision {
+		return errors.Errorf("invalid decimal precision %d and frac %d", precision, frac)
+	}
+	return nil
+}
+
+// putDigits writes the value of digits as a big-endian integer of size bytes.
+func putDigits(b []byte, digits string) {
+	var v uint32
+	for _, c := range digits {
+		v = v*10 + uint32(c-'0')
+	}
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i] = byte(v)
+		v >>= 8
+	}
+}
+
+// readDigits reads a big-endian integer and formats it as digits with the given width.
+func readDigits(b []byte, width int) string {
+	var v uint32
+	for _, c := range b {
+		v = v<<8 | uint32(c)
+	}
+	return fmt.Sprintf("%0*d", width, v)
+}
+
+// decimalToBin converts a decimal string to the binary format with precision and frac.
+// The binary format is the same as `decimal2bin` of MySQL, which is memcomparable
+// between the decimals with the same precision and frac.
+func decimalToBin(dec string, precision, frac int) ([]byte, error) {
+	if err := checkDecimalPrecision(precision, frac); err != nil {
+		return nil, err
+	}
+	negative := false
+	s := strings.TrimSpace(dec)
+	if strings.HasPrefix(s, "-") {
+		negative = true
+		s = s[1:]
+	} else if strings.HasPrefix(s, "+") {
+		s = s[1:]
+	}
+	intPart, fracPart := s, ""
+	if idx := strings.IndexByte(s, '.'); idx >= 0 {
+		intPart, fracPart = s[:idx], s[idx+1:]
+	}
+	for _, c := range intPart + fracPart {
+		if c < '0' || c > '9' {
+			return nil, errors.Errorf("invalid decimal %q", dec)
+		}
+	}
+	intPart = strings.TrimLeft(intPart, "0")
+	digitsInt := precision - frac
+	if len(intPart) > digitsInt {
+		return nil, errors.Errorf("decimal %q overflows precision %d and frac %d", dec, precision, frac)
+	}
+	if len(fracPart) > frac {
+		if strings.Trim(fracPart[frac:], "0") != "" {
+			return nil, errors.Errorf("decimal %q is truncated by frac %d", dec, frac)
+		}
+		fracPart = fracPart[:frac]
+	}
+	intPart = strings.Repeat("0", digitsInt-len(intPart)) + intPart
+	fracPart = fracPart + strings.Repeat("0", frac-len(fracPart))
+	if strings.Trim(intPart+fracPart, "0") == "" {
+		// There is no negative zero
+		negative = false
+	}
+
+	bin := make([]byte, decimalBinSize(precision, frac))
+	pos := 0
+	xInt := digitsInt % digitsPerWord
+	if xInt > 0 {
+		putDigits(bin[pos:pos+dig2bytes[xInt]], intPart[:xInt])
+		pos += dig2bytes[xInt]
+	}
+	for i := xInt; i < digitsInt; i += digitsPerWord {
+		putDigits(bin[pos:pos+decimalWordSize], intPart[i:i+digitsPerWord])
+		pos += decimalWordSize
+	}
+	wordsFrac := frac / digitsPerWord
+	for i := 0; i < wordsFrac*digitsPerWord; i += digitsPerWord {
+		putDigits(bin[pos:pos+decimalWordSize], fracPart[i:i+digitsPerWord])
+		pos += decimalWordSize
+	}
+	xFrac := frac % digitsPerWord
+	if xFrac > 0 {
+		putDigits(bin[pos:pos+dig2bytes[xFrac]], fracPart[wordsFrac*digitsPerWord:])
+	}
+
+	if negative {
+		for i := range bin {
+			bin[i] = ^bin[i]
+		}
+	}
+	bin[0] ^= 0x80
+	return bin, nil
+}
+
+// decimalFromBin converts the binary format with precision and frac to a decimal string.
+func decimalFromBin(bin []byte, precision, frac int) (string, error) {
+	if err := checkDecimalPrecision(precision, frac); err != nil {
+		return "", err
+	}
+	binSize := decimalBinSize(precision, frac)
+	if len(bin) < binSize {
+		return "", errors.New("insufficient bytes to decode value")
+	}
+	b := make([]byte, binSize)
+	copy(b, bin[:binSize])
+	b[0] ^= 0x80
+	negative := b[0]&0x80 != 0
+	if negative {
+		for i := range b {
+			b[i] = ^b[i]
+		}
+	}
+
+	var intPart, fracPart strings.Builder
+	pos := 0
+	digitsInt := precision - frac
+	xInt := digitsInt % digitsPerWord
+	if xInt > 0 {
+		intPart.WriteString(readDigits(b[pos:pos+dig2bytes[xInt]], xInt))
+		pos += dig2bytes[xInt]
+	}
+	for i := xInt; i < digitsInt; i += digitsPerWord {
+		intPart.WriteString(readDigits(b[pos:pos+decimalWordSize], digitsPerWord))
+		pos += decimalWordSize
+	}
+	wordsFrac := frac / digitsPerWord
+	for i := 0; i < wordsFrac; i++ {
+		fracPart.WriteString(readDigits(b[pos:pos+decimalWordSize], digitsPerWord))
+		pos += decimalWordSize
+	}
+	if xFrac := frac % digitsPerWord; xFrac > 0 {
+		fracPart.WriteString(readDigits(b[pos:pos+dig2bytes[xFrac]], xFrac))
+	}
+
+	if intPart.Len() > digitsInt || fracPart.Len() > frac {
+		return "", errors.Errorf("invalid decimal bin %x", bin[:binSize])
+	}
+	s := strings.TrimLeft(intPart.String(), "0")
+	if s == "" {
+		s = "0"
+	}
+	if frac > 0 {
+		s += "." + fracPart.String()
+	}
+	if negative {
+		s = "-" + s
+	}
+	return s, nil
+}
+
+// EncodeDecimal encodes a decimal into a byte slice which can be sorted lexicographically later.
+// The precision and frac are encoded at first, so only the decimals with the same precision
+// and frac are comparable.
+func EncodeDecimal(b []byte, dec string, precision, frac int) ([]byte, error) {
+	bin, err := decimalToBin(dec, precision, frac)
+	if err != nil {
+		return b, errors.Trace(err)
+	}
+	b = append(b, byte(precision), byte(frac))
+	return append(b, bin...), nil
+}
+
+// DecodeDecimal decodes bytes to decimal.
+// It returns the leftover bytes, the decimal string, precision and frac.
+func DecodeDecimal(b []byte) ([]byte, string, int, int, error) {
+	if len(b) < 3 {
+		return b, "", 0, 0, errors.New("insufficient bytes to decode value")
+	}
+	precision, frac := int(b[0]), int(b[1])
+	b = b[2:]
+	dec, err := decimalFromBin(b, precision, frac)
+	if err != nil {
+		return b, "", 0, 0, errors.Trace(err)
+	}
+	return b[decimalBinSize(precision, frac):], dec, precision, frac, nil
+}
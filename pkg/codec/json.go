@@ -0,0 +1,216 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/pingcap/errors"
+)
+
+// The type codes of TiDB binary JSON
+const (
+	JSONTypeCodeObject    byte = 0x01
+	JSONTypeCodeArray     byte = 0x03
+	JSONTypeCodeLiteral   byte = 0x04
+	JSONTypeCodeInt64     byte = 0x09
+	JSONTypeCodeUint64    byte = 0x0a
+	JSONTypeCodeFloat64   byte = 0x0b
+	JSONTypeCodeString    byte = 0x0c
+	JSONTypeCodeOpaque    byte = 0x0d
+	JSONTypeCodeDate      byte = 0x0e
+	JSONTypeCodeDatetime  byte = 0x0f
+	JSONTypeCodeTimestamp byte = 0x10
+	JSONTypeCodeDuration  byte = 0x11
+)
+
+const (
+	jsonLiteralNil   byte = 0x00
+	jsonLiteralTrue  byte = 0x01
+	jsonLiteralFalse byte = 0x02
+)
+
+const (
+	jsonHeaderSize   = 8 // element count and total size, both uint32
+	jsonKeyEntrySize = 6 // key offset uint32 and key length uint16
+	jsonValEntrySize = 5 // type code and value offset uint32
+)
+
+var errJSONInsufficient = errors.New("insufficient bytes to decode json")
+
+// PeekJSONLength returns the length of the binary JSON value with typeCode
+// at the beginning of b.
+func PeekJSONLength(typeCode byte, b []byte) (int, error) {
+	var n int
+	switch typeCode {
+	case JSONTypeCodeObject, JSONTypeCodeArray:
+		if len(b) < jsonHeaderSize {
+			return 0, errJSONInsufficient
+		}
+		n = int(binary.LittleEndian.Uint32(b[4:]))
+		if n < jsonHeaderSize {
+			return 0, errJSONInsufficient
+		}
+	case JSONTypeCodeLiteral:
+		n = 1
+	case JSONTypeCodeInt64, JSONTypeCodeUint64, JSONTypeCodeFloat64,
+		JSONTypeCodeDate, JSONTypeCodeDatetime, JSONTypeCodeTimestamp:
+		n = 8
+	case JSONTypeCodeDuration:
+		n = 12
+	case JSONTypeCodeString:
+		strLen, lenLen := binary.Uvarint(b)
+		if lenLen <= 0 || strLen > uint64(len(b)) {
+			return 0, errJSONInsufficient
+		}
+		n = lenLen + int(strLen)
+	case JSONTypeCodeOpaque:
+		if len(b) < 1 {
+			return 0, errJSONInsufficient
+		}
+		dataLen, lenLen := binary.Uvarint(b[1:])
+		if lenLen <= 0 || dataLen > uint64(len(b)) {
+			return 0, errJSONInsufficient
+		}
+		n = 1 + lenLen + int(dataLen)
+	default:
+		return 0, errors.Errorf("invalid json type code %d", typeCode)
+	}
+	if len(b) < n {
+		return 0, errJSONInsufficient
+	}
+	return n, nil
+}
+
+// JSONToString converts the binary JSON value to JSON text
+func JSONToString(typeCode byte, value []byte) (string, error) {
+	var buf bytes.Buffer
+	if err := marshalJSONTo(&buf, typeCode, value); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func quoteJSONString(buf *bytes.Buffer, s string) error {
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(s); err != nil {
+		return errors.Trace(err)
+	}
+	// Remove the newline appended by Encode
+	buf.Truncate(buf.Len() - 1)
+	return nil
+}
+
+func marshalJSONTo(buf *bytes.Buffer, typeCode byte, value []byte) error {
+	n, err := PeekJSONLength(typeCode, value)
+	if err != nil {
+		return err
+	}
+	value = value[:n]
+	switch typeCode {
+	case JSONTypeCodeObject, JSONTypeCodeArray:
+		return marshalJSONContainerTo(buf, typeCode, value)
+	case JSONTypeCodeLiteral:
+		switch value[0] {
+		case jsonLiteralNil:
+			buf.WriteString("null")
+		case jsonLiteralTrue:
+			buf.WriteString("true")
+		case jsonLiteralFalse:
+			buf.WriteString("false")
+		default:
+			return errors.Errorf("invalid json literal %d", value[0])
+		}
+	case JSONTypeCodeInt64:
+		buf.WriteString(strconv.FormatInt(int64(binary.LittleEndian.Uint64(value)), 10))
+	case JSONTypeCodeUint64:
+		buf.WriteString(strconv.FormatUint(binary.LittleEndian.Uint64(value), 10))
+	case JSONTypeCodeFloat64:
+		f := decodeJSONFloat64(value)
+		buf.WriteString(strconv.FormatFloat(f, 'g', -1, 64))
+	case JSONTypeCodeString:
+		strLen, lenLen := binary.Uvarint(value)
+		return quoteJSONString(buf, string(value[lenLen:lenLen+int(strLen)]))
+	case JSONTypeCodeOpaque:
+		_, lenLen := binary.Uvarint(value[1:])
+		data := value[1+lenLen:]
+		return quoteJSONString(buf, fmt.Sprintf("base64:type%d:%s", value[0], base64.StdEncoding.EncodeToString(data)))
+	case JSONTypeCodeDate:
+		t := FromPackedUint(binary.LittleEndian.Uint64(value))
+		return quoteJSONString(buf, t.DateString())
+	case JSONTypeCodeDatetime, JSONTypeCodeTimestamp:
+		t := FromPackedUint(binary.LittleEndian.Uint64(value))
+		return quoteJSONString(buf, t.DatetimeString(6))
+	case JSONTypeCodeDuration:
+		nanos := int64(binary.LittleEndian.Uint64(value))
+		fsp := int(binary.LittleEndian.Uint32(value[8:]))
+		return quoteJSONString(buf, FormatDuration(nanos, fsp))
+	}
+	return nil
+}
+
+func decodeJSONFloat64(b []byte) float64 {
+	return math.Float64frombits(binary.LittleEndian.Uint64(b))
+}
+
+func marshalJSONContainerTo(buf *bytes.Buffer, typeCode byte, value []byte) error {
+	elemCount := int(binary.LittleEndian.Uint32(value))
+	valEntryStart := jsonHeaderSize
+	if typeCode == JSONTypeCodeObject {
+		valEntryStart += elemCount * jsonKeyEntrySize
+	}
+	if len(value) < valEntryStart+elemCount*jsonValEntrySize {
+		return errJSONInsufficient
+	}
+
+	if typeCode == JSONTypeCodeObject {
+		buf.WriteByte('{')
+	} else {
+		buf.WriteByte('[')
+	}
+	for i := 0; i < elemCount; i++ {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		if typeCode == JSONTypeCodeObject {
+			keyEntry := value[jsonHeaderSize+i*jsonKeyEntrySize:]
+			keyOff := int(binary.LittleEndian.Uint32(keyEntry))
+			keyLen := int(binary.LittleEndian.Uint16(keyEntry[4:]))
+			// The keys are after the header and the entries
+			if keyOff < jsonHeaderSize || len(value) < keyOff+keyLen {
+				return errJSONInsufficient
+			}
+			if err := quoteJSONString(buf, string(value[keyOff:keyOff+keyLen])); err != nil {
+				return err
+			}
+			buf.WriteString(": ")
+		}
+		valEntry := value[valEntryStart+i*jsonValEntrySize:]
+		elemTypeCode := valEntry[0]
+		var elem []byte
+		if elemTypeCode == JSONTypeCodeLiteral {
+			// The literal is inlined in the value entry
+			elem = valEntry[1:2]
+		} else {
+			valOff := int(binary.LittleEndian.Uint32(valEntry[1:]))
+			if valOff < jsonHeaderSize || len(value) <= valOff {
+				return errJSONInsufficient
+			}
+			elem = value[valOff:]
+		}
+		if err := marshalJSONTo(buf, elemTypeCode, elem); err != nil {
+			return err
+		}
+	}
+	if typeCode == JSONTypeCodeObject {
+		buf.WriteByte('}')
+	} else {
+		buf.WriteByte(']')
+	}
+	return nil
+}
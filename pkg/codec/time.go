@@ -0,0 +1,162 @@
+package codec
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pingcap/errors"
+)
+
+// CoreTime is the date and time of a MySQL DATE/DATETIME/TIMESTAMP value.
+// In TiKV keys, it is packed into a uint64 and encoded with uintFlag, and
+// the TIMESTAMP value is converted to UTC before packing.
+type CoreTime struct {
+	Year        int
+	Month       int
+	Day         int
+	Hour        int
+	Minute      int
+	Second      int
+	Microsecond int
+}
+
+// ToPackedUint packs the time into a uint64, which keeps the order of time:
+//
+//	ymd    = (year * 13 + month) << 5 | day
+//	hms    = hour << 12 | minute << 6 | second
+//	packed = (ymd << 17 | hms) << 24 | microsecond
+func (t CoreTime) ToPackedUint() uint64 {
+	ymd := uint64(((t.Year*13 + t.Month) << 5) | t.Day)
+	hms := uint64(t.Hour<<12 | t.Minute<<6 | t.Second)
+	micro := uint64(t.Microsecond)
+	return ((ymd<<17 | hms) << 24) | micro
+}
+
+// FromPackedUint unpacks the uint64 packed by ToPackedUint
+func FromPackedUint(packed uint64) CoreTime {
+	if packed == 0 {
+		return CoreTime{}
+	}
+	ymdhms := packed >> 24
+	ymd := ymdhms >> 17
+	ym := ymd >> 5
+	hms := ymdhms % (1 << 17)
+	return CoreTime{
+		Year:        int(ym / 13),
+		Month:       int(ym % 13),
+		Day:         int(ymd % (1 << 5)),
+		Hour:        int(hms >> 12),
+		Minute:      int((hms >> 6) % (1 << 6)),
+		Second:      int(hms % (1 << 6)),
+		Microsecond: int(packed % (1 << 24)),
+	}
+}
+
+// DateString formats the time as "YYYY-MM-DD"
+func (t CoreTime) DateString() string {
+	return fmt.Sprintf("%04d-%02d-%02d", t.Year, t.Month, t.Day)
+}
+
+// DatetimeString formats the time as "YYYY-MM-DD HH:MM:SS[.fraction]" with
+// fsp digits of fraction.
+func (t CoreTime) DatetimeString(fsp int) string {
+	s := fmt.Sprintf("%s %02d:%02d:%02d", t.DateString(), t.Hour, t.Minute, t.Second)
+	return s + formatFraction(t.Microsecond, fsp)
+}
+
+func formatFraction(microsecond int, fsp int) string {
+	if fsp <= 0 {
+		return ""
+	}
+	if fsp > 6 {
+		fsp = 6
+	}
+	return "." + fmt.Sprintf("%06d", microsecond)[:fsp]
+}
+
+func parseFraction(s string) (int, error) {
+	if len(s) > 6 {
+		s = s[:6]
+	}
+	v, err := strconv.Atoi(s + strings.Repeat("0", 6-len(s)))
+	return v, errors.Trace(err)
+}
+
+// ParseCoreTime parses the time in format "YYYY-MM-DD[ HH:MM:SS[.fraction]]",
+// which is the format returned by MySQL protocol.
+func ParseCoreTime(s string) (CoreTime, error) {
+	var t CoreTime
+	datePart, timePart := s, ""
+	if idx := strings.IndexByte(s, ' '); idx >= 0 {
+		datePart, timePart = s[:idx], s[idx+1:]
+	}
+	if _, err := fmt.Sscanf(datePart, "%d-%d-%d", &t.Year, &t.Month, &t.Day); err != nil {
+		return t, errors.Errorf("invalid time %q", s)
+	}
+	if timePart == "" {
+		return t, nil
+	}
+	fracPart := ""
+	if idx := strings.IndexByte(timePart, '.'); idx >= 0 {
+		timePart, fracPart = timePart[:idx], timePart[idx+1:]
+	}
+	if _, err := fmt.Sscanf(timePart, "%d:%d:%d", &t.Hour, &t.Minute, &t.Second); err != nil {
+		return t, errors.Errorf("invalid time %q", s)
+	}
+	if fracPart != "" {
+		micro, err := parseFraction(fracPart)
+		if err != nil {
+			return t, errors.Errorf("invalid time %q", s)
+		}
+		t.Microsecond = micro
+	}
+	return t, nil
+}
+
+// FormatDuration formats the nanoseconds of a MySQL TIME value as
+// "[-]HH:MM:SS[.fraction]" with fsp digits of fraction.
+func FormatDuration(nanos int64, fsp int) string {
+	sign := ""
+	d := time.Duration(nanos)
+	if d < 0 {
+		sign = "-"
+		d = -d
+	}
+	hours := d / time.Hour
+	d -= hours * time.Hour
+	minutes := d / time.Minute
+	d -= minutes * time.Minute
+	seconds := d / time.Second
+	d -= seconds * time.Second
+	micro := int(d / time.Microsecond)
+	return fmt.Sprintf("%s%02d:%02d:%02d", sign, hours, minutes, seconds) + formatFraction(micro, fsp)
+}
+
+// ParseDuration parses a MySQL TIME value in format "[-]HH:MM:SS[.fraction]"
+// and returns the nanoseconds.
+func ParseDuration(s string) (int64, error) {
+	negative := strings.HasPrefix(s, "-")
+	str := strings.TrimPrefix(s, "-")
+	fracPart := ""
+	if idx := strings.IndexByte(str, '.'); idx >= 0 {
+		str, fracPart = str[:idx], str[idx+1:]
+	}
+	var hours, minutes, seconds int64
+	if _, err := fmt.Sscanf(str, "%d:%d:%d", &hours, &minutes, &seconds); err != nil {
+		return 0, errors.Errorf("invalid duration %q", s)
+	}
+	nanos := int64(time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute + time.Duration(seconds)*time.Second)
+	if fracPart != "" {
+		micro, err := parseFraction(fracPart)
+		if err != nil {
+			return 0, errors.Errorf("invalid duration %q", s)
+		}
+		nanos += int64(micro) * int64(time.Microsecond)
+	}
+	if negative {
+		nanos = -nanos
+	}
+	return nanos, nil
+}
@@ -0,0 +1,111 @@
+package format
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/olekukonko/tablewriter"
+	"gopkg.in/yaml.v3"
+)
+
+// The output formats of the results
+const (
+	Text = "text"
+	JSON = "json"
+	YAML = "yaml"
+	CSV  = "csv"
+)
+
+// TextWriter returns where the commands print the results as text. It is
+// stdout for the text output. When the output is structured, the rendered
+// results are written to stdout, and the text goes to stderr so that they are
+// not mixed.
+func TextWriter(format string) io.Writer {
+	if IsStructured(format) {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// Table is the result that can be rendered as rows, it is required by CSV
+type Table interface {
+	Header() []string
+	Rows() [][]string
+}
+
+func Validate(format string) error {
+	switch format {
+	case Text, JSON, YAML, CSV:
+		return nil
+	}
+	return fmt.Errorf("unknown output format %s, should be one of text|json|yaml|csv", format)
+}
+
+// IsStructured returns whether the results are rendered by the format instead
+// of printing as text
+func IsStructured(format string) bool {
+	return format != "" && format != Text
+}
+
+// Render writes the result in the format. The json and yaml keys are the json
+// tags of the fields.
+func Render(w io.Writer, format string, v interface{}) error {
+	switch format {
+	case JSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case YAML:
+		// Marshal to JSON first so that the keys are the same as JSON
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		var node yaml.Node
+		if err = yaml.Unmarshal(data, &node); err != nil {
+			return err
+		}
+		// The JSON is parsed in flow style, render it in block style
+		clearStyle(&node)
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err = enc.Encode(&node); err != nil {
+			return err
+		}
+		return enc.Close()
+	case CSV:
+		t, ok := v.(Table)
+		if !ok {
+			return fmt.Errorf("the result can not be rendered as csv")
+		}
+		cw := csv.NewWriter(w)
+		if err := cw.Write(t.Header()); err != nil {
+			return err
+		}
+		if err := cw.WriteAll(t.Rows()); err != nil {
+			return err
+		}
+		return cw.Error()
+	case Text, "":
+		if t, ok := v.(Table); ok {
+			table := tablewriter.NewWriter(w)
+			table.SetHeader(t.Header())
+			table.AppendBulk(t.Rows())
+			table.Render()
+			return nil
+		}
+		_, err := fmt.Fprintf(w, "%+v\n", v)
+		return err
+	}
+	return Validate(format)
+}
+
+func clearStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, n := range node.Content {
+		clearStyle(n)
+	}
+}
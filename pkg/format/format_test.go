@@ -0,0 +1,53 @@
+package format_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/format"
+	"github.com/stretchr/testify/assert"
+)
+
+type testRow struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+type testResult struct {
+	Items []testRow `json:"rows"`
+}
+
+func (r *testResult) Header() []string {
+	return []string{"id", "name"}
+}
+
+func (r *testResult) Rows() [][]string {
+	return [][]string{{"1", "a,b"}, {"2", "c"}}
+}
+
+func TestRender(t *testing.T) {
+	res := &testResult{Items: []testRow{{ID: 1, Name: "a,b"}, {ID: 2, Name: "c"}}}
+
+	var buf bytes.Buffer
+	assert.Equal(t, format.Render(&buf, format.JSON, res), nil)
+	assert.JSONEq(t, `{"rows": [{"id": 1, "name": "a,b"}, {"id": 2, "name": "c"}]}`, buf.String())
+
+	buf.Reset()
+	assert.Equal(t, format.Render(&buf, format.YAML, res), nil)
+	assert.Equal(t, "rows:\n  - id: 1\n    name: a,b\n  - id: 2\n    name: c\n", buf.String())
+
+	buf.Reset()
+	assert.Equal(t, format.Render(&buf, format.CSV, res), nil)
+	assert.Equal(t, "id,name\n1,\"a,b\"\n2,c\n", buf.String())
+
+	buf.Reset()
+	assert.Equal(t, format.Render(&buf, format.Text, res), nil)
+	assert.Contains(t, buf.String(), "| ID | NAME |")
+
+	// Only the result with rows can be rendered as csv
+	assert.NotEqual(t, format.Render(&buf, format.CSV, res.Items), nil)
+	assert.NotEqual(t, format.Render(&buf, "xml", res), nil)
+	assert.NotEqual(t, format.Validate("xml"), nil)
+	assert.True(t, format.IsStructured(format.JSON))
+	assert.False(t, format.IsStructured(format.Text))
+}
@@ -0,0 +1,127 @@
+package logger
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of the logs, the logs below the level of Logger are
+// discarded
+type Level int
+
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < DebugLevel || l > ErrorLevel {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+func ParseLevel(s string) (Level, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return Level(i), nil
+		}
+	}
+	return InfoLevel, fmt.Errorf("unknown log level %s, should be one of %s", s, strings.Join(levelNames, "|"))
+}
+
+// The layout of timestamp at the beginning of each log
+const timeLayout = "2006/01/02 15:04:05.000 -07:00"
+
+// Logger writes the diagnostic messages with the timestamp and level, it is
+// safe to be used by multiple goroutines
+type Logger struct {
+	mu    sync.Mutex
+	out   io.Writer
+	level Level
+}
+
+func New(out io.Writer, level Level) *Logger {
+	return &Logger{out: out, level: level}
+}
+
+func (l *Logger) SetOutput(out io.Writer) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out = out
+}
+
+func (l *Logger) SetLevel(level Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.level = level
+}
+
+// Enabled returns whether the logs of the level are written
+func (l *Logger) Enabled(level Level) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return level >= l.level
+}
+
+// Logf writes a line of log like `[2006/01/02 15:04:05.000 +08:00] [INFO] message`
+func (l *Logger) Logf(level Level, format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if level < l.level {
+		return
+	}
+	msg := strings.TrimRight(fmt.Sprintf(format, args...), "\n")
+	fmt.Fprintf(l.out, "[%s] [%s] %s\n", time.Now().Format(timeLayout), strings.ToUpper(level.String()), msg)
+}
+
+// std is the logger used by the commands, it writes to stderr so that the
+// results on stdout are not mixed with the logs
+var std = New(os.Stderr, InfoLevel)
+
+// Init sets the level of logs and the file to write to, the logs are written
+// to stderr if file is empty. The file is appended and kept open until the
+// process exits.
+func Init(level string, file string) error {
+	lvl, err := ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	if file != "" {
+		f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("open log file %s fail: %s", file, err)
+		}
+		std.SetOutput(f)
+	}
+	std.SetLevel(lvl)
+	return nil
+}
+
+func Enabled(level Level) bool {
+	return std.Enabled(level)
+}
+
+func Debugf(format string, args ...interface{}) {
+	std.Logf(DebugLevel, format, args...)
+}
+
+func Infof(format string, args ...interface{}) {
+	std.Logf(InfoLevel, format, args...)
+}
+
+func Warnf(format string, args ...interface{}) {
+	std.Logf(WarnLevel, format, args...)
+}
+
+func Errorf(format string, args ...interface{}) {
+	std.Logf(ErrorLevel, format, args...)
+}
@@ -0,0 +1,44 @@
+package logger_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLevel(t *testing.T) {
+	for _, c := range []struct {
+		s     string
+		level logger.Level
+	}{
+		{"debug", logger.DebugLevel},
+		{"INFO", logger.InfoLevel},
+		{"Warn", logger.WarnLevel},
+		{"error", logger.ErrorLevel},
+	} {
+		level, err := logger.ParseLevel(c.s)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, c.level, level)
+	}
+	_, err := logger.ParseLevel("trace")
+	assert.NotEqual(t, err, nil)
+}
+
+func TestLogf(t *testing.T) {
+	var buf bytes.Buffer
+	l := logger.New(&buf, logger.InfoLevel)
+	assert.False(t, l.Enabled(logger.DebugLevel))
+	assert.True(t, l.Enabled(logger.WarnLevel))
+
+	l.Logf(logger.DebugLevel, "select %d", 1)
+	assert.Equal(t, "", buf.String())
+	l.Logf(logger.WarnLevel, "Region %d is not found\n", 1)
+	assert.Regexp(t, `^\[\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2}\.\d{3} [+-]\d{2}:\d{2}\] \[WARN\] Region 1 is not found\n$`, buf.String())
+
+	buf.Reset()
+	l.SetLevel(logger.DebugLevel)
+	l.Logf(logger.DebugLevel, "select %d", 1)
+	assert.Regexp(t, `\[DEBUG\] select 1\n$`, buf.String())
+}
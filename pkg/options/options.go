@@ -1,6 +1,8 @@
 package options
 
 import (
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/format"
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/logger"
 	"github.com/JaySon-Huang/tiflash-ctl/pkg/tidb"
 	"github.com/spf13/cobra"
 )
@@ -10,4 +12,46 @@ func AddTiDBConnFlags(c *cobra.Command, tidbFlags *tidb.TiDBClientOpts) {
 	c.Flags().Int32Var(&tidbFlags.Port, "tidb_port", 4000, "The port of TiDB instance")
 	c.Flags().StringVar(&tidbFlags.User, "user", "root", "TiDB user")
 	c.Flags().StringVar(&tidbFlags.Password, "password", "", "TiDB user password")
+	c.Flags().StringVar(&tidbFlags.TLS.CA, "ca", "", "The path of CA certificate for the TLS-enabled cluster, used for PD, TiFlash and TiDB unless the tidb_* certificates are set")
+	c.Flags().StringVar(&tidbFlags.TLS.Cert, "cert", "", "The path of client certificate for the TLS-enabled cluster")
+	c.Flags().StringVar(&tidbFlags.TLS.Key, "key", "", "The path of client key for the TLS-enabled cluster")
+	c.Flags().StringVar(&tidbFlags.SQLTLS.CA, "tidb_ca", "", "The path of CA certificate for the TLS connection to TiDB, the ca, cert and key are used if none of the tidb_ca, tidb_cert and tidb_key is set")
+	c.Flags().StringVar(&tidbFlags.SQLTLS.Cert, "tidb_cert", "", "The path of client certificate for the TLS connection to TiDB")
+	c.Flags().StringVar(&tidbFlags.SQLTLS.Key, "tidb_key", "", "The path of client key for the TLS connection to TiDB")
+	c.Flags().StringSliceVar(&tidbFlags.PDAddrs, "pd", nil, "The comma separated PD addresses, found from information_schema.cluster_info if not set")
+}
+
+// AddOutputFlag adds the flag of output format to the root command, it is
+// inherited by all the subcommands
+func AddOutputFlag(c *cobra.Command) {
+	c.PersistentFlags().String("output", format.Text, "The output format of the results, text|json|yaml|csv")
+}
+
+// GetOutput returns the output format set to the root command
+func GetOutput(c *cobra.Command) string {
+	output, err := c.Flags().GetString("output")
+	if err != nil {
+		return format.Text
+	}
+	return output
+}
+
+// AddLogFlags adds the flags of logging to the root command, the logs are
+// written to stderr if the log file is not set
+func AddLogFlags(c *cobra.Command) {
+	c.PersistentFlags().String("log-level", "info", "The level of logs written to stderr or the log file, debug|info|warn|error. The SQL statements and HTTP requests are logged at debug level")
+	c.PersistentFlags().String("log-file", "", "The file to write the logs to instead of stderr")
+}
+
+// InitLogger sets up the logger by the flags of logging
+func InitLogger(c *cobra.Command) error {
+	level, err := c.Flags().GetString("log-level")
+	if err != nil {
+		return err
+	}
+	file, err := c.Flags().GetString("log-file")
+	if err != nil {
+		return err
+	}
+	return logger.Init(level, file)
 }
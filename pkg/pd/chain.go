@@ -0,0 +1,213 @@
+package pd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/logger"
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/tidb"
+)
+
+// The kinds of the issues in Region chain
+const (
+	// No Region covers the range
+	ChainIssueHole = "hole"
+	// More than one Regions cover the range
+	ChainIssueOverlap = "overlap"
+	// The Region is changed during scanning, so the scanned Regions are not a
+	// consistent snapshot of the key space
+	ChainIssueEpochChanged = "epoch changed"
+)
+
+// ChainIssue is a hole or an overlap between the Regions, or a Region changed
+// during scanning. The range is in PD key format.
+type ChainIssue struct {
+	Kind     string
+	StartKey string
+	EndKey   string
+	Regions  []Region
+	Detail   string
+}
+
+// windowStart returns the start of range covering the issue for re-scanning
+func (i *ChainIssue) windowStart() string {
+	start := i.StartKey
+	for _, r := range i.Regions {
+		if r.StartKey < start {
+			start = r.StartKey
+		}
+	}
+	return start
+}
+
+// windowEnd returns the end of range covering the issue for re-scanning
+func (i *ChainIssue) windowEnd() string {
+	end := i.EndKey
+	for _, r := range i.Regions {
+		if compareEndKey(r.EndKey, end) > 0 {
+			end = r.EndKey
+		}
+	}
+	return end
+}
+
+// compareEndKey compares two end keys in PD key format, the empty key is the
+// end of the key space. The upper case hex strings are in the same order as
+// the keys.
+func compareEndKey(a, b string) int {
+	if a == b {
+		return 0
+	} else if a == "" {
+		return 1
+	} else if b == "" {
+		return -1
+	}
+	return strings.Compare(a, b)
+}
+
+// CheckRegionChain scans the Regions in the range [startKey, endKey) in PD key
+// format by batches, the empty endKey is the end of the key space. The holes
+// and overlaps found are verified by re-fetching the Regions, if any of the
+// Regions is changed during scanning, the range around it is re-scanned up to
+// maxRetry times. It returns the num of Regions scanned and the issues found.
+func (c *Client) CheckRegionChain(startKey, endKey string, batch int64, maxRetry int) (int, []ChainIssue, error) {
+	numRegions, found, err := c.scanRegionChain(startKey, endKey, batch)
+	if err != nil {
+		return numRegions, nil, err
+	}
+
+	var issues []ChainIssue
+	for _, issue := range found {
+		changed, err := c.getChangedRegions(issue.Regions)
+		if err != nil {
+			return numRegions, issues, err
+		}
+		if len(changed) == 0 && issue.Kind != ChainIssueEpochChanged {
+			// The Regions are not changed, it is a real hole or overlap
+			logger.Infof("Confirmed %s in [%s, %s)", issue.Kind, issue.StartKey, issue.EndKey)
+			issues = append(issues, issue)
+			continue
+		}
+
+		start, end := issue.windowStart(), issue.windowEnd()
+		if maxRetry <= 0 {
+			issues = append(issues, ChainIssue{
+				Kind:     ChainIssueEpochChanged,
+				StartKey: start,
+				EndKey:   end,
+				Regions:  issue.Regions,
+				Detail:   fmt.Sprintf("the Regions keep changing, found %s but can not verify it", issue.Kind),
+			})
+			continue
+		}
+		logger.Infof("Regions %v changed during scanning, re-scanning [%s, %s)", changed, start, end)
+		_, rescanned, err := c.CheckRegionChain(start, end, batch, maxRetry-1)
+		if err != nil {
+			return numRegions, issues, err
+		}
+		issues = append(issues, rescanned...)
+	}
+	return numRegions, issues, nil
+}
+
+// scanRegionChain scans the Regions in the range in order, and finds the holes
+// and overlaps between adjacent Regions, and the Regions scanned twice with
+// different epochs.
+func (c *Client) scanRegionChain(startKey, endKey string, batch int64) (int, []ChainIssue, error) {
+	var (
+		issues     []ChainIssue
+		numRegions int
+		prev       *Region
+	)
+	seen := make(map[int64]Region)
+	key := startKey
+	for {
+		queryKey, err := tidb.FromPDKey(key)
+		if err != nil {
+			return numRegions, issues, err
+		}
+		regions, err := c.GetRegions(queryKey, batch)
+		if err != nil {
+			return numRegions, issues, err
+		}
+		if len(regions) == 0 {
+			// No Region covers the rest of the range
+			issues = append(issues, ChainIssue{Kind: ChainIssueHole, StartKey: key, EndKey: endKey, Detail: "no Region after the key"})
+			return numRegions, issues, nil
+		}
+
+		for i := range regions {
+			region := regions[i]
+			if endKey != "" && region.StartKey >= endKey {
+				return numRegions, issues, nil
+			}
+			old, rescanned := seen[region.Id]
+			if !rescanned {
+				seen[region.Id] = region
+				numRegions++
+			}
+			switch {
+			case rescanned:
+				// Already checked, do not report an overlap with itself. But
+				// go on scanning after it if it is extended by merging.
+				if old.Epoch != region.Epoch {
+					issues = append(issues, ChainIssue{
+						Kind:     ChainIssueEpochChanged,
+						StartKey: region.StartKey,
+						EndKey:   region.EndKey,
+						Regions:  []Region{old, region},
+						Detail:   fmt.Sprintf("Region %d is scanned twice with different epochs", region.Id),
+					})
+				}
+			case prev == nil:
+				if region.StartKey > startKey {
+					issues = append(issues, ChainIssue{Kind: ChainIssueHole, StartKey: startKey, EndKey: region.StartKey, Regions: []Region{region},
+						Detail: fmt.Sprintf("no Region covers the range before Region %d", region.Id)})
+				}
+			case region.StartKey > prev.EndKey:
+				issues = append(issues, ChainIssue{Kind: ChainIssueHole, StartKey: prev.EndKey, EndKey: region.StartKey, Regions: []Region{*prev, region},
+					Detail: fmt.Sprintf("no Region covers the range between Region %d and Region %d", prev.Id, region.Id)})
+			case region.StartKey < prev.EndKey:
+				overlapEnd := region.EndKey
+				if compareEndKey(prev.EndKey, overlapEnd) < 0 {
+					overlapEnd = prev.EndKey
+				}
+				issues = append(issues, ChainIssue{Kind: ChainIssueOverlap, StartKey: region.StartKey, EndKey: overlapEnd, Regions: []Region{*prev, region},
+					Detail: fmt.Sprintf("Region %d and Region %d overlap", prev.Id, region.Id)})
+			}
+
+			if prev == nil || compareEndKey(region.EndKey, prev.EndKey) > 0 {
+				prev = &regions[i]
+			}
+			if prev.EndKey == "" || (endKey != "" && prev.EndKey >= endKey) {
+				// Reach the end of the range
+				return numRegions, issues, nil
+			}
+		}
+		if prev == nil || prev.EndKey == key {
+			return numRegions, issues, fmt.Errorf("can not make progress when scanning Regions from %s", key)
+		}
+		key = prev.EndKey
+	}
+}
+
+// getChangedRegions re-fetches the Regions, and returns the ids of Regions that
+// are removed or with a different epoch
+func (c *Client) getChangedRegions(regions []Region) ([]int64, error) {
+	var changed []int64
+	for _, region := range regions {
+		latest, err := c.GetRegionByID(region.Id)
+		if errors.Is(err, ErrRegionNotFound) {
+			// The Region is merged or removed
+			changed = append(changed, region.Id)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if latest.Epoch != region.Epoch || latest.StartKey != region.StartKey || latest.EndKey != region.EndKey {
+			changed = append(changed, region.Id)
+		}
+	}
+	return changed, nil
+}
@@ -0,0 +1,165 @@
+package pd_test
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/pd"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeRegions is the Regions served by the fake PD. The Regions can be changed
+// after a num of requests of scanning Regions.
+type fakeRegions struct {
+	mu      sync.Mutex
+	regions []pd.Region
+	numScan int
+	// Called after the scan request with the num of scan requests
+	afterScan func(numScan int, regions []pd.Region) []pd.Region
+}
+
+func (f *fakeRegions) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case r.URL.Path == "/pd/api/v1/regions/key":
+		// The key is the raw bytes of the encoded key, return the Regions
+		// ending after it ordered by start key like PD
+		key := strings.ToUpper(hex.EncodeToString([]byte(r.URL.Query().Get("key"))))
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		var res []pd.Region
+		for _, region := range f.regions {
+			if (region.EndKey == "" || region.EndKey > key) && len(res) < limit {
+				res = append(res, region)
+			}
+		}
+		f.numScan++
+		if f.afterScan != nil {
+			f.regions = f.afterScan(f.numScan, f.regions)
+		}
+		body, _ := json.Marshal(map[string]interface{}{"count": len(res), "regions": res})
+		w.Write(body)
+	case strings.HasPrefix(r.URL.Path, "/pd/api/v1/region/id/"):
+		id, _ := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/pd/api/v1/region/id/"), 10, 64)
+		for _, region := range f.regions {
+			if region.Id == id {
+				body, _ := json.Marshal(region)
+				w.Write(body)
+				return
+			}
+		}
+		w.Write([]byte(`null`))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// newFakeRegion returns a Region as decoded from PD, the role name of peers is
+// filled as Voter
+func newFakeRegion(id int64, start, end string, version uint64) pd.Region {
+	return pd.Region{Id: id, StartKey: start, EndKey: end, Epoch: pd.RegionEpoch{ConfVer: 1, Version: version}, Leader: pd.Peer{RoleName: pd.RoleNameVoter}}
+}
+
+func checkFakeRegionChain(t *testing.T, f *fakeRegions, startKey, endKey string, batch int64, maxRetry int) (int, []pd.ChainIssue) {
+	sort.Slice(f.regions, func(i, j int) bool { return f.regions[i].StartKey < f.regions[j].StartKey })
+	server := newTestPDServer(f)
+	defer server.Close()
+	client := newTestPDClient(server)
+	client.SetRetry(0, time.Millisecond)
+	n, issues, err := client.CheckRegionChain(startKey, endKey, batch, maxRetry)
+	assert.Equal(t, err, nil)
+	return n, issues
+}
+
+func TestCheckRegionChain(t *testing.T) {
+	f := &fakeRegions{regions: []pd.Region{
+		newFakeRegion(1, "", "10", 1),
+		newFakeRegion(2, "10", "20", 1),
+		newFakeRegion(3, "20", "30", 1),
+		newFakeRegion(4, "30", "", 1),
+	}}
+	n, issues := checkFakeRegionChain(t, f, "", "", 2, 3)
+	assert.Equal(t, 4, n)
+	assert.Empty(t, issues)
+
+	// Check a range in the middle
+	n, issues = checkFakeRegionChain(t, f, "10", "30", 1, 3)
+	assert.Equal(t, 2, n)
+	assert.Empty(t, issues)
+}
+
+func TestCheckRegionChainWithGap(t *testing.T) {
+	f := &fakeRegions{regions: []pd.Region{
+		newFakeRegion(1, "", "10", 1),
+		newFakeRegion(2, "10", "20", 1),
+		newFakeRegion(4, "30", "40", 1),
+	}}
+	n, issues := checkFakeRegionChain(t, f, "", "", 2, 3)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, 2, len(issues))
+	assert.Equal(t, pd.ChainIssueHole, issues[0].Kind)
+	assert.Equal(t, "20", issues[0].StartKey)
+	assert.Equal(t, "30", issues[0].EndKey)
+	assert.Equal(t, []pd.Region{f.regions[1], f.regions[2]}, issues[0].Regions)
+	// No Region after the last one
+	assert.Equal(t, pd.ChainIssueHole, issues[1].Kind)
+	assert.Equal(t, "40", issues[1].StartKey)
+	assert.Equal(t, "", issues[1].EndKey)
+}
+
+func TestCheckRegionChainWithOverlap(t *testing.T) {
+	f := &fakeRegions{regions: []pd.Region{
+		newFakeRegion(1, "", "10", 1),
+		newFakeRegion(2, "10", "25", 1),
+		newFakeRegion(3, "20", "30", 1),
+		newFakeRegion(4, "30", "", 1),
+	}}
+	n, issues := checkFakeRegionChain(t, f, "", "", 10, 3)
+	assert.Equal(t, 4, n)
+	assert.Equal(t, 1, len(issues))
+	assert.Equal(t, pd.ChainIssueOverlap, issues[0].Kind)
+	assert.Equal(t, "20", issues[0].StartKey)
+	assert.Equal(t, "25", issues[0].EndKey)
+	assert.Equal(t, fmt.Sprintf("Region %d and Region %d overlap", 2, 3), issues[0].Detail)
+}
+
+func TestCheckRegionChainWithEpochChanged(t *testing.T) {
+	// Region 1 is merged with Region 2 after the first batch, so it is scanned
+	// again in the second batch with a different epoch
+	merge := func(numScan int, regions []pd.Region) []pd.Region {
+		if numScan != 1 {
+			return regions
+		}
+		return []pd.Region{newFakeRegion(1, "", "20", 2), regions[2]}
+	}
+	newRegions := func() *fakeRegions {
+		return &fakeRegions{afterScan: merge, regions: []pd.Region{
+			newFakeRegion(1, "", "10", 1),
+			newFakeRegion(2, "10", "20", 1),
+			newFakeRegion(3, "20", "", 1),
+		}}
+	}
+
+	// The range is re-scanned and no issue is found
+	f := newRegions()
+	_, issues := checkFakeRegionChain(t, f, "", "", 1, 1)
+	assert.Empty(t, issues)
+	assert.Equal(t, 4, f.numScan)
+
+	// Report the epoch change without retry
+	f = newRegions()
+	_, issues = checkFakeRegionChain(t, f, "", "", 1, 0)
+	assert.Equal(t, 1, len(issues))
+	assert.Equal(t, pd.ChainIssueEpochChanged, issues[0].Kind)
+	assert.Equal(t, []int64{1, 1}, []int64{issues[0].Regions[0].Id, issues[0].Regions[1].Id})
+	assert.Equal(t, uint64(1), issues[0].Regions[0].Epoch.Version)
+	assert.Equal(t, uint64(2), issues[0].Regions[1].Epoch.Version)
+}
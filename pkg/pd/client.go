@@ -3,33 +3,72 @@ package pd
 import (
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/JaySon-Huang/tiflash-ctl/pkg/tidb"
 )
 
 type Client struct {
-	baseURL string
+	members    *memberList
+	httpClient *http.Client
+	// "https" for the TLS-enabled cluster
+	scheme   string
+	maxRetry int
+	backoff  time.Duration
 }
 
-func NewPDClient(url string) Client {
+// NewPDClient returns the client of the PD cluster with the members. The
+// requests are sent to the PD leader, and fail over to the other members if
+// the leader is unreachable.
+func NewPDClient(endpoints ...string) Client {
 	return Client{
-		baseURL: url,
+		members:    newMemberList(endpoints),
+		httpClient: &http.Client{Timeout: defaultRequestTimeout},
+		scheme:     "http",
+		maxRetry:   defaultMaxRetry,
+		backoff:    defaultRetryBackoff,
 	}
 }
 
+// NewPDClientFromTiDB returns the client of the PD members in opts. If they
+// are not set, the members are found from `information_schema.cluster_info`.
+func NewPDClientFromTiDB(client *tidb.Client, opts tidb.TiDBClientOpts) (Client, error) {
+	endpoints := opts.PDAddrs
+	if len(endpoints) == 0 {
+		pdInstances, err := client.GetInstances("pd")
+		if err != nil {
+			return Client{}, err
+		}
+		if len(pdInstances) == 0 {
+			return Client{}, fmt.Errorf("can not find any PD instance from cluster_info")
+		}
+		endpoints = pdInstances
+	}
+	c := NewPDClient(endpoints...)
+	if err := c.SetTLS(opts.TLS); err != nil {
+		return Client{}, err
+	}
+	return c, nil
+}
+
 type Peer struct {
-	Id       int64  `json:"id"`
-	StoreId  int64  `json:"store_id"`
-	RoleName string `json:"role_name"`
+	Id        int64  `json:"id"`
+	StoreId   int64  `json:"store_id"`
+	RoleName  string `json:"role_name"`
+	IsWitness bool   `json:"is_witness,omitempty"`
 }
 
 const RoleNameLearner = "Learner"
 const RoleNameVoter = "Voter"
 
+// The roles of peer during joint consensus
+const RoleNameIncomingVoter = "IncomingVoter"
+const RoleNameDemotingVoter = "DemotingVoter"
+
 func (p *Peer) UnmarshalJSON(data []byte) error {
 	type APeer Peer
 	peer := &APeer{}
@@ -60,6 +99,7 @@ func (p *Peer) UnmarshalJSON(data []byte) error {
 	}
 	p.Id = peer.Id
 	p.StoreId = peer.StoreId
+	p.IsWitness = peer.IsWitness
 	return nil
 }
 
@@ -67,11 +107,50 @@ func (p *Peer) IsLearner() bool {
 	return p.RoleName == RoleNameLearner
 }
 
+func (p Peer) String() string {
+	if p.IsWitness {
+		return fmt.Sprintf("{%d %d %s Witness}", p.Id, p.StoreId, p.RoleName)
+	}
+	return fmt.Sprintf("{%d %d %s}", p.Id, p.StoreId, p.RoleName)
+}
+
+// DownPeer is a peer that has not sent heartbeat to the leader for a while
+type DownPeer struct {
+	Peer        Peer  `json:"peer"`
+	DownSeconds int64 `json:"down_seconds"`
+}
+
+// RegionEpoch is changed when the Region is split or merged (version), or
+// the peers are changed (conf_ver)
+type RegionEpoch struct {
+	ConfVer uint64 `json:"conf_ver"`
+	Version uint64 `json:"version"`
+}
+
 type Region struct {
-	Id       int64  `json:"id"`
-	StartKey string `json:"start_key"`
-	EndKey   string `json:"end_key"`
-	Peers    []Peer `json:"peers"`
+	Id       int64       `json:"id"`
+	StartKey string      `json:"start_key"`
+	EndKey   string      `json:"end_key"`
+	Peers    []Peer      `json:"peers"`
+	Epoch    RegionEpoch `json:"epoch"`
+	Leader   Peer        `json:"leader"`
+
+	// The peers that are not up to date with the leader
+	PendingPeers []Peer     `json:"pending_peers,omitempty"`
+	DownPeers    []DownPeer `json:"down_peers,omitempty"`
+
+	WrittenBytes uint64 `json:"written_bytes"`
+	ReadBytes    uint64 `json:"read_bytes"`
+	WrittenKeys  uint64 `json:"written_keys"`
+	ReadKeys     uint64 `json:"read_keys"`
+	// The approximate size of Region in MiB
+	ApproximateSize int64 `json:"approximate_size"`
+	ApproximateKeys int64 `json:"approximate_keys"`
+}
+
+// String returns the id, keys and peers of the Region
+func (r Region) String() string {
+	return fmt.Sprintf("{%d %s %s %v}", r.Id, r.StartKey, r.EndKey, r.Peers)
 }
 
 func (r *Region) GetLearnerStoreIDs() []int64 {
@@ -84,66 +163,80 @@ func (r *Region) GetLearnerStoreIDs() []int64 {
 	return res
 }
 
-func (c *Client) getAPIWithParam(route string, params url.Values) string {
-	u, err := url.Parse(fmt.Sprintf("http://%s/pd/api/v1/%s", c.baseURL, route))
-	if err != nil {
-		return ""
+func (r *Region) IsPeerPending(peerID int64) bool {
+	for _, p := range r.PendingPeers {
+		if p.Id == peerID {
+			return true
+		}
 	}
-	u.RawQuery = params.Encode()
-	return u.String()
+	return false
 }
 
-func (c *Client) getAPI(route string) string {
-	return fmt.Sprintf("http://%s/pd/api/v1/%s", c.baseURL, route)
+func (r *Region) IsPeerDown(peerID int64) bool {
+	for _, p := range r.DownPeers {
+		if p.Peer.Id == peerID {
+			return true
+		}
+	}
+	return false
 }
 
+// GetUnhealthyLearners returns the learner peers that are pending or down,
+// the data on them can not be read until they catch up with the leader
+func (r *Region) GetUnhealthyLearners() []Peer {
+	var res []Peer
+	for _, p := range r.Peers {
+		if p.IsLearner() && (r.IsPeerPending(p.Id) || r.IsPeerDown(p.Id)) {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+// GetRegionByKey returns the Region containing the key, ErrRegionNotFound is
+// returned if no Region contains the key
 func (c *Client) GetRegionByKey(key tidb.TiKVKey) (Region, error) {
+	return c.getRegion(fmt.Sprintf("region/key/%s", url.QueryEscape(string(key.GetBytes()))))
+}
+
+// GetRegionByID returns the Region with the id, ErrRegionNotFound is returned
+// if it does not exist
+func (c *Client) GetRegionByID(regionID int64) (Region, error) {
+	return c.getRegion(fmt.Sprintf("region/id/%d", regionID))
+}
+
+func (c *Client) getRegion(route string) (Region, error) {
 	var region Region
-	resp, err := http.Get(c.getAPI(fmt.Sprintf("region/key/%s", url.QueryEscape(string(key.GetBytes())))))
+	body, u, err := c.do(http.MethodGet, route, nil, nil)
 	if err != nil {
 		return region, err
 	}
-	defer resp.Body.Close()
-	bytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return region, err
+	if err = json.Unmarshal(body, &region); err != nil {
+		return region, &Error{Kind: ErrInvalidResponse, URL: u, StatusCode: http.StatusOK, Message: fmt.Sprintf("%s, response: %s", err, body)}
 	}
-	// fmt.Printf("%s\n", string(bytes))
-	err = json.Unmarshal(bytes, &region)
-	if err != nil {
-		return region, err
+	// PD responses "null" if the Region does not exist
+	if region.Id == 0 {
+		return region, &Error{Kind: ErrRegionNotFound, URL: u, StatusCode: http.StatusOK, Message: string(body)}
 	}
-	return region, err
+	return region, nil
+}
+
+type regionStatsResp struct {
+	Count *int64 `json:"count"`
 }
 
 func (c *Client) GetNumRegionBetweenKey(startKey, endKey tidb.TiKVKey) (int64, error) {
 	params := url.Values{}
 	params.Set("start_key", string(startKey.GetBytes()))
 	params.Set("end_key", string(endKey.GetBytes()))
-	resp, err := http.Get(c.getAPIWithParam("stats/region", params))
-	if err != nil {
+	var result regionStatsResp
+	if err := c.getJSON("stats/region", params, &result); err != nil {
 		return 0, err
 	}
-	defer resp.Body.Close()
-	bytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return 0, err
+	if result.Count == nil {
+		return 0, fmt.Errorf("'count' is not exits in the response of stats/region: %w", ErrInvalidResponse)
 	}
-	// fmt.Printf("%s\n", string(bytes))
-	var result map[string]interface{}
-	err = json.Unmarshal(bytes, &result)
-	if err != nil {
-		return 0, err
-	}
-	count, ok := result["count"]
-	if !ok {
-		return 0, fmt.Errorf("'count' is not exits in the response: %s", bytes)
-	}
-	cnt, ok := count.(float64)
-	if !ok {
-		return 0, fmt.Errorf("can not parse 'count' as float64, response: %s", bytes)
-	}
-	return int64(cnt), nil
+	return *result.Count, nil
 }
 
 type regionsByKeyResp struct {
@@ -155,20 +248,124 @@ func (c *Client) GetRegions(startKey tidb.TiKVKey, limit int64) ([]Region, error
 	params := url.Values{}
 	params.Set("key", string(startKey.GetBytes()))
 	params.Set("limit", strconv.FormatInt(limit, 10))
-	resp, err := http.Get(c.getAPIWithParam("regions/key", params))
-	if err != nil {
+	var result regionsByKeyResp
+	if err := c.getJSON("regions/key", params, &result); err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-	bytes, err := io.ReadAll(resp.Body)
+	return result.Regions, nil
+}
+
+type operatorInput struct {
+	Name           string `json:"name"`
+	RegionID       int64  `json:"region_id,omitempty"`
+	StoreID        int64  `json:"store_id,omitempty"`
+	Policy         string `json:"policy,omitempty"`
+	SourceRegionID int64  `json:"source_region_id,omitempty"`
+	TargetRegionID int64  `json:"target_region_id,omitempty"`
+}
+
+// AddRemovePeerOperator creates an operator to remove the peer of the Region
+// on the store, the same as `operator add remove-peer` in pd-ctl
+func (c *Client) AddRemovePeerOperator(regionID, storeID int64) error {
+	return c.addOperator(operatorInput{Name: "remove-peer", RegionID: regionID, StoreID: storeID})
+}
+
+// The policies of splitting Region
+const (
+	// Split the Region in the middle of the keys scanned from the Region
+	SplitPolicyScan = "scan"
+	// Split the Region in the middle by the approximate size
+	SplitPolicyApproximate = "approximate"
+)
+
+// AddSplitRegionOperator creates an operator to split the Region, the same as
+// `operator add split-region <region_id> --policy=<policy>` in pd-ctl
+func (c *Client) AddSplitRegionOperator(regionID int64, policy string) error {
+	return c.addOperator(operatorInput{Name: "split-region", RegionID: regionID, Policy: policy})
+}
+
+// AddMergeRegionOperator creates an operator to merge the source Region into
+// the adjacent target Region, the same as `operator add merge-region` in pd-ctl
+func (c *Client) AddMergeRegionOperator(sourceRegionID, targetRegionID int64) error {
+	return c.addOperator(operatorInput{Name: "merge-region", SourceRegionID: sourceRegionID, TargetRegionID: targetRegionID})
+}
+
+func (c *Client) addOperator(op operatorInput) error {
+	input, err := json.Marshal(op)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	// fmt.Printf("%s\n", string(bytes))
-	var result regionsByKeyResp
-	err = json.Unmarshal(bytes, &result)
+	if _, err = c.post("operators", input); err != nil {
+		return fmt.Errorf("add operator %s fail: %w", input, err)
+	}
+	return nil
+}
+
+// The status of operator
+const (
+	OperatorStatusSuccess = "SUCCESS"
+	OperatorStatusTimeout = "TIMEOUT"
+	OperatorStatusCancel  = "CANCEL"
+	OperatorStatusReplace = "REPLACE"
+	OperatorStatusRunning = "RUNNING"
+)
+
+// The status in the order of pdpb.OperatorStatus, for the response with
+// the status in number
+var operatorStatusNames = []string{
+	OperatorStatusSuccess, OperatorStatusTimeout, OperatorStatusCancel, OperatorStatusReplace, OperatorStatusRunning,
+}
+
+// IsOperatorFinished returns whether the operator with the status is finished,
+// no matter it is success or not
+func IsOperatorFinished(status string) bool {
+	switch status {
+	// CREATED and STARTED are the status of running operator in the newer versions
+	case OperatorStatusRunning, "CREATED", "STARTED":
+		return false
+	}
+	return true
+}
+
+// GetOperatorStatus returns the status of the latest operator of the Region
+func (c *Client) GetOperatorStatus(regionID int64) (string, error) {
+	body, u, err := c.do(http.MethodGet, fmt.Sprintf("operators/%d", regionID), nil, nil)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	return result.Regions, nil
+	status, err := parseOperatorStatus(body)
+	if err != nil {
+		return "", &Error{Kind: ErrInvalidResponse, URL: u, StatusCode: http.StatusOK, Message: fmt.Sprintf("%s, response: %s", err, body)}
+	}
+	return status, nil
+}
+
+func parseOperatorStatus(body []byte) (string, error) {
+	var result interface{}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", err
+	}
+	switch v := result.(type) {
+	case string:
+		// The operator with status is returned as a string like
+		// "status: SUCCESS, operator: ..."
+		const prefix = "status: "
+		if strings.HasPrefix(v, prefix) {
+			status := strings.TrimPrefix(v, prefix)
+			if i := strings.IndexByte(status, ','); i >= 0 {
+				status = status[:i]
+			}
+			return strings.TrimSpace(status), nil
+		}
+	case map[string]interface{}:
+		switch status := v["status"].(type) {
+		case string:
+			return status, nil
+		case float64:
+			if i := int(status); i >= 0 && i < len(operatorStatusNames) {
+				return operatorStatusNames[i], nil
+			}
+		}
+	}
+	return "", fmt.Errorf("can not parse the status of operator, response: %s", body)
 }
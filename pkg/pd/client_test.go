@@ -2,6 +2,12 @@ package pd_test
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/JaySon-Huang/tiflash-ctl/pkg/pd"
@@ -48,6 +54,7 @@ func TestParseRegionFromV4(t *testing.T) {
 	assert.Equal(t, int64(58), region.Id)
 	assert.Equal(t, "7480000000000000FF345F720000000000FA", region.StartKey)
 	assert.Equal(t, "7480000000000000FF3500000000000000F8", region.EndKey)
+	assert.Equal(t, pd.RegionEpoch{ConfVer: 2, Version: 28}, region.Epoch)
 	assert.Equal(t, 2, len(region.Peers))
 	p59 := region.Peers[0]
 	assert.Equal(t, int64(59), p59.Id)
@@ -115,4 +122,174 @@ func TestParseRegionFromV5(t *testing.T) {
 	assert.Equal(t, pd.RoleNameLearner, p1.RoleName)
 
 	assert.Equal(t, []int64{68}, region.GetLearnerStoreIDs())
+
+	assert.Equal(t, int64(4825), region.Leader.Id)
+	assert.Equal(t, int64(105), region.ApproximateSize)
+	assert.Equal(t, int64(503161), region.ApproximateKeys)
+	assert.Equal(t, 0, len(region.GetUnhealthyLearners()))
+	assert.Equal(t, "{4824 7480000000000000FF4C5F728000000094FFFFC3460000000000FA 7480000000000000FF4C5F728000000095FF06C0E00000000000FA [{4825 1 Voter} {4826 68 Learner}]}", region.String())
+}
+
+func TestParseRegionWithUnhealthyPeers(t *testing.T) {
+	jsonRsp := []byte(`
+{"id": 100,
+  "start_key": "7480000000000000FF4C5F728000000094FFFFC3460000000000FA",
+  "end_key": "",
+  "epoch": {"conf_ver": 5, "version": 10},
+  "peers": [
+    {"id": 101, "store_id": 1, "role_name": "Voter"},
+    {"id": 102, "store_id": 2, "role_name": "IncomingVoter"},
+    {"id": 103, "store_id": 3, "role_name": "DemotingVoter", "is_witness": true},
+    {"id": 104, "store_id": 68, "role_name": "Learner", "is_learner": true},
+    {"id": 105, "store_id": 69, "role_name": "Learner", "is_learner": true},
+    {"id": 106, "store_id": 70, "role_name": "Learner", "is_learner": true}
+  ],
+  "leader": {"id": 101, "store_id": 1, "role_name": "Voter"},
+  "down_peers": [
+    {"peer": {"id": 104, "store_id": 68, "role_name": "Learner", "is_learner": true}, "down_seconds": 3600}
+  ],
+  "pending_peers": [
+    {"id": 105, "store_id": 69, "role_name": "Learner", "is_learner": true}
+  ],
+  "written_bytes": 1024,
+  "read_bytes": 2048,
+  "written_keys": 10,
+  "read_keys": 20,
+  "approximate_size": 96,
+  "approximate_keys": 100000}`)
+
+	region := pd.Region{}
+	err := json.Unmarshal(jsonRsp, &region)
+	assert.Equal(t, err, nil)
+
+	assert.Equal(t, pd.RegionEpoch{ConfVer: 5, Version: 10}, region.Epoch)
+	assert.Equal(t, pd.RoleNameIncomingVoter, region.Peers[1].RoleName)
+	assert.Equal(t, pd.RoleNameDemotingVoter, region.Peers[2].RoleName)
+	assert.True(t, region.Peers[2].IsWitness)
+	assert.False(t, region.Peers[1].IsWitness)
+	assert.Equal(t, int64(1), region.Leader.StoreId)
+	assert.Equal(t, int64(3600), region.DownPeers[0].DownSeconds)
+	assert.Equal(t, uint64(1024), region.WrittenBytes)
+	assert.Equal(t, uint64(2048), region.ReadBytes)
+	assert.Equal(t, uint64(10), region.WrittenKeys)
+	assert.Equal(t, uint64(20), region.ReadKeys)
+	assert.Equal(t, int64(96), region.ApproximateSize)
+	assert.Equal(t, int64(100000), region.ApproximateKeys)
+
+	assert.Equal(t, []int64{68, 69, 70}, region.GetLearnerStoreIDs())
+	assert.True(t, region.IsPeerDown(104))
+	assert.True(t, region.IsPeerPending(105))
+	assert.False(t, region.IsPeerPending(106))
+	unhealthy := region.GetUnhealthyLearners()
+	assert.Equal(t, 2, len(unhealthy))
+	assert.Equal(t, int64(104), unhealthy[0].Id)
+	assert.Equal(t, int64(105), unhealthy[1].Id)
+}
+
+// newTestPDServer returns a fake PD that is the leader itself, the requests
+// except getting the leader are handled by the handler
+func newTestPDServer(handler http.Handler) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/pd/api/v1/leader" {
+			fmt.Fprintf(w, `{"name": "pd-0", "client_urls": ["%s"]}`, server.URL)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	return server
+}
+
+func newTestPDClient(server *httptest.Server) pd.Client {
+	return pd.NewPDClient(strings.TrimPrefix(server.URL, "http://"))
+}
+
+func TestAddOperator(t *testing.T) {
+	var body []byte
+	server := newTestPDServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/pd/api/v1/operators", r.URL.Path)
+		body, _ = io.ReadAll(r.Body)
+		if strings.Contains(string(body), `"region_id":404`) {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`"region 404 not found"`))
+			return
+		}
+		w.Write([]byte(`"The operator is created."`))
+	}))
+	defer server.Close()
+
+	client := newTestPDClient(server)
+	err := client.AddRemovePeerOperator(581, 62)
+	assert.Equal(t, err, nil)
+	assert.JSONEq(t, `{"name": "remove-peer", "region_id": 581, "store_id": 62}`, string(body))
+
+	err = client.AddRemovePeerOperator(404, 62)
+	assert.True(t, errors.Is(err, pd.ErrRegionNotFound))
+
+	err = client.AddSplitRegionOperator(581, pd.SplitPolicyScan)
+	assert.Equal(t, err, nil)
+	assert.JSONEq(t, `{"name": "split-region", "region_id": 581, "policy": "scan"}`, string(body))
+
+	err = client.AddMergeRegionOperator(581, 699)
+	assert.Equal(t, err, nil)
+	assert.JSONEq(t, `{"name": "merge-region", "source_region_id": 581, "target_region_id": 699}`, string(body))
+}
+
+func TestGetOperatorStatus(t *testing.T) {
+	responses := map[string]string{
+		"/pd/api/v1/operators/1": `"status: SUCCESS, operator: \"admin-remove-peer {rm peer: store [62]} (kind:admin,region, region:1(1,1), createAt:2021-09-01 12:00:00 +0800 CST m=+1.0, startAt:2021-09-01 12:00:00 +0800 CST m=+1.0, currentStep:1, steps:[remove peer on store 62]) finished\""`,
+		"/pd/api/v1/operators/2": `"status: RUNNING, operator: \"admin-remove-peer\""`,
+		"/pd/api/v1/operators/3": `{"status": "TIMEOUT"}`,
+		"/pd/api/v1/operators/4": `{"status": 2}`,
+	}
+	server := newTestPDServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp, ok := responses[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`"operator not found"`))
+			return
+		}
+		w.Write([]byte(resp))
+	}))
+	defer server.Close()
+
+	client := newTestPDClient(server)
+	for regionID, expected := range map[int64]string{
+		1: pd.OperatorStatusSuccess,
+		2: pd.OperatorStatusRunning,
+		3: pd.OperatorStatusTimeout,
+		4: pd.OperatorStatusCancel,
+	} {
+		status, err := client.GetOperatorStatus(regionID)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, expected, status)
+	}
+	assert.False(t, pd.IsOperatorFinished(pd.OperatorStatusRunning))
+	assert.True(t, pd.IsOperatorFinished(pd.OperatorStatusSuccess))
+
+	_, err := client.GetOperatorStatus(5)
+	assert.NotEqual(t, err, nil)
+}
+
+func TestGetRegionByID(t *testing.T) {
+	server := newTestPDServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/pd/api/v1/region/id/58" {
+			w.Write([]byte(`{"id": 58, "start_key": "7480000000000000FF345F720000000000FA", "end_key": "", "epoch": {"conf_ver": 2, "version": 28}}`))
+			return
+		}
+		w.Write([]byte(`null`))
+	}))
+	defer server.Close()
+
+	client := newTestPDClient(server)
+	region, err := client.GetRegionByID(58)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, int64(58), region.Id)
+	assert.Equal(t, "", region.EndKey)
+	assert.Equal(t, pd.RegionEpoch{ConfVer: 2, Version: 28}, region.Epoch)
+
+	_, err = client.GetRegionByID(59)
+	assert.True(t, errors.Is(err, pd.ErrRegionNotFound))
+	assert.Contains(t, err.Error(), "/pd/api/v1/region/id/59")
 }
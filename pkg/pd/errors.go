@@ -0,0 +1,131 @@
+package pd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// The kinds of errors returned by PD, check them by `errors.Is`
+var (
+	ErrRegionNotFound   = errors.New("region not found")
+	ErrOperatorNotFound = errors.New("operator not found")
+	ErrStoreNotFound    = errors.New("store not found")
+	ErrNotLeader        = errors.New("not leader")
+	ErrUnavailable      = errors.New("service unavailable")
+	ErrInvalidResponse  = errors.New("invalid response")
+	ErrRequestFailed    = errors.New("request failed")
+)
+
+// Error is the error of a request to PD
+type Error struct {
+	// The kind of the error
+	Kind       error
+	URL        string
+	StatusCode int
+	// The error message in the response
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.StatusCode == 0 {
+		return fmt.Sprintf("%s: %s, url: %s", e.Kind, e.Message, e.URL)
+	}
+	return fmt.Sprintf("%s: %s, status: %d, url: %s", e.Kind, e.Message, e.StatusCode, e.URL)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Kind
+}
+
+// newResponseError returns the error of the response of the route with the
+// status code not success. PD responses the error message as a JSON string, or
+// an object with the message in newer versions.
+func newResponseError(route, url string, statusCode int, body []byte) *Error {
+	msg := strings.TrimSpace(string(body))
+	var str string
+	var obj struct {
+		Message string `json:"message"`
+		Error   string `json:"error"`
+	}
+	if err := json.Unmarshal(body, &str); err == nil {
+		msg = str
+	} else if err = json.Unmarshal(body, &obj); err == nil {
+		if obj.Message != "" {
+			msg = obj.Message
+		} else if obj.Error != "" {
+			msg = obj.Error
+		}
+	}
+	return &Error{Kind: classifyError(route, statusCode, msg), URL: url, StatusCode: statusCode, Message: msg}
+}
+
+// The messages returned by PD when the member is not the leader or the leader
+// is not elected yet
+var notLeaderMessages = []string{"not leader", "no leader", "leader is nil", "redirect failed"}
+
+// The messages returned by PD when the resource is not found, like "region 5
+// not found" or "store id 1 not found"
+var (
+	regionNotFoundRe   = regexp.MustCompile(`^(\[.*\] ?)?region (id )?(\d+ )?not found`)
+	operatorNotFoundRe = regexp.MustCompile(`^(\[.*\] ?)?operator not found`)
+	storeNotFoundRe    = regexp.MustCompile(`^(\[.*\] ?)?store (id )?(\d+ )?not found`)
+)
+
+// classifyError returns the kind of the error by the route requested and the
+// status code. The message is only matched with the known ones returned by PD.
+func classifyError(route string, statusCode int, msg string) error {
+	lower := strings.ToLower(msg)
+	for _, m := range notLeaderMessages {
+		if strings.Contains(lower, m) {
+			return ErrNotLeader
+		}
+	}
+	// The resource requested, like "region" in "region/id/1"
+	resource := strings.SplitN(route, "/", 2)[0]
+	switch resource {
+	case "region", "regions":
+		if statusCode == http.StatusNotFound || regionNotFoundRe.MatchString(lower) {
+			return ErrRegionNotFound
+		}
+	case "store", "stores":
+		if statusCode == http.StatusNotFound || storeNotFoundRe.MatchString(lower) {
+			return ErrStoreNotFound
+		}
+	case "operators":
+		// The Region or store of the operator to add could be not found
+		switch {
+		case operatorNotFoundRe.MatchString(lower):
+			return ErrOperatorNotFound
+		case regionNotFoundRe.MatchString(lower):
+			return ErrRegionNotFound
+		case storeNotFoundRe.MatchString(lower):
+			return ErrStoreNotFound
+		case statusCode == http.StatusNotFound && route != resource:
+			return ErrOperatorNotFound
+		}
+	}
+	if isTransientStatus(statusCode) {
+		return ErrUnavailable
+	}
+	return ErrRequestFailed
+}
+
+// isRetryable returns whether the request could success by retrying, for
+// example, PD is electing a new leader
+func isRetryable(err *Error) bool {
+	return err.Kind == ErrNotLeader || err.Kind == ErrUnavailable
+}
+
+// isTransientStatus returns whether the status code means PD is temporarily
+// unable to handle the request
+func isTransientStatus(code int) bool {
+	switch code {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package pd_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/pd"
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/tidb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponseError(t *testing.T) {
+	server := newTestPDServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/pd/api/v1/operators/1":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`"operator not found"`))
+		case "/pd/api/v1/operators/2":
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"code": "PD:apiutil:ErrRedirectFailed", "message": "redirect failed, not leader"}`))
+		case "/pd/api/v1/regions/key":
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`internal error`))
+		case "/pd/api/v1/stats/region":
+			w.Write([]byte(`<html>not json</html>`))
+		case "/pd/api/v1/stores":
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`"no leader"`))
+		case "/pd/api/v1/operators/5":
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`"operator not found for region 5"`))
+		case "/pd/api/v1/operators/6":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`"not found"`))
+		case "/pd/api/v1/region/id/7":
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`"redirect"`))
+		case "/pd/api/v1/region/id/8":
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"code": "PD:core:ErrRegionNotFound", "message": "[PD:core:ErrRegionNotFound]region 8 not found"}`))
+		case "/pd/api/v1/operators":
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`"region 10 not found"`))
+		default:
+			w.Write([]byte(`null`))
+		}
+	}))
+	defer server.Close()
+
+	client := newTestPDClient(server)
+	client.SetRetry(1, time.Millisecond)
+
+	_, err := client.GetOperatorStatus(1)
+	assert.True(t, errors.Is(err, pd.ErrOperatorNotFound))
+	var pdErr *pd.Error
+	assert.True(t, errors.As(err, &pdErr))
+	assert.Equal(t, http.StatusNotFound, pdErr.StatusCode)
+	assert.Equal(t, "operator not found", pdErr.Message)
+	assert.Contains(t, pdErr.URL, "/pd/api/v1/operators/1")
+
+	// Retry on not leader error, then fail
+	_, err = client.GetOperatorStatus(2)
+	assert.True(t, errors.Is(err, pd.ErrNotLeader))
+
+	_, err = client.GetRegions(tidb.NewTableStartAsKey(1), 10)
+	assert.True(t, errors.Is(err, pd.ErrRequestFailed))
+	assert.Contains(t, err.Error(), "internal error")
+
+	_, err = client.GetNumRegionBetweenKey(tidb.NewTableStartAsKey(1), tidb.NewTableEndAsKey(1))
+	assert.True(t, errors.Is(err, pd.ErrInvalidResponse))
+
+	_, err = client.GetStores()
+	assert.True(t, errors.Is(err, pd.ErrNotLeader))
+
+	_, err = client.GetRegionByKey(tidb.NewTableStartAsKey(1))
+	assert.True(t, errors.Is(err, pd.ErrRegionNotFound))
+
+	// The message mentioning a Region is not a Region not found error
+	_, err = client.GetOperatorStatus(5)
+	assert.True(t, errors.Is(err, pd.ErrOperatorNotFound))
+	assert.False(t, errors.Is(err, pd.ErrRegionNotFound))
+	_, err = client.GetOperatorStatus(6)
+	assert.True(t, errors.Is(err, pd.ErrOperatorNotFound))
+
+	// Only the known messages of PD mean not leader
+	_, err = client.GetRegionByID(7)
+	assert.True(t, errors.Is(err, pd.ErrRequestFailed))
+	assert.False(t, errors.Is(err, pd.ErrNotLeader))
+
+	_, err = client.GetRegionByID(8)
+	assert.True(t, errors.Is(err, pd.ErrRegionNotFound))
+	err = client.AddRemovePeerOperator(10, 1)
+	assert.True(t, errors.Is(err, pd.ErrRegionNotFound))
+}
@@ -0,0 +1,276 @@
+package pd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/logger"
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/tidb"
+)
+
+const (
+	defaultRequestTimeout = 30 * time.Second
+	defaultMaxRetry       = 3
+	defaultRetryBackoff   = 500 * time.Millisecond
+)
+
+// memberList is the PD members to send requests to, the current one is tried
+// first. It is shared by the copies of Client.
+type memberList struct {
+	mu        sync.Mutex
+	endpoints []string
+	cur       int
+	// Whether the current member is the leader found by the leader API
+	leaderFound bool
+}
+
+func newMemberList(endpoints []string) *memberList {
+	return &memberList{endpoints: endpoints}
+}
+
+// list returns the endpoints starting from the current one
+func (m *memberList) list() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res := make([]string, 0, len(m.endpoints))
+	res = append(res, m.endpoints[m.cur:]...)
+	res = append(res, m.endpoints[:m.cur]...)
+	return res
+}
+
+func (m *memberList) setCurrent(endpoint string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, ep := range m.endpoints {
+		if ep == endpoint {
+			m.cur = i
+			return
+		}
+	}
+}
+
+// setLeader makes the leader the current member, it is added to the members if
+// it is not in the list
+func (m *memberList) setLeader(leader string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.leaderFound = true
+	for i, ep := range m.endpoints {
+		if ep == leader {
+			m.cur = i
+			return
+		}
+	}
+	m.endpoints = append(m.endpoints, leader)
+	m.cur = len(m.endpoints) - 1
+}
+
+func (m *memberList) isLeaderFound() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.leaderFound
+}
+
+// resetLeader makes the leader be found again before the next request
+func (m *memberList) resetLeader() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.leaderFound = false
+}
+
+// SetRetry sets the max times of retrying a request after all the members fail,
+// and the backoff before the first retry, which is doubled for each retry
+func (c *Client) SetRetry(maxRetry int, backoff time.Duration) {
+	c.maxRetry, c.backoff = maxRetry, backoff
+}
+
+// SetTimeout sets the timeout of each request to a PD member
+func (c *Client) SetTimeout(timeout time.Duration) {
+	c.httpClient = &http.Client{Timeout: timeout, Transport: c.httpClient.Transport}
+}
+
+// SetTLS makes the requests use https with the certificates if TLS is enabled
+func (c *Client) SetTLS(opts tidb.TLSOpts) error {
+	httpClient, scheme, err := opts.NewHTTPClient()
+	if err != nil {
+		return err
+	}
+	httpClient.Timeout = c.httpClient.Timeout
+	c.httpClient, c.scheme = httpClient, scheme
+	return nil
+}
+
+// Endpoints returns the PD members, the current one is the first
+func (c *Client) Endpoints() []string {
+	return c.members.list()
+}
+
+type leaderResp struct {
+	Name       string   `json:"name"`
+	ClientURLs []string `json:"client_urls"`
+}
+
+// discoverLeader asks the members for the PD leader and makes it the current
+// member, so that the requests are not proxied by the followers
+func (c *Client) discoverLeader() error {
+	var lastErr error
+	for _, ep := range c.members.list() {
+		leader, err := c.getLeader(ep)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		c.members.setLeader(leader)
+		return nil
+	}
+	return lastErr
+}
+
+func (c *Client) getLeader(endpoint string) (string, error) {
+	resp, err := c.send(http.MethodGet, fmt.Sprintf("%s://%s/pd/api/v1/leader", c.scheme, endpoint), nil)
+	if err != nil {
+		return "", err
+	}
+	body := resp.body
+	if resp.statusCode != http.StatusOK {
+		return "", fmt.Errorf("get leader from %s fail, status: %d, response: %s", endpoint, resp.statusCode, body)
+	}
+	var leader leaderResp
+	if err = json.Unmarshal(body, &leader); err != nil {
+		return "", fmt.Errorf("can not parse leader from %s, err: %s, response: %s", endpoint, err, body)
+	}
+	if len(leader.ClientURLs) == 0 {
+		return "", fmt.Errorf("no client url of leader %s from %s", leader.Name, endpoint)
+	}
+	u, err := url.Parse(leader.ClientURLs[0])
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("can not parse client url %s of leader %s from %s", leader.ClientURLs[0], leader.Name, endpoint)
+	}
+	return u.Host, nil
+}
+
+func (c *Client) post(route string, body []byte) ([]byte, error) {
+	resp, _, err := c.do(http.MethodPost, route, nil, body)
+	return resp, err
+}
+
+// getJSON gets the response of the route and parses it into v
+func (c *Client) getJSON(route string, params url.Values, v interface{}) error {
+	body, u, err := c.do(http.MethodGet, route, params, nil)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(body, v); err != nil {
+		return &Error{Kind: ErrInvalidResponse, URL: u, StatusCode: http.StatusOK, Message: fmt.Sprintf("%s, response: %s", err, body)}
+	}
+	return nil
+}
+
+// isDialError returns whether the request fails on connecting to PD, so that
+// it is not sent
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// do sends the request to the current PD member and returns the response body
+// and the request url. It fails over to the other members on connection
+// errors, and retries with backoff after all the members fail or PD responses
+// a retryable error. The POST request could be handled by PD even if it fails,
+// so it is only sent again on the errors of connecting to PD. The response
+// with status code not success is returned as *Error.
+func (c *Client) do(method, route string, params url.Values, body []byte) ([]byte, string, error) {
+	resend := func(err error) bool {
+		return method != http.MethodPost || isDialError(err)
+	}
+	var lastErr error
+	for attempt := 0; attempt <= c.maxRetry; attempt++ {
+		if attempt > 0 {
+			time.Sleep(c.backoff << (attempt - 1))
+		}
+		if !c.members.isLeaderFound() {
+			// Go on with the members in order if the leader is not found
+			if err := c.discoverLeader(); err != nil {
+				lastErr = err
+			}
+		}
+		for _, ep := range c.members.list() {
+			u := fmt.Sprintf("%s://%s/pd/api/v1/%s", c.scheme, ep, route)
+			if len(params) > 0 {
+				u += "?" + params.Encode()
+			}
+			resp, err := c.send(method, u, body)
+			if err != nil {
+				if !resend(err) {
+					return nil, u, fmt.Errorf("request %s fail, it is not sent again since PD could have handled it: %w", u, err)
+				}
+				// Connection error, fail over to the next member
+				lastErr = err
+				continue
+			}
+			if resp.statusCode < 200 || resp.statusCode >= 300 {
+				respErr := newResponseError(route, u, resp.statusCode, resp.body)
+				if !isRetryable(respErr) {
+					return nil, u, respErr
+				}
+				// The leader could be changed, find it again in the next attempt
+				c.members.resetLeader()
+				if !resend(respErr) {
+					return nil, u, respErr
+				}
+				lastErr = respErr
+				break
+			}
+			c.members.setCurrent(ep)
+			return resp.body, u, nil
+		}
+	}
+	return nil, "", fmt.Errorf("request PD %v fail after %d retries: %w", c.members.list(), c.maxRetry, lastErr)
+}
+
+type response struct {
+	statusCode int
+	body       []byte
+}
+
+// send sends the request to a PD member, the request is logged with its
+// latency at debug level
+func (c *Client) send(method, u string, body []byte) (res *response, err error) {
+	defer func(start time.Time) {
+		if err != nil {
+			logger.Debugf("[http] %s %s => %s, %dms (pd)", method, u, err, time.Since(start).Milliseconds())
+		} else {
+			logger.Debugf("[http] %s %s => %d, %dms (pd)", method, u, res.statusCode, time.Since(start).Milliseconds())
+		}
+	}(time.Now())
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, u, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &response{statusCode: resp.StatusCode, body: respBody}, nil
+}
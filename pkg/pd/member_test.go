@@ -0,0 +1,205 @@
+package pd_test
+
+import (
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/pd"
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/tidb"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakePDMember is a PD member that reports the leader by the leader URL, and
+// counts the requests of getting Regions and adding operators
+type fakePDMember struct {
+	server       *httptest.Server
+	leaderURL    atomic.Value
+	numLeader    int32
+	numRegions   int32
+	numOperators int32
+	// The num of requests to fail with 503 before success
+	numUnavailable int32
+	// Close the connection without response after reading the request
+	hangUp int32
+}
+
+func newFakePDMember() *fakePDMember {
+	m := &fakePDMember{}
+	m.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/pd/api/v1/leader":
+			atomic.AddInt32(&m.numLeader, 1)
+			leader, _ := m.leaderURL.Load().(string)
+			if leader == "" {
+				leader = m.server.URL
+			}
+			fmt.Fprintf(w, `{"name": "pd", "client_urls": ["%s"]}`, leader)
+		case "/pd/api/v1/region/id/58":
+			atomic.AddInt32(&m.numRegions, 1)
+			if atomic.AddInt32(&m.numUnavailable, -1) >= 0 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				w.Write([]byte(`no leader`))
+				return
+			}
+			w.Write([]byte(`{"id": 58, "start_key": "", "end_key": ""}`))
+		case "/pd/api/v1/operators":
+			atomic.AddInt32(&m.numOperators, 1)
+			if atomic.LoadInt32(&m.hangUp) > 0 {
+				conn, _, _ := w.(http.Hijacker).Hijack()
+				conn.Close()
+				return
+			}
+			if atomic.AddInt32(&m.numUnavailable, -1) >= 0 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				w.Write([]byte(`no leader`))
+				return
+			}
+			w.Write([]byte(`"The operator is created."`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return m
+}
+
+func (m *fakePDMember) addr() string {
+	return strings.TrimPrefix(m.server.URL, "http://")
+}
+
+func TestRequestToLeader(t *testing.T) {
+	leader, follower := newFakePDMember(), newFakePDMember()
+	defer leader.server.Close()
+	defer follower.server.Close()
+	follower.leaderURL.Store(leader.server.URL)
+
+	client := pd.NewPDClient(follower.addr(), leader.addr())
+	region, err := client.GetRegionByID(58)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, int64(58), region.Id)
+	assert.Equal(t, int32(1), leader.numRegions)
+	assert.Equal(t, int32(0), follower.numRegions)
+	assert.Equal(t, []string{leader.addr(), follower.addr()}, client.Endpoints())
+
+	// The leader is found once
+	_, err = client.GetRegionByID(58)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, int32(1), follower.numLeader)
+	assert.Equal(t, int32(2), leader.numRegions)
+}
+
+func TestLeaderNotInEndpoints(t *testing.T) {
+	leader, follower := newFakePDMember(), newFakePDMember()
+	defer leader.server.Close()
+	defer follower.server.Close()
+	follower.leaderURL.Store(leader.server.URL)
+
+	client := pd.NewPDClient(follower.addr())
+	_, err := client.GetRegionByID(58)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, int32(1), leader.numRegions)
+	assert.Equal(t, leader.addr(), client.Endpoints()[0])
+}
+
+func TestFailoverOnConnectionError(t *testing.T) {
+	down, alive := newFakePDMember(), newFakePDMember()
+	defer alive.server.Close()
+	down.server.Close()
+
+	client := pd.NewPDClient(down.addr(), alive.addr())
+	client.SetRetry(0, time.Millisecond)
+	region, err := client.GetRegionByID(58)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, int64(58), region.Id)
+	assert.Equal(t, alive.addr(), client.Endpoints()[0])
+
+	// The leader is down, fail over to the other member
+	leader, follower := newFakePDMember(), newFakePDMember()
+	defer follower.server.Close()
+	follower.leaderURL.Store(leader.server.URL)
+	client = pd.NewPDClient(follower.addr(), leader.addr())
+	client.SetRetry(0, time.Millisecond)
+	_, err = client.GetRegionByID(58)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, int32(1), leader.numRegions)
+	leader.server.Close()
+	_, err = client.GetRegionByID(58)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, int32(1), follower.numRegions)
+	assert.Equal(t, follower.addr(), client.Endpoints()[0])
+}
+
+func TestRetryTransientError(t *testing.T) {
+	member := newFakePDMember()
+	defer member.server.Close()
+	member.numUnavailable = 2
+
+	client := pd.NewPDClient(member.addr())
+	client.SetRetry(3, time.Millisecond)
+	region, err := client.GetRegionByID(58)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, int64(58), region.Id)
+	assert.Equal(t, int32(3), member.numRegions)
+	// The leader is found again after each transient error
+	assert.Equal(t, int32(3), member.numLeader)
+
+	member.numUnavailable = 10
+	_, err = client.GetRegionByID(58)
+	assert.NotEqual(t, err, nil)
+	assert.Contains(t, err.Error(), "503")
+	assert.Equal(t, int32(3+4), member.numRegions)
+}
+
+func TestPostNotSentAgain(t *testing.T) {
+	// Fail over on connection error, the request is not sent
+	down, alive := newFakePDMember(), newFakePDMember()
+	defer alive.server.Close()
+	down.server.Close()
+	client := pd.NewPDClient(down.addr(), alive.addr())
+	client.SetRetry(3, time.Millisecond)
+	assert.Equal(t, client.AddRemovePeerOperator(58, 4), nil)
+	assert.Equal(t, int32(1), alive.numOperators)
+
+	// The transient error is not retried
+	alive.numUnavailable = 1
+	err := client.AddRemovePeerOperator(58, 4)
+	assert.NotEqual(t, err, nil)
+	assert.Contains(t, err.Error(), "503")
+	assert.Equal(t, int32(2), alive.numOperators)
+
+	// The connection is closed after the request is sent
+	alive.hangUp = 1
+	err = client.AddRemovePeerOperator(58, 4)
+	assert.NotEqual(t, err, nil)
+	assert.Equal(t, int32(3), alive.numOperators)
+}
+
+func TestRequestWithTLS(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/pd/api/v1/leader" {
+			fmt.Fprintf(w, `{"name": "pd-0", "client_urls": ["%s"]}`, server.URL)
+			return
+		}
+		w.Write([]byte(`{"id": 58, "start_key": "", "end_key": ""}`))
+	}))
+	defer server.Close()
+
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	pemData := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	assert.Equal(t, os.WriteFile(ca, pemData, 0644), nil)
+
+	client := pd.NewPDClient(strings.TrimPrefix(server.URL, "https://"))
+	client.SetRetry(0, time.Millisecond)
+	assert.Equal(t, client.SetTLS(tidb.TLSOpts{CA: ca}), nil)
+	region, err := client.GetRegionByID(58)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, int64(58), region.Id)
+}
@@ -0,0 +1,70 @@
+package pd
+
+import "fmt"
+
+// MergeChain is the consecutive Regions that every two adjacent Regions share
+// an invalid boundary. Merging all of them into the first Region removes all
+// the invalid boundaries in the chain.
+type MergeChain struct {
+	Regions    []int64
+	Boundaries []string
+}
+
+// UnfixableBoundary is an invalid boundary that can not be removed by merging
+type UnfixableBoundary struct {
+	Key    string
+	Reason string
+}
+
+// PlanMerges builds the chains of Regions to merge from the Regions ordered by
+// start key, and the invalid boundaries (in PD key) of them. It also returns
+// the invalid boundaries that can not be fixed by merging, since the Region on
+// the other side is not scanned or not adjacent.
+func PlanMerges(regions []Region, invalidBoundaries map[string][]int64) ([]MergeChain, []UnfixableBoundary) {
+	var (
+		chains    []MergeChain
+		unfixable []UnfixableBoundary
+		cur       *MergeChain
+	)
+	reported := make(map[string]bool)
+	reportUnfixable := func(key, reason string) {
+		if !reported[key] {
+			reported[key] = true
+			unfixable = append(unfixable, UnfixableBoundary{Key: key, Reason: reason})
+		}
+	}
+	for i, region := range regions {
+		if _, ok := invalidBoundaries[region.StartKey]; ok {
+			if i == 0 {
+				reportUnfixable(region.StartKey, fmt.Sprintf("Region %d is the first Region of the table, the previous Region is out of the table", region.Id))
+			} else if regions[i-1].EndKey != region.StartKey {
+				reportUnfixable(region.StartKey, fmt.Sprintf("there is a gap or overlap between Region %d and Region %d", regions[i-1].Id, region.Id))
+			}
+		}
+
+		_, endIsInvalid := invalidBoundaries[region.EndKey]
+		linked := endIsInvalid && i+1 < len(regions) && regions[i+1].StartKey == region.EndKey
+		if endIsInvalid && !linked {
+			if i+1 == len(regions) {
+				reportUnfixable(region.EndKey, fmt.Sprintf("Region %d is the last Region of the table, the next Region is out of the table", region.Id))
+			} else {
+				reportUnfixable(region.EndKey, fmt.Sprintf("there is a gap or overlap between Region %d and Region %d", region.Id, regions[i+1].Id))
+			}
+		}
+
+		if linked {
+			if cur == nil {
+				cur = &MergeChain{Regions: []int64{region.Id}}
+			}
+			cur.Regions = append(cur.Regions, regions[i+1].Id)
+			cur.Boundaries = append(cur.Boundaries, region.EndKey)
+		} else if cur != nil {
+			chains = append(chains, *cur)
+			cur = nil
+		}
+	}
+	if cur != nil {
+		chains = append(chains, *cur)
+	}
+	return chains, unfixable
+}
@@ -0,0 +1,78 @@
+package pd_test
+
+import (
+	"testing"
+
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/pd"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanMerges(t *testing.T) {
+	region := func(id int64, start, end string) pd.Region {
+		return pd.Region{Id: id, StartKey: start, EndKey: end}
+	}
+	invalid := func(keys ...string) map[string][]int64 {
+		res := make(map[string][]int64)
+		for _, k := range keys {
+			res[k] = nil
+		}
+		return res
+	}
+	for _, c := range []struct {
+		name      string
+		regions   []pd.Region
+		invalid   map[string][]int64
+		chains    []pd.MergeChain
+		unfixable []pd.UnfixableBoundary
+	}{
+		{
+			name:    "no invalid boundary",
+			regions: []pd.Region{region(1, "a", "b"), region(2, "b", "c")},
+			invalid: invalid(),
+		},
+		{
+			name:      "single Region with invalid start",
+			regions:   []pd.Region{region(1, "a", "b")},
+			invalid:   invalid("a"),
+			unfixable: []pd.UnfixableBoundary{{Key: "a", Reason: "Region 1 is the first Region of the table, the previous Region is out of the table"}},
+		},
+		{
+			name:      "single Region with invalid end",
+			regions:   []pd.Region{region(1, "a", "b")},
+			invalid:   invalid("b"),
+			unfixable: []pd.UnfixableBoundary{{Key: "b", Reason: "Region 1 is the last Region of the table, the next Region is out of the table"}},
+		},
+		{
+			name:    "chain of 4 Regions",
+			regions: []pd.Region{region(1, "a", "b"), region(2, "b", "c"), region(3, "c", "d"), region(4, "d", "e"), region(5, "e", "f")},
+			invalid: invalid("b", "c", "d"),
+			chains:  []pd.MergeChain{{Regions: []int64{1, 2, 3, 4}, Boundaries: []string{"b", "c", "d"}}},
+		},
+		{
+			name:    "two separate chains",
+			regions: []pd.Region{region(1, "a", "b"), region(2, "b", "c"), region(3, "c", "d"), region(4, "d", "e"), region(5, "e", "f")},
+			invalid: invalid("b", "d"),
+			chains: []pd.MergeChain{
+				{Regions: []int64{1, 2}, Boundaries: []string{"b"}},
+				{Regions: []int64{3, 4}, Boundaries: []string{"d"}},
+			},
+		},
+		{
+			name:    "gap inside a chain",
+			regions: []pd.Region{region(1, "a", "b"), region(2, "b", "c"), region(3, "c1", "d"), region(4, "d", "e")},
+			invalid: invalid("b", "c", "c1", "d"),
+			chains: []pd.MergeChain{
+				{Regions: []int64{1, 2}, Boundaries: []string{"b"}},
+				{Regions: []int64{3, 4}, Boundaries: []string{"d"}},
+			},
+			unfixable: []pd.UnfixableBoundary{
+				{Key: "c", Reason: "there is a gap or overlap between Region 2 and Region 3"},
+				{Key: "c1", Reason: "there is a gap or overlap between Region 2 and Region 3"},
+			},
+		},
+	} {
+		chains, unfixable := pd.PlanMerges(c.regions, c.invalid)
+		assert.Equal(t, c.chains, chains, c.name)
+		assert.Equal(t, c.unfixable, unfixable, c.name)
+	}
+}
@@ -0,0 +1,154 @@
+package pd
+
+import "net"
+
+// The states of store
+const (
+	StoreStateUp           = "Up"
+	StoreStateOffline      = "Offline"
+	StoreStateTombstone    = "Tombstone"
+	StoreStateDisconnected = "Disconnected"
+	StoreStateDown         = "Down"
+)
+
+// The label key and value for TiFlash stores
+const (
+	EngineLabelKey     = "engine"
+	EngineLabelTiFlash = "tiflash"
+)
+
+type StoreLabel struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type Store struct {
+	Id            int64        `json:"id"`
+	Address       string       `json:"address"`
+	StatusAddress string       `json:"status_address"`
+	Version       string       `json:"version"`
+	Labels        []StoreLabel `json:"labels"`
+	StateName     string       `json:"state_name"`
+
+	// The sizes are human readable strings like "1.5TiB"
+	Capacity    string `json:"capacity"`
+	Available   string `json:"available"`
+	UsedSize    string `json:"used_size"`
+	LeaderCount int64  `json:"leader_count"`
+	RegionCount int64  `json:"region_count"`
+}
+
+// storeInfo is the format of each store in the response of PD
+type storeInfo struct {
+	Store struct {
+		Id            int64        `json:"id"`
+		Address       string       `json:"address"`
+		StatusAddress string       `json:"status_address"`
+		Version       string       `json:"version"`
+		Labels        []StoreLabel `json:"labels"`
+		StateName     string       `json:"state_name"`
+	} `json:"store"`
+	Status struct {
+		Capacity    string `json:"capacity"`
+		Available   string `json:"available"`
+		UsedSize    string `json:"used_size"`
+		LeaderCount int64  `json:"leader_count"`
+		RegionCount int64  `json:"region_count"`
+	} `json:"status"`
+}
+
+type storesResp struct {
+	Count  int64       `json:"count"`
+	Stores []storeInfo `json:"stores"`
+}
+
+func (s *Store) GetLabel(key string) string {
+	for _, l := range s.Labels {
+		if l.Key == key {
+			return l.Value
+		}
+	}
+	return ""
+}
+
+func (s *Store) IsTiFlash() bool {
+	return s.GetLabel(EngineLabelKey) == EngineLabelTiFlash
+}
+
+// Host returns the host of the store address
+func (s *Store) Host() string {
+	host, _, err := net.SplitHostPort(s.Address)
+	if err != nil {
+		return s.Address
+	}
+	return host
+}
+
+// StatusHost returns the host of the status address, it is the host of the
+// store address if the status address is not valid. Note that for TiFlash the
+// port of status address is the status port of the proxy, not the HTTP port.
+func (s *Store) StatusHost() string {
+	host, _, err := net.SplitHostPort(s.StatusAddress)
+	if err != nil || host == "" {
+		return s.Host()
+	}
+	return host
+}
+
+// GetStores returns all the stores except the tombstone ones. Whether PD
+// returns the tombstone stores by default differs between versions, so they
+// are filtered out here.
+func (c *Client) GetStores() ([]Store, error) {
+	var result storesResp
+	if err := c.getJSON("stores", nil, &result); err != nil {
+		return nil, err
+	}
+	stores := make([]Store, 0, len(result.Stores))
+	for _, info := range result.Stores {
+		if info.Store.StateName == StoreStateTombstone {
+			continue
+		}
+		stores = append(stores, Store{
+			Id:            info.Store.Id,
+			Address:       info.Store.Address,
+			StatusAddress: info.Store.StatusAddress,
+			Version:       info.Store.Version,
+			Labels:        info.Store.Labels,
+			StateName:     info.Store.StateName,
+			Capacity:      info.Status.Capacity,
+			Available:     info.Status.Available,
+			UsedSize:      info.Status.UsedSize,
+			LeaderCount:   info.Status.LeaderCount,
+			RegionCount:   info.Status.RegionCount,
+		})
+	}
+	return stores, nil
+}
+
+// GetTiFlashStores returns the TiFlash stores except the tombstone ones
+func (c *Client) GetTiFlashStores() ([]Store, error) {
+	stores, err := c.GetStores()
+	if err != nil {
+		return nil, err
+	}
+	var res []Store
+	for _, s := range stores {
+		if s.IsTiFlash() {
+			res = append(res, s)
+		}
+	}
+	return res, nil
+}
+
+// GetStoreMap returns the stores indexed by store id
+func (c *Client) GetStoreMap() (map[int64]Store, error) {
+	stores, err := c.GetStores()
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[int64]Store, len(stores))
+	for _, s := range stores {
+		res[s.Id] = s
+	}
+	return res, nil
+}
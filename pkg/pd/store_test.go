@@ -0,0 +1,99 @@
+package pd_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/pd"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetStores(t *testing.T) {
+	server := newTestPDServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/pd/api/v1/stores", r.URL.Path)
+		w.Write([]byte(`
+{
+  "count": 3,
+  "stores": [
+    {
+      "store": {
+        "id": 1,
+        "address": "172.16.5.81:20160",
+        "version": "5.4.0",
+        "status_address": "172.16.5.81:20180",
+        "state_name": "Up"
+      },
+      "status": {
+        "capacity": "1.968TiB",
+        "available": "1.5TiB",
+        "used_size": "12.5GiB",
+        "leader_count": 30,
+        "region_count": 90
+      }
+    },
+    {
+      "store": {
+        "id": 62,
+        "address": "172.16.5.82:3930",
+        "labels": [{"key": "engine", "value": "tiflash"}],
+        "version": "v5.4.0",
+        "status_address": "172.16.5.82:20292",
+        "state_name": "Offline"
+      },
+      "status": {
+        "capacity": "500GiB",
+        "available": "400GiB",
+        "used_size": "2GiB",
+        "region_count": 20
+      }
+    },
+    {
+      "store": {
+        "id": 63,
+        "address": "172.16.5.83:3930",
+        "labels": [{"key": "engine", "value": "tiflash"}],
+        "version": "v5.4.0",
+        "status_address": "172.16.5.83:20292",
+        "state_name": "Tombstone"
+      },
+      "status": {}
+    }
+  ]
+}`))
+	}))
+	defer server.Close()
+
+	client := newTestPDClient(server)
+	stores, err := client.GetStores()
+	assert.Equal(t, err, nil)
+	// The tombstone store is excluded
+	assert.Equal(t, 2, len(stores))
+
+	tikv := stores[0]
+	assert.Equal(t, int64(1), tikv.Id)
+	assert.False(t, tikv.IsTiFlash())
+	assert.Equal(t, pd.StoreStateUp, tikv.StateName)
+	assert.Equal(t, "1.968TiB", tikv.Capacity)
+	assert.Equal(t, int64(30), tikv.LeaderCount)
+	assert.Equal(t, int64(90), tikv.RegionCount)
+
+	tiflash := stores[1]
+	assert.Equal(t, int64(62), tiflash.Id)
+	assert.True(t, tiflash.IsTiFlash())
+	assert.Equal(t, pd.StoreStateOffline, tiflash.StateName)
+	assert.Equal(t, "172.16.5.82", tiflash.Host())
+	assert.Equal(t, "172.16.5.82", tiflash.StatusHost())
+	// Fall back to the host of store address
+	assert.Equal(t, "172.16.5.82", (&pd.Store{Address: "172.16.5.82:3930"}).StatusHost())
+	assert.Equal(t, int64(0), tiflash.LeaderCount)
+
+	tiflashStores, err := client.GetTiFlashStores()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, []pd.Store{tiflash}, tiflashStores)
+
+	storeMap, err := client.GetStoreMap()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, tikv, storeMap[1])
+	_, ok := storeMap[63]
+	assert.False(t, ok)
+}
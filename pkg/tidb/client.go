@@ -2,10 +2,15 @@ package tidb
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/logger"
+	"github.com/go-sql-driver/mysql"
 )
 
 type TiDBClientOpts struct {
@@ -13,18 +18,83 @@ type TiDBClientOpts struct {
 	Port     int32
 	User     string
 	Password string
+	// The PD addresses, found from `information_schema.cluster_info` if empty
+	PDAddrs []string
+	// The certificates for the TLS-enabled cluster, used for requesting PD and
+	// TiFlash
+	TLS TLSOpts
+	// The certificates for the TLS connection to TiDB. It is configured
+	// separately from the TLS between the components by TiDB, the certificates
+	// of the cluster are used if they are not set, see GetSQLTLS.
+	SQLTLS TLSOpts
+	// The time zone of all the sessions like "+00:00", the time zone of TiDB
+	// is used if it is empty
+	TimeZone string
 }
 
 type Client struct {
 	Db *sql.DB // TODO: Maybe find a way not exposing it to public?
 }
 
+// mysqlLogger writes the errors of the MySQL driver to the logs
+type mysqlLogger struct{}
+
+func (mysqlLogger) Print(v ...interface{}) {
+	logger.Warnf("[mysql] %s", fmt.Sprint(v...))
+}
+
+func init() {
+	mysql.SetLogger(mysqlLogger{})
+}
+
 func NewClientFromOpts(opts TiDBClientOpts) (Client, error) {
-	return NewClient(opts.Host, int32(opts.Port), opts.User, opts.Password)
+	params, err := opts.dsnParams()
+	if err != nil {
+		return Client{}, err
+	}
+	return newClient(opts.Host, int32(opts.Port), opts.User, opts.Password, params)
+}
+
+// NewSnapshotClientFromOpts returns a client that all of its connections read
+// the data at the snapshot of the TSO by setting `tidb_snapshot`
+func NewSnapshotClientFromOpts(opts TiDBClientOpts, tso uint64) (Client, error) {
+	params, err := opts.dsnParams()
+	if err != nil {
+		return Client{}, err
+	}
+	snapshot := url.QueryEscape(fmt.Sprintf("'%d'", tso))
+	return newClient(opts.Host, int32(opts.Port), opts.User, opts.Password, params+"&tidb_snapshot="+snapshot)
+}
+
+// GetSQLTLS returns the certificates for the connection to TiDB. The
+// certificates of the cluster are used if none of SQLTLS is set.
+func (opts TiDBClientOpts) GetSQLTLS() TLSOpts {
+	if opts.SQLTLS.IsEnabled() {
+		return opts.SQLTLS
+	}
+	return opts.TLS
+}
+
+// dsnParams returns the params to add to the dsn for the TLS config and the
+// time zone. The driver sets the params that it does not know as system
+// variables on connecting, so they are set for all the connections in pool.
+func (opts TiDBClientOpts) dsnParams() (string, error) {
+	params, err := registerTLSConfig(opts.GetSQLTLS())
+	if err != nil {
+		return "", err
+	}
+	if opts.TimeZone != "" {
+		params += "&time_zone=" + url.QueryEscape(fmt.Sprintf("'%s'", opts.TimeZone))
+	}
+	return params, nil
 }
 
 func NewClient(host string, port int32, user, password string) (Client, error) {
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/?charset=utf8", user, password, host, port)
+	return newClient(host, port, user, password, "")
+}
+
+func newClient(host string, port int32, user, password string, params string) (Client, error) {
+	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/?charset=utf8%s", user, password, host, port, params)
 	db, err := sql.Open("mysql", dsn)
 	if err != nil {
 		return Client{}, fmt.Errorf("connect to database fail: %s", err)
@@ -37,29 +107,135 @@ func (c *Client) Close() error {
 }
 
 func (c *Client) ExecWithElapsed(sql string) error {
-	defer func(start time.Time) {
-		elapsed := time.Since(start)
-		fmt.Printf("%s => %dms\n", sql, elapsed.Milliseconds())
-	}(time.Now())
+	defer LogSQL(sql, "", time.Now())
 
 	_, err := c.Db.Exec(sql)
 	return err
 }
 
-func (c *Client) GetTableID(dbName, tblName string) (int64, error) {
-	rows, err := c.Db.Query("select TABLE_ID from information_schema.tiflash_replica where TABLE_SCHEMA = ? and TABLE_NAME = ?", dbName, tblName)
+// LogSQL logs the statement with its latency since start at debug level.
+// engine is the one set by `tidb_isolation_read_engines`, empty for default.
+func LogSQL(sql string, engine string, start time.Time) {
+	if engine == "" {
+		engine = "default"
+	}
+	logger.Debugf("[sql] %s => %dms (%s)", strings.Join(strings.Fields(sql), " "), time.Since(start).Milliseconds(), engine)
+}
+
+func (c *Client) query(query string, args ...interface{}) (*sql.Rows, error) {
+	defer LogSQL(query, "", time.Now())
+	return c.Db.Query(query, args...)
+}
+
+func (c *Client) queryRow(query string, args ...interface{}) *sql.Row {
+	defer LogSQL(query, "", time.Now())
+	return c.Db.QueryRow(query, args...)
+}
+
+// GetCurrentTSO returns the start TSO of a new transaction
+func (c *Client) GetCurrentTSO() (uint64, error) {
+	txn, err := c.Db.Begin()
 	if err != nil {
 		return 0, err
 	}
-	var table_id int64
+	defer txn.Rollback()
+	var tso uint64
+	const query = "select @@tidb_current_ts"
+	defer LogSQL(query, "", time.Now())
+	if err = txn.QueryRow(query).Scan(&tso); err != nil {
+		return 0, err
+	}
+	return tso, nil
+}
+
+// TSOToTime returns the physical time of the TSO
+func TSOToTime(tso uint64) time.Time {
+	// The lower 18 bits are the logical part
+	physical := int64(tso >> 18)
+	return time.Unix(physical/1000, (physical%1000)*int64(time.Millisecond))
+}
+
+// PhysicalTable is a table or a partition of the partitioned table, each of
+// them owns a range of keys `t{table_id}` in TiKV
+type PhysicalTable struct {
+	ID int64
+	// The partition name, empty if the table is not partitioned
+	PartitionName string
+}
+
+func (t PhysicalTable) IsPartition() bool {
+	return t.PartitionName != ""
+}
+
+func (t PhysicalTable) String() string {
+	if t.IsPartition() {
+		return fmt.Sprintf("partition `%s` (id: %d)", t.PartitionName, t.ID)
+	}
+	return fmt.Sprintf("table id: %d", t.ID)
+}
+
+// GetPhysicalTables returns the physical tables of a table. For the partitioned
+// table, it is the partitions ordered by the definition, otherwise it is the
+// table itself.
+func (c *Client) GetPhysicalTables(dbName, tblName string) ([]PhysicalTable, error) {
+	var tableID int64
+	err := c.queryRow("select TIDB_TABLE_ID from information_schema.tables where TABLE_SCHEMA = ? and TABLE_NAME = ?", dbName, tblName).Scan(&tableID)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("can not find table `%s`.`%s`", dbName, tblName)
+	} else if err != nil {
+		return nil, err
+	}
+
+	rows, err := c.query(`select PARTITION_NAME, TIDB_PARTITION_ID from information_schema.partitions
+where TABLE_SCHEMA = ? and TABLE_NAME = ? and PARTITION_NAME is not null
+order by PARTITION_ORDINAL_POSITION`, dbName, tblName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tables []PhysicalTable
 	for rows.Next() {
-		rows.Scan(&table_id)
+		var t PhysicalTable
+		if err = rows.Scan(&t.PartitionName, &t.ID); err != nil {
+			return nil, err
+		}
+		tables = append(tables, t)
+	}
+	if len(tables) == 0 {
+		tables = append(tables, PhysicalTable{ID: tableID})
+	}
+	return tables, nil
+}
+
+// FilterPartitions returns the partitions with the names in the comma separated
+// list. All the tables are returned if the list is empty.
+func FilterPartitions(tables []PhysicalTable, partitions string) ([]PhysicalTable, error) {
+	if partitions == "" {
+		return tables, nil
+	}
+	if len(tables) > 0 && !tables[0].IsPartition() {
+		return nil, fmt.Errorf("the table is not partitioned, but partition %s is specified", partitions)
+	}
+	var filtered []PhysicalTable
+	for _, name := range strings.Split(partitions, ",") {
+		name = strings.TrimSpace(name)
+		found := false
+		for _, t := range tables {
+			if strings.EqualFold(t.PartitionName, name) {
+				filtered = append(filtered, t)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("can not find partition `%s`", name)
+		}
 	}
-	return table_id, nil
+	return filtered, nil
 }
 
 func (c *Client) GetInstances(selectType string) ([]string, error) {
-	rows, err := c.Db.Query("select INSTANCE from information_schema.cluster_info where type = ?", selectType)
+	rows, err := c.query("select INSTANCE from information_schema.cluster_info where type = ?", selectType)
 	if err != nil {
 		return nil, err
 	}
@@ -71,3 +247,263 @@ func (c *Client) GetInstances(selectType string) ([]string, error) {
 	}
 	return pdInstances, nil
 }
+
+// GetClusterConfig returns the config items of the instances with the type from
+// `information_schema.cluster_config`, indexed by instance then key
+func (c *Client) GetClusterConfig(selectType string, keys []string) (map[string]map[string]string, error) {
+	query := "select INSTANCE, `KEY`, VALUE from information_schema.cluster_config where type = ?"
+	args := []interface{}{selectType}
+	if len(keys) > 0 {
+		query += " and `KEY` in (?" + strings.Repeat(", ?", len(keys)-1) + ")"
+		for _, k := range keys {
+			args = append(args, k)
+		}
+	}
+	rows, err := c.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	configs := make(map[string]map[string]string)
+	var inst, key, value string
+	for rows.Next() {
+		if err = rows.Scan(&inst, &key, &value); err != nil {
+			return nil, err
+		}
+		if configs[inst] == nil {
+			configs[inst] = make(map[string]string)
+		}
+		configs[inst][key] = value
+	}
+	return configs, rows.Err()
+}
+
+type Column struct {
+	Name       string
+	DataType   string
+	ColumnType string
+	Collation  string
+}
+
+func (c *Column) IsUnsigned() bool {
+	return strings.Contains(c.ColumnType, "unsigned")
+}
+
+// TypeArgs returns the arguments in the column type, for example,
+// [10, 2] for "decimal(10,2)" and [3] for "datetime(3)"
+func (c *Column) TypeArgs() []int {
+	l, r := strings.IndexByte(c.ColumnType, '('), strings.IndexByte(c.ColumnType, ')')
+	if l < 0 || r < l {
+		return nil
+	}
+	var args []int
+	for _, s := range strings.Split(c.ColumnType[l+1:r], ",") {
+		v, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			return nil
+		}
+		args = append(args, v)
+	}
+	return args
+}
+
+// GetColumns returns the columns of a table ordered by the definition
+func (c *Client) GetColumns(dbName, tblName string) ([]Column, error) {
+	rows, err := c.query(`select COLUMN_NAME, DATA_TYPE, COLUMN_TYPE, ifnull(COLLATION_NAME, '')
+from information_schema.columns
+where TABLE_SCHEMA = ? and TABLE_NAME = ?
+order by ORDINAL_POSITION`, dbName, tblName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var cols []Column
+	for rows.Next() {
+		var col Column
+		if err = rows.Scan(&col.Name, &col.DataType, &col.ColumnType, &col.Collation); err != nil {
+			return nil, err
+		}
+		cols = append(cols, col)
+	}
+	return cols, nil
+}
+
+// GetCommonHandleColumns returns the clustered primary key columns of a table
+// whose rows are keyed by a common handle. It returns nil if the rows are keyed
+// by `_tidb_rowid` or an int primary key.
+func (c *Client) GetCommonHandleColumns(dbName, tblName string) ([]Column, error) {
+	isClustered, err := c.IsClustered(dbName, tblName)
+	if err != nil || !isClustered {
+		return nil, err
+	}
+	cols, err := c.getPrimaryKeyColumns(dbName, tblName)
+	if err != nil {
+		return nil, err
+	}
+	if len(cols) == 1 && IsIntType(cols[0].DataType) {
+		// clustered by an int primary key, the rows are keyed by int handle
+		return nil, nil
+	}
+	return cols, nil
+}
+
+// GetIntHandleColumn returns the column name of the int handle of a table, it
+// is the int primary key if the table is clustered by it, otherwise it is
+// `_tidb_rowid`. The result is meaningless for the table with common handle.
+func (c *Client) GetIntHandleColumn(dbName, tblName string) (string, error) {
+	isClustered, err := c.IsClustered(dbName, tblName)
+	if err != nil {
+		return "", err
+	}
+	if !isClustered {
+		return "_tidb_rowid", nil
+	}
+	cols, err := c.getPrimaryKeyColumns(dbName, tblName)
+	if err != nil {
+		return "", err
+	}
+	if len(cols) == 1 && IsIntType(cols[0].DataType) {
+		return "`" + cols[0].Name + "`", nil
+	}
+	return "_tidb_rowid", nil
+}
+
+func (c *Client) getPrimaryKeyColumns(dbName, tblName string) ([]Column, error) {
+	rows, err := c.query(`select k.COLUMN_NAME, c.DATA_TYPE, c.COLUMN_TYPE, ifnull(c.COLLATION_NAME, '')
+from information_schema.key_column_usage k, information_schema.columns c
+where k.TABLE_SCHEMA = ? and k.TABLE_NAME = ? and k.CONSTRAINT_NAME = 'PRIMARY'
+	and c.TABLE_SCHEMA = k.TABLE_SCHEMA and c.TABLE_NAME = k.TABLE_NAME and c.COLUMN_NAME = k.COLUMN_NAME
+order by k.ORDINAL_POSITION`, dbName, tblName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var cols []Column
+	for rows.Next() {
+		var col Column
+		if err = rows.Scan(&col.Name, &col.DataType, &col.ColumnType, &col.Collation); err != nil {
+			return nil, err
+		}
+		cols = append(cols, col)
+	}
+	return cols, nil
+}
+
+// TiFlashReplica is a table with TiFlash replica
+type TiFlashReplica struct {
+	DBName       string
+	TableName    string
+	ReplicaCount int
+	Available    bool
+}
+
+// GetTiFlashReplicas returns the tables with TiFlash replica in the database,
+// or in all databases if dbName is empty.
+func (c *Client) GetTiFlashReplicas(dbName string) ([]TiFlashReplica, error) {
+	query := "select TABLE_SCHEMA, TABLE_NAME, REPLICA_COUNT, AVAILABLE from information_schema.tiflash_replica"
+	var args []interface{}
+	if dbName != "" {
+		query += " where TABLE_SCHEMA = ?"
+		args = append(args, dbName)
+	}
+	query += " order by TABLE_SCHEMA, TABLE_NAME"
+	rows, err := c.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var replicas []TiFlashReplica
+	for rows.Next() {
+		var r TiFlashReplica
+		if err = rows.Scan(&r.DBName, &r.TableName, &r.ReplicaCount, &r.Available); err != nil {
+			return nil, err
+		}
+		// There could be one row for each partition of a partitioned table
+		if n := len(replicas); n > 0 && replicas[n-1].DBName == r.DBName && replicas[n-1].TableName == r.TableName {
+			replicas[n-1].Available = replicas[n-1].Available && r.Available
+			continue
+		}
+		replicas = append(replicas, r)
+	}
+	return replicas, nil
+}
+
+// The error number of unknown column in MySQL protocol
+const errNoBadField = 1054
+
+// IsUnknownColumnError returns whether the error is caused by the column not
+// existing, the column name is case-insensitive
+func IsUnknownColumnError(err error, column string) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == errNoBadField &&
+		strings.Contains(strings.ToUpper(mysqlErr.Message), "'"+strings.ToUpper(column)+"'")
+}
+
+// IsClustered returns whether the rows of the table are clustered by its primary key
+func (c *Client) IsClustered(dbName, tblName string) (bool, error) {
+	var pkType string
+	err := c.queryRow("select TIDB_PK_TYPE from information_schema.tables where TABLE_SCHEMA = ? and TABLE_NAME = ?", dbName, tblName).Scan(&pkType)
+	if IsUnknownColumnError(err, "TIDB_PK_TYPE") {
+		// TIDB_PK_TYPE is not exist before v5.0, which does not support common handle
+		logger.Warnf("Can not get the pk type of `%s`.`%s`, regard it as non-clustered. %v", dbName, tblName, err)
+		return false, nil
+	} else if err == sql.ErrNoRows {
+		return false, fmt.Errorf("table `%s`.`%s` is not found", dbName, tblName)
+	} else if err != nil {
+		return false, err
+	}
+	return pkType == "CLUSTERED", nil
+}
+
+type Index struct {
+	Name    string
+	ID      int64
+	Columns []string
+}
+
+// GetIndexes returns the indexes of a table ordered by index id. The clustered
+// primary key (and the int primary key as row id) is not included since it is
+// not stored as an index.
+func (c *Client) GetIndexes(dbName, tblName string) ([]Index, error) {
+	isClustered, err := c.IsClustered(dbName, tblName)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := c.query(`select KEY_NAME, INDEX_ID, ifnull(COLUMN_NAME, ifnull(EXPRESSION, ''))
+from information_schema.tidb_indexes
+where TABLE_SCHEMA = ? and TABLE_NAME = ?
+order by INDEX_ID, SEQ_IN_INDEX`, dbName, tblName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var indexes []Index
+	for rows.Next() {
+		var (
+			name   string
+			id     int64
+			column string
+		)
+		if err = rows.Scan(&name, &id, &column); err != nil {
+			return nil, err
+		}
+		// The int primary key used as row id comes with index id 0
+		if id == 0 || (isClustered && name == "PRIMARY") {
+			continue
+		}
+		if len(indexes) == 0 || indexes[len(indexes)-1].ID != id {
+			indexes = append(indexes, Index{Name: name, ID: id})
+		}
+		last := &indexes[len(indexes)-1]
+		last.Columns = append(last.Columns, column)
+	}
+	return indexes, nil
+}
+
+func IsIntType(dataType string) bool {
+	switch dataType {
+	case "tinyint", "smallint", "mediumint", "int", "bigint":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package tidb_test
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/tidb"
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterPartitions(t *testing.T) {
+	partitions := []tidb.PhysicalTable{
+		{ID: 101, PartitionName: "p0"},
+		{ID: 102, PartitionName: "p1"},
+		{ID: 103, PartitionName: "pMax"},
+	}
+
+	filtered, err := tidb.FilterPartitions(partitions, "")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, partitions, filtered)
+
+	filtered, err = tidb.FilterPartitions(partitions, "pmax, p0")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, []tidb.PhysicalTable{partitions[2], partitions[0]}, filtered)
+
+	_, err = tidb.FilterPartitions(partitions, "p2")
+	assert.NotNil(t, err)
+
+	// Not a partitioned table
+	table := []tidb.PhysicalTable{{ID: 100}}
+	filtered, err = tidb.FilterPartitions(table, "")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, table, filtered)
+	_, err = tidb.FilterPartitions(table, "p0")
+	assert.NotNil(t, err)
+}
+
+func TestTSOToTime(t *testing.T) {
+	// 2021-09-01 12:00:00.123 UTC with logical part 5
+	tso := uint64(1630497600123)<<18 | 5
+	ts := tidb.TSOToTime(tso)
+	assert.Equal(t, time.Date(2021, 9, 1, 12, 0, 0, 123*int(time.Millisecond), time.UTC), ts.UTC())
+}
+
+func TestIsUnknownColumnError(t *testing.T) {
+	// The error returned by TiDB before v5.0
+	err := &mysql.MySQLError{Number: 1054, Message: "Unknown column 'tidb_pk_type' in 'field list'"}
+	assert.True(t, tidb.IsUnknownColumnError(err, "TIDB_PK_TYPE"))
+	assert.True(t, tidb.IsUnknownColumnError(fmt.Errorf("query fail: %w", err), "TIDB_PK_TYPE"))
+	assert.False(t, tidb.IsUnknownColumnError(err, "TIDB_PK"))
+	assert.False(t, tidb.IsUnknownColumnError(&mysql.MySQLError{Number: 1054, Message: "Unknown column 'SEGMENT_COUNT' in 'field list'"}, "TIDB_PK_TYPE"))
+	assert.False(t, tidb.IsUnknownColumnError(sql.ErrNoRows, "TIDB_PK_TYPE"))
+	assert.False(t, tidb.IsUnknownColumnError(fmt.Errorf("connection refused"), "TIDB_PK_TYPE"))
+	assert.False(t, tidb.IsUnknownColumnError(nil, "TIDB_PK_TYPE"))
+}
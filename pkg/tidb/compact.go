@@ -0,0 +1,119 @@
+package tidb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// The error number of syntax error in MySQL protocol
+const errNoParseError = 1064
+
+// IsSyntaxError returns whether the statement is not supported by the parser
+// of TiDB, which is usually because the version of TiDB is too old
+func IsSyntaxError(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == errNoParseError
+}
+
+// CompactSQL returns the statement to compact the TiFlash replica of the table,
+// or the partitions of it if partitions is not empty
+func CompactSQL(dbName, tblName string, partitions []string) string {
+	if len(partitions) == 0 {
+		return fmt.Sprintf("alter table `%s`.`%s` compact tiflash replica", dbName, tblName)
+	}
+	quoted := make([]string, 0, len(partitions))
+	for _, p := range partitions {
+		quoted = append(quoted, "`"+p+"`")
+	}
+	return fmt.Sprintf("alter table `%s`.`%s` compact partition %s tiflash replica", dbName, tblName, strings.Join(quoted, ", "))
+}
+
+// CompactTiFlashReplica compacts the TiFlash replica of the table (or the
+// partitions) on all the TiFlash instances. It blocks until the compaction is
+// finished. It is supported since TiDB v6.1, check the error by IsSyntaxError
+// for the older versions.
+func (c *Client) CompactTiFlashReplica(ctx context.Context, dbName, tblName string, partitions []string) error {
+	sql := CompactSQL(dbName, tblName, partitions)
+	defer LogSQL(sql, "tiflash", time.Now())
+	_, err := c.Db.ExecContext(ctx, sql)
+	return err
+}
+
+// TiFlashTableStat is the storage stat of a table (or a partition) on a
+// TiFlash instance
+type TiFlashTableStat struct {
+	// The store address of the TiFlash instance
+	Instance string
+	TableID  int64
+	// The database and table name in TiFlash storage, like `db_2`.`t_45`
+	MappedDBName    string
+	MappedTableName string
+	NumSegments     int64
+	NumRows         int64
+	// The num of rows in delta layer, they are merged into stable layer by
+	// compaction
+	NumDeltaRows int64
+}
+
+// GetTiFlashTableStats returns the stats of the physical tables on each TiFlash
+// instance, the tables without replica on an instance are not returned for it
+func (c *Client) GetTiFlashTableStats(tableIDs []int64) ([]TiFlashTableStat, error) {
+	if len(tableIDs) == 0 {
+		return nil, nil
+	}
+	query := "select TIFLASH_INSTANCE, TABLE_ID, `DATABASE`, `TABLE`, ifnull(SEGMENT_COUNT, 0), ifnull(TOTAL_ROWS, 0), ifnull(TOTAL_DELTA_ROWS, 0)" +
+		" from information_schema.tiflash_tables where IS_TOMBSTONE = 0 and TABLE_ID in (?" + strings.Repeat(", ?", len(tableIDs)-1) + ")"
+	args := make([]interface{}, 0, len(tableIDs))
+	for _, id := range tableIDs {
+		args = append(args, id)
+	}
+	rows, err := c.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var stats []TiFlashTableStat
+	for rows.Next() {
+		var s TiFlashTableStat
+		if err = rows.Scan(&s.Instance, &s.TableID, &s.MappedDBName, &s.MappedTableName, &s.NumSegments, &s.NumRows, &s.NumDeltaRows); err != nil {
+			return nil, err
+		}
+		stats = append(stats, s)
+	}
+	return stats, rows.Err()
+}
+
+// GetNumRegionsByStore returns the num of Region peers of the physical tables
+// on each store
+func (c *Client) GetNumRegionsByStore(tableIDs []int64) (map[int64]int64, error) {
+	if len(tableIDs) == 0 {
+		return nil, nil
+	}
+	query := `select p.STORE_ID, count(*)
+from information_schema.tikv_region_status s, information_schema.tikv_region_peers p
+where s.REGION_ID = p.REGION_ID and s.TABLE_ID in (?` + strings.Repeat(", ?", len(tableIDs)-1) + `)
+group by p.STORE_ID`
+	args := make([]interface{}, 0, len(tableIDs))
+	for _, id := range tableIDs {
+		args = append(args, id)
+	}
+	rows, err := c.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := make(map[int64]int64)
+	var storeID, num int64
+	for rows.Next() {
+		if err = rows.Scan(&storeID, &num); err != nil {
+			return nil, err
+		}
+		res[storeID] = num
+	}
+	return res, rows.Err()
+}
@@ -0,0 +1,23 @@
+package tidb_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/tidb"
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompactSQL(t *testing.T) {
+	assert.Equal(t, "alter table `test`.`t` compact tiflash replica", tidb.CompactSQL("test", "t", nil))
+	assert.Equal(t, "alter table `test`.`t` compact partition `p0`, `pMax` tiflash replica", tidb.CompactSQL("test", "t", []string{"p0", "pMax"}))
+}
+
+func TestIsSyntaxError(t *testing.T) {
+	err := &mysql.MySQLError{Number: 1064, Message: "You have an error in your SQL syntax"}
+	assert.True(t, tidb.IsSyntaxError(err))
+	assert.True(t, tidb.IsSyntaxError(fmt.Errorf("compact fail: %w", err)))
+	assert.False(t, tidb.IsSyntaxError(&mysql.MySQLError{Number: 1146, Message: "Table doesn't exist"}))
+	assert.False(t, tidb.IsSyntaxError(fmt.Errorf("connection refused")))
+}
@@ -0,0 +1,53 @@
+package tidb
+
+import (
+	"strings"
+
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/codec"
+)
+
+// CommonHandle is the handle of a row in the table clustered by a non-int or
+// multi-column primary key. It keeps both the datums of the primary key columns
+// and their memcomparable encoding in the row key.
+type CommonHandle struct {
+	datums  []codec.Datum
+	encoded []byte
+}
+
+func NewCommonHandle(datums ...codec.Datum) (*CommonHandle, error) {
+	encoded, err := codec.EncodeKey(nil, datums...)
+	if err != nil {
+		return nil, err
+	}
+	return &CommonHandle{datums: datums, encoded: encoded}, nil
+}
+
+// DecodeCommonHandle decodes the encoded common handle in a row key. Note that
+// a Region boundary can carry only a prefix of the primary key columns.
+func DecodeCommonHandle(encoded []byte) (*CommonHandle, error) {
+	datums, err := codec.Decode(encoded, 2)
+	if err != nil {
+		return nil, err
+	}
+	return &CommonHandle{datums: datums, encoded: encoded}, nil
+}
+
+func (h *CommonHandle) Datums() []codec.Datum {
+	return h.datums
+}
+
+func (h *CommonHandle) Encoded() []byte {
+	return h.encoded
+}
+
+func (h *CommonHandle) NumCols() int {
+	return len(h.datums)
+}
+
+func (h *CommonHandle) String() string {
+	vals := make([]string, 0, len(h.datums))
+	for _, d := range h.datums {
+		vals = append(vals, d.String())
+	}
+	return "{" + strings.Join(vals, ", ") + "}"
+}
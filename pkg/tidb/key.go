@@ -16,10 +16,14 @@ const (
 	MaxInf TableRowStatus = 2
 )
 
+// TableRow is the decoded row key. The row is identified by RowID if the table
+// is keyed by int handle, or by CommonHandle if the table is clustered by common
+// handle.
 type TableRow struct {
-	TableID int64
-	RowID   int64
-	Status  TableRowStatus
+	TableID      int64
+	RowID        int64
+	CommonHandle *CommonHandle
+	Status       TableRowStatus
 }
 
 type TiKVKey struct {
@@ -35,6 +39,15 @@ func NewTableRowAsKey(tableID, rowID int64) TiKVKey {
 	return r.GetKey()
 }
 
+func NewTableRowWithCommonHandle(tableID int64, handle *CommonHandle) TableRow {
+	return TableRow{TableID: tableID, CommonHandle: handle}
+}
+
+func NewCommonHandleRowAsKey(tableID int64, handle *CommonHandle) TiKVKey {
+	r := NewTableRowWithCommonHandle(tableID, handle)
+	return r.GetKey()
+}
+
 func NewTableStartAsKey(tableID int64) TiKVKey {
 	r := TableRow{TableID: tableID, RowID: 0, Status: MinInf}
 	return r.GetKey()
@@ -53,18 +66,45 @@ func (r *TableRow) GetKey() TiKVKey {
 		key = codec.EncodeInt(key, r.TableID)
 		key = append(key, []byte("_r")...)
 		if r.Status != MinInf {
-			key = codec.EncodeInt(key, r.RowID)
+			if r.IsCommonHandle() {
+				key = append(key, r.CommonHandle.Encoded()...)
+			} else {
+				key = codec.EncodeInt(key, r.RowID)
+			}
 		}
 	}
 	key = codec.EncodeBytes([]byte{}, key)
 	return TiKVKey{key}
 }
 
+func (r *TableRow) IsCommonHandle() bool {
+	return r.CommonHandle != nil
+}
+
+func (r TableRow) String() string {
+	switch r.Status {
+	case MinInf:
+		return fmt.Sprintf("{table: %d, handle: -Inf}", r.TableID)
+	case MaxInf:
+		return fmt.Sprintf("{table: %d, handle: +Inf}", r.TableID)
+	}
+	if r.IsCommonHandle() {
+		return fmt.Sprintf("{table: %d, handle: %s}", r.TableID, r.CommonHandle.String())
+	}
+	return fmt.Sprintf("{table: %d, handle: %d}", r.TableID, r.RowID)
+}
+
 func FromPDKey(k string) (TiKVKey, error) {
 	b, err := hex.DecodeString(k)
 	return TiKVKey{key: b}, err
 }
 
+// FromRawKey builds the key from a raw TiDB key, which is not memcomparable
+// encoded as the keys in TiKV
+func FromRawKey(raw []byte) TiKVKey {
+	return TiKVKey{key: codec.EncodeBytes([]byte{}, raw)}
+}
+
 func (k *TiKVKey) GetBytes() []byte {
 	return k.key
 }
@@ -73,7 +113,26 @@ func (k *TiKVKey) GetPDKey() string {
 	return strings.ToUpper(hex.EncodeToString(k.key))
 }
 
+// GetRawKey returns the raw TiDB key, which is decoded from the memcomparable
+// encoded key
+func (k *TiKVKey) GetRawKey() ([]byte, error) {
+	_, b, err := codec.DecodeBytes(k.key, nil)
+	return b, err
+}
+
+// GetTableRow decodes the key as a row key. Like TiDB, the handle is regarded
+// as an int handle if its length is 8, otherwise as a common handle.
 func (k *TiKVKey) GetTableRow() (TableRow, error) {
+	return k.getTableRow(false)
+}
+
+// GetTableRowAsCommonHandle decodes the key as a row key of the table
+// clustered by common handle.
+func (k *TiKVKey) GetTableRowAsCommonHandle() (TableRow, error) {
+	return k.getTableRow(true)
+}
+
+func (k *TiKVKey) getTableRow(isCommonHandle bool) (TableRow, error) {
 	_, b, err := codec.DecodeBytes(k.key, nil)
 	if err != nil {
 		return TableRow{}, err
@@ -95,21 +154,97 @@ func (k *TiKVKey) GetTableRow() (TableRow, error) {
 			status = MinInf
 		}
 		return TableRow{TableID: tableID, RowID: rowID, Status: status}, nil
-	} else if len(b) == 1+8+2+8 {
+	} else if len(b) > 1+8+2 {
 		if !bytes.Equal(b[9:11], []byte("_r")) {
 			return TableRow{}, fmt.Errorf("invalid row prefix")
 		}
 		if _, tableID, err = codec.DecodeInt(b[1:]); err != nil {
 			return TableRow{}, err
 		}
-		if _, rowID, err = codec.DecodeInt(b[11:]); err != nil {
-			return TableRow{}, err
+		if !isCommonHandle && len(b) == 1+8+2+8 {
+			if _, rowID, err = codec.DecodeInt(b[11:]); err != nil {
+				return TableRow{}, err
+			}
+			return TableRow{TableID: tableID, RowID: rowID}, nil
+		}
+		handle, err := DecodeCommonHandle(b[11:])
+		if err != nil {
+			return TableRow{}, fmt.Errorf("invalid common handle, %s, %s", err, k.GetPDKey())
 		}
-		return TableRow{TableID: tableID, RowID: rowID}, nil
+		return TableRow{TableID: tableID, CommonHandle: handle}, nil
 	}
 	return TableRow{}, fmt.Errorf("size not fit, actual is %d, %s", len(b), k.GetPDKey())
 }
 
+// TableIndex is the decoded index key `t{table_id}_i{index_id}{values}`. For
+// the non-unique index, the handle of the row is appended to the values.
+type TableIndex struct {
+	TableID int64
+	IndexID int64
+	Values  []codec.Datum
+}
+
+func NewTableIndexAsKey(tableID, indexID int64, values ...codec.Datum) (TiKVKey, error) {
+	key := []byte{'t'}
+	key = codec.EncodeInt(key, tableID)
+	key = append(key, []byte("_i")...)
+	key = codec.EncodeInt(key, indexID)
+	key, err := codec.EncodeKey(key, values...)
+	if err != nil {
+		return TiKVKey{}, err
+	}
+	key = codec.EncodeBytes([]byte{}, key)
+	return TiKVKey{key}, nil
+}
+
+func (k *TiKVKey) hasPrefix(prefix string) bool {
+	_, b, err := codec.DecodeBytes(k.key, nil)
+	if err != nil {
+		return false
+	}
+	return len(b) >= 1+8+2 && b[0] == 't' && string(b[9:11]) == prefix
+}
+
+// IsRecordKey returns whether the key is in the record key space `t{table_id}_r`
+func (k *TiKVKey) IsRecordKey() bool {
+	return k.hasPrefix("_r")
+}
+
+// IsIndexKey returns whether the key is in the index key space `t{table_id}_i`
+func (k *TiKVKey) IsIndexKey() bool {
+	return k.hasPrefix("_i")
+}
+
+func (k *TiKVKey) GetTableIndex() (TableIndex, error) {
+	_, b, err := codec.DecodeBytes(k.key, nil)
+	if err != nil {
+		return TableIndex{}, err
+	}
+	if len(b) < 1+8+2+8 {
+		return TableIndex{}, fmt.Errorf("size not fit, actual is %d, %s", len(b), k.GetPDKey())
+	}
+	if !bytes.Equal(b[9:11], []byte("_i")) {
+		return TableIndex{}, fmt.Errorf("invalid index prefix")
+	}
+	var (
+		tableID int64
+		indexID int64
+		values  []codec.Datum
+	)
+	if _, tableID, err = codec.DecodeInt(b[1:]); err != nil {
+		return TableIndex{}, err
+	}
+	if _, indexID, err = codec.DecodeInt(b[11:]); err != nil {
+		return TableIndex{}, err
+	}
+	if len(b) > 1+8+2+8 {
+		if values, err = codec.Decode(b[19:], 2); err != nil {
+			return TableIndex{}, fmt.Errorf("invalid index values, %s, %s", err, k.GetPDKey())
+		}
+	}
+	return TableIndex{TableID: tableID, IndexID: indexID, Values: values}, nil
+}
+
 func (k *TiKVKey) GetTableID() (int64, error) {
 	_, b, err := codec.DecodeBytes(k.key, nil)
 	if err != nil {
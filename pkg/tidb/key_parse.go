@@ -0,0 +1,160 @@
+package tidb
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/codec"
+)
+
+// KeyFormat is the format of a key pasted from PD, TiKV or TiFlash
+type KeyFormat string
+
+const (
+	KeyFormatHex     KeyFormat = "hex"
+	KeyFormatEscaped KeyFormat = "escaped"
+	KeyFormatBase64  KeyFormat = "base64"
+)
+
+// ParseKey parses a key in hex (PD, TiKV logs), escaped string (tikv-ctl,
+// TiFlash logs, like `t\200\000...`) or base64 format. The key can be either
+// memcomparable encoded as the keys in TiKV or a raw TiDB key. The data prefix
+// 'z' of keys in the TiKV engine is ignored.
+func ParseKey(s string) (TiKVKey, KeyFormat, error) {
+	s = strings.TrimSpace(s)
+	s = strings.Trim(s, "\"'")
+	if s == "" {
+		return TiKVKey{}, "", fmt.Errorf("empty key")
+	}
+
+	var (
+		b      []byte
+		format KeyFormat
+		err    error
+	)
+	if b, err = hex.DecodeString(s); err == nil {
+		format = KeyFormatHex
+	} else if strings.Contains(s, `\`) || strings.HasPrefix(s, "t") || strings.HasPrefix(s, "zt") {
+		if b, err = unescapeKey(s); err != nil {
+			return TiKVKey{}, "", err
+		}
+		format = KeyFormatEscaped
+	} else if b, err = decodeBase64(s); err == nil {
+		format = KeyFormatBase64
+	} else {
+		return TiKVKey{}, "", fmt.Errorf("can not parse key %q as hex, escaped string or base64", s)
+	}
+
+	if len(b) > 1 && b[0] == 'z' && b[1] == 't' {
+		b = b[1:]
+	}
+	if len(b) == 0 || b[0] != 't' {
+		return TiKVKey{}, format, fmt.Errorf("not a TiDB table key, %s", EscapeKey(b))
+	}
+	// The key is memcomparable encoded if it can be fully decoded
+	if remain, _, err := codec.DecodeBytes(b, nil); err == nil && len(remain) == 0 {
+		return TiKVKey{key: b}, format, nil
+	}
+	if isMemcomparablePrefix(b) {
+		return TiKVKey{}, format, fmt.Errorf("truncated memcomparable key, the groups of 9 bytes are incomplete, %s", EscapeKey(b))
+	}
+	return FromRawKey(b), format, nil
+}
+
+// isMemcomparablePrefix returns whether the key starts with the first group of
+// a memcomparable encoded table key, that is `t\x80` followed by 6 bytes of
+// the table ID and the group marker 0xFF. The raw key of a table whose ID ends
+// with 0xFF has the same prefix, but it is followed by the separator `_r` or
+// `_i` rather than the last byte of the table ID.
+func isMemcomparablePrefix(b []byte) bool {
+	if len(b) < 9 || b[1] != 0x80 || b[8] != 0xFF {
+		return false
+	}
+	isRaw := len(b) == 9 || (b[9] == '_' && (len(b) == 10 || b[10] == 'r' || b[10] == 'i'))
+	return !isRaw
+}
+
+func decodeBase64(s string) ([]byte, error) {
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.URLEncoding, base64.RawStdEncoding, base64.RawURLEncoding} {
+		if b, err := enc.DecodeString(s); err == nil {
+			return b, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid base64 %q", s)
+}
+
+// unescapeKey unescapes the key escaped by EscapeKey, the hex escape `\xHH`
+// is also accepted.
+func unescapeKey(s string) ([]byte, error) {
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b = append(b, s[i])
+			continue
+		}
+		i++
+		if i >= len(s) {
+			return nil, fmt.Errorf("invalid escaped key, unexpected end after '\\'")
+		}
+		switch c := s[i]; {
+		case c == 't':
+			b = append(b, '\t')
+		case c == 'n':
+			b = append(b, '\n')
+		case c == 'r':
+			b = append(b, '\r')
+		case c == '\\' || c == '"' || c == '\'':
+			b = append(b, c)
+		case c == 'x':
+			if i+2 >= len(s) {
+				return nil, fmt.Errorf("invalid escaped key, incomplete hex escape at %d", i-1)
+			}
+			v, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+			if err != nil {
+				return nil, fmt.Errorf("invalid escaped key, %s", err)
+			}
+			b = append(b, byte(v))
+			i += 2
+		case c >= '0' && c <= '7':
+			if i+2 >= len(s) {
+				return nil, fmt.Errorf("invalid escaped key, incomplete octal escape at %d", i-1)
+			}
+			v, err := strconv.ParseUint(s[i:i+3], 8, 8)
+			if err != nil {
+				return nil, fmt.Errorf("invalid escaped key, %s", err)
+			}
+			b = append(b, byte(v))
+			i += 2
+		default:
+			return nil, fmt.Errorf("invalid escaped key, unknown escape '\\%c'", c)
+		}
+	}
+	return b, nil
+}
+
+// EscapeKey escapes the key in the same way as TiKV, the printable ASCII
+// chars are kept and the others are escaped as octal `\ooo`.
+func EscapeKey(b []byte) string {
+	var sb strings.Builder
+	for _, c := range b {
+		switch {
+		case c == '\t':
+			sb.WriteString(`\t`)
+		case c == '\n':
+			sb.WriteString(`\n`)
+		case c == '\r':
+			sb.WriteString(`\r`)
+		case c == '\\' || c == '"':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c >= 0x20 && c < 0x7f:
+			sb.WriteByte(c)
+		default:
+			fmt.Fprintf(&sb, "\\%03o", c)
+		}
+	}
+	return sb.String()
+}
@@ -1,8 +1,11 @@
 package tidb_test
 
 import (
+	"encoding/base64"
+	"encoding/hex"
 	"testing"
 
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/codec"
 	"github.com/JaySon-Huang/tiflash-ctl/pkg/tidb"
 	"github.com/stretchr/testify/assert"
 )
@@ -85,3 +88,166 @@ func TestEndKey(t *testing.T) {
 	newKey := tidb.NewTableEndAsKey(expectTableID)
 	assert.Equal(t, newKey.GetPDKey(), key.GetPDKey())
 }
+
+func TestCommonHandleKey(t *testing.T) {
+	// The row key of (1, 'abc') in a table clustered by `PRIMARY KEY (a, b)`
+	const (
+		pdCommonHandleKey string = "7480000000000000FF375F720380000000FF0000000101616263FF0000000000FA0000FD"
+		expectTableID     int64  = 55
+	)
+	// pd key -> kv key
+	key, err := tidb.FromPDKey(pdCommonHandleKey)
+	assert.Equal(t, err, nil)
+	// kv key -> tidb tableID, common handle
+	tableRow, err := key.GetTableRow()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, expectTableID, tableRow.TableID)
+	assert.True(t, tableRow.IsCommonHandle())
+	datums := tableRow.CommonHandle.Datums()
+	assert.Equal(t, 2, len(datums))
+	assert.Equal(t, codec.KindInt64, datums[0].Kind())
+	assert.Equal(t, int64(1), datums[0].GetInt64())
+	assert.Equal(t, codec.KindBytes, datums[1].Kind())
+	assert.Equal(t, []byte("abc"), datums[1].GetBytes())
+	// tidb tableID, common handle -> kv key
+	encKey := tableRow.GetKey()
+	assert.Equal(t, pdCommonHandleKey, encKey.GetPDKey())
+
+	handle, err := tidb.NewCommonHandle(codec.NewIntDatum(1), codec.NewBytesDatum([]byte("abc")))
+	assert.Equal(t, err, nil)
+	newKey := tidb.NewCommonHandleRowAsKey(expectTableID, handle)
+	assert.Equal(t, pdCommonHandleKey, newKey.GetPDKey())
+}
+
+func TestCommonHandleBoundary(t *testing.T) {
+	const expectTableID int64 = 55
+	// A boundary with the prefix of primary key columns, (1)
+	handle, err := tidb.NewCommonHandle(codec.NewIntDatum(1))
+	assert.Equal(t, err, nil)
+	key := tidb.NewCommonHandleRowAsKey(expectTableID, handle)
+	// The length of handle is 9 (flag + int64) rather than the 8 of an int
+	// handle, so GetTableRow decodes it as common handle without error
+	tableRow, err := key.GetTableRow()
+	assert.Equal(t, err, nil)
+	assert.True(t, tableRow.IsCommonHandle())
+	assert.Equal(t, 1, tableRow.CommonHandle.NumCols())
+	tableRow, err = key.GetTableRowAsCommonHandle()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 1, tableRow.CommonHandle.NumCols())
+
+	// A boundary splitting a datum in the middle is invalid
+	_, b, err := codec.DecodeBytes(key.GetBytes(), nil)
+	assert.Equal(t, err, nil)
+	midKey := tidb.FromRawKey(b[:len(b)-2])
+	_, err = midKey.GetTableRow()
+	assert.NotNil(t, err)
+
+	// An 8 bytes int handle is not a valid common handle, its first byte 0x83
+	// is not a datum flag. GetTableRow decodes it as int handle.
+	intKey := tidb.NewTableRowAsKey(expectTableID, 216172783141383189)
+	_, err = intKey.GetTableRowAsCommonHandle()
+	assert.NotNil(t, err)
+	tableRow, err = intKey.GetTableRow()
+	assert.Equal(t, err, nil)
+	assert.False(t, tableRow.IsCommonHandle())
+	assert.Equal(t, int64(216172783141383189), tableRow.RowID)
+}
+
+func TestIndexKey(t *testing.T) {
+	// The key of index 1 on table 59 with value 5, the int handle 10 is appended
+	const (
+		pdIndexKey    string = "7480000000000000FF3B5F698000000000FF0000010380000000FF0000000503800000FF000000000A000000FC"
+		expectTableID int64  = 59
+		expectIndexID int64  = 1
+	)
+	key, err := tidb.FromPDKey(pdIndexKey)
+	assert.Equal(t, err, nil)
+	assert.True(t, key.IsIndexKey())
+	assert.False(t, key.IsRecordKey())
+	index, err := key.GetTableIndex()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, expectTableID, index.TableID)
+	assert.Equal(t, expectIndexID, index.IndexID)
+	assert.Equal(t, 2, len(index.Values))
+	assert.Equal(t, int64(5), index.Values[0].GetInt64())
+	assert.Equal(t, int64(10), index.Values[1].GetInt64())
+	_, err = key.GetTableRow()
+	assert.NotNil(t, err)
+
+	newKey, err := tidb.NewTableIndexAsKey(expectTableID, expectIndexID, codec.NewIntDatum(5), codec.NewIntDatum(10))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, pdIndexKey, newKey.GetPDKey())
+
+	rowKey := tidb.NewTableRowAsKey(expectTableID, 10)
+	assert.True(t, rowKey.IsRecordKey())
+	_, err = rowKey.GetTableIndex()
+	assert.NotNil(t, err)
+}
+
+func TestDatetimeIndexKeyFromTiDB(t *testing.T) {
+	// The seek key of index 2 on table 45 with DATETIME(6) value
+	// '2021-01-02 03:04:05.123456', encoded by `tablecodec` of TiDB
+	const rawIndexKey = "74800000000000002D5F6980000000000000020419A884310501E240"
+	b, err := hex.DecodeString(rawIndexKey)
+	assert.Equal(t, err, nil)
+	key := tidb.FromRawKey(b)
+	assert.True(t, key.IsIndexKey())
+	index, err := key.GetTableIndex()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, int64(45), index.TableID)
+	assert.Equal(t, int64(2), index.IndexID)
+	assert.Equal(t, 1, len(index.Values))
+	tm := codec.FromPackedUint(index.Values[0].GetUint64())
+	assert.Equal(t, "2021-01-02 03:04:05.123456", tm.DatetimeString(6))
+
+	newKey, err := tidb.NewTableIndexAsKey(45, 2, codec.NewUintDatum(tm.ToPackedUint()))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, key.GetBytes(), newKey.GetBytes())
+}
+
+func TestParseKey(t *testing.T) {
+	const pdKey = "7480000000000000FF375F72830000003DFF3FEC150000000000FA"
+	expected, err := tidb.FromPDKey(pdKey)
+	assert.Equal(t, err, nil)
+	raw, err := expected.GetRawKey()
+	assert.Equal(t, err, nil)
+	escaped := tidb.EscapeKey(expected.GetBytes())
+	assert.Equal(t, `t\200\000\000\000\000\000\000\3777_r\203\000\000\000=\377?\354\025\000\000\000\000\000\372`, escaped)
+
+	cases := []struct {
+		input  string
+		format tidb.KeyFormat
+	}{
+		{pdKey, tidb.KeyFormatHex},
+		{"7480000000000000375f72830000003d3fec15", tidb.KeyFormatHex},
+		{escaped, tidb.KeyFormatEscaped},
+		{"z" + escaped, tidb.KeyFormatEscaped},
+		{`"` + escaped + `"`, tidb.KeyFormatEscaped},
+		{base64.StdEncoding.EncodeToString(expected.GetBytes()), tidb.KeyFormatBase64},
+		{base64.StdEncoding.EncodeToString(raw), tidb.KeyFormatBase64},
+	}
+	for _, c := range cases {
+		key, format, err := tidb.ParseKey(c.input)
+		assert.Equal(t, err, nil, c.input)
+		assert.Equal(t, c.format, format, c.input)
+		assert.Equal(t, pdKey, key.GetPDKey(), c.input)
+	}
+
+	_, _, err = tidb.ParseKey("not a key")
+	assert.NotNil(t, err)
+	_, _, err = tidb.ParseKey(`t\200\00`)
+	assert.NotNil(t, err)
+
+	// A PD key cut off in the middle of a group is not decoded as a raw key
+	_, _, err = tidb.ParseKey("7480000000000000FF375F7203800000")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "truncated memcomparable key")
+	// The raw key of table 255 has the same prefix as the memcomparable keys
+	key, format, err := tidb.ParseKey("7480000000000000FF5F728000000000000001")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, tidb.KeyFormatHex, format)
+	row, err := key.GetTableRow()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, int64(255), row.TableID)
+	assert.Equal(t, int64(1), row.RowID)
+}
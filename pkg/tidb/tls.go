@@ -0,0 +1,87 @@
+package tidb
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// The name of TLS config registered to the MySQL driver
+const tlsConfigName = "tiflash-ctl"
+
+// TLSOpts is the certificates for connecting to the TLS-enabled cluster
+type TLSOpts struct {
+	// The path of CA certificate
+	CA string
+	// The path of client certificate and key
+	Cert string
+	Key  string
+}
+
+func (o TLSOpts) IsEnabled() bool {
+	return o.CA != "" || o.Cert != "" || o.Key != ""
+}
+
+// ToTLSConfig returns the TLS config with the certificates, it returns nil if
+// TLS is not enabled
+func (o TLSOpts) ToTLSConfig() (*tls.Config, error) {
+	if !o.IsEnabled() {
+		return nil, nil
+	}
+	if (o.Cert == "") != (o.Key == "") {
+		return nil, fmt.Errorf("should set both the cert and key for TLS")
+	}
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if o.CA != "" {
+		ca, err := os.ReadFile(o.CA)
+		if err != nil {
+			return nil, fmt.Errorf("read CA file %s fail: %s", o.CA, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("can not parse CA file %s", o.CA)
+		}
+		config.RootCAs = pool
+	}
+	if o.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(o.Cert, o.Key)
+		if err != nil {
+			return nil, fmt.Errorf("load cert %s and key %s fail: %s", o.Cert, o.Key, err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// NewHTTPClient returns the client and the url scheme for requesting the
+// components in the cluster, it uses https with the certificates if TLS is
+// enabled
+func (o TLSOpts) NewHTTPClient() (*http.Client, string, error) {
+	config, err := o.ToTLSConfig()
+	if err != nil {
+		return nil, "", err
+	}
+	if config == nil {
+		return &http.Client{}, "http", nil
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	return &http.Client{Transport: transport}, "https", nil
+}
+
+// registerTLSConfig registers the TLS config to the MySQL driver, it returns
+// the params to add to the dsn
+func registerTLSConfig(o TLSOpts) (string, error) {
+	config, err := o.ToTLSConfig()
+	if err != nil || config == nil {
+		return "", err
+	}
+	if err = mysql.RegisterTLSConfig(tlsConfigName, config); err != nil {
+		return "", err
+	}
+	return "&tls=" + tlsConfigName, nil
+}
@@ -0,0 +1,64 @@
+package tidb_test
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/tidb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTLSOpts(t *testing.T) {
+	var opts tidb.TLSOpts
+	config, err := opts.ToTLSConfig()
+	assert.Equal(t, err, nil)
+	assert.Nil(t, config)
+	_, scheme, err := opts.NewHTTPClient()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, "http", scheme)
+
+	// Should set both cert and key
+	_, err = tidb.TLSOpts{Cert: "client.pem"}.ToTLSConfig()
+	assert.NotEqual(t, err, nil)
+	_, err = tidb.TLSOpts{CA: filepath.Join(t.TempDir(), "not-exist.pem")}.ToTLSConfig()
+	assert.NotEqual(t, err, nil)
+}
+
+func TestGetSQLTLS(t *testing.T) {
+	cluster := tidb.TLSOpts{CA: "ca.pem", Cert: "client.pem", Key: "client-key.pem"}
+	// Use the certificates of the cluster by default
+	opts := tidb.TiDBClientOpts{TLS: cluster}
+	assert.Equal(t, cluster, opts.GetSQLTLS())
+	// Override by the certificates for TiDB
+	opts.SQLTLS = tidb.TLSOpts{CA: "tidb-ca.pem"}
+	assert.Equal(t, tidb.TLSOpts{CA: "tidb-ca.pem"}, opts.GetSQLTLS())
+	// Not encrypted if none of them is set
+	assert.False(t, tidb.TiDBClientOpts{}.GetSQLTLS().IsEnabled())
+}
+
+func TestTLSHTTPClient(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	pemData := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	assert.Equal(t, os.WriteFile(ca, pemData, 0644), nil)
+
+	client, scheme, err := tidb.TLSOpts{CA: ca}.NewHTTPClient()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, "https", scheme)
+	resp, err := client.Get(server.URL)
+	assert.Equal(t, err, nil)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Can not verify the server without the CA
+	_, err = http.Get(server.URL)
+	assert.NotEqual(t, err, nil)
+}
@@ -0,0 +1,58 @@
+package tiflash
+
+import "fmt"
+
+// The sources of TiFlash HTTP port
+const (
+	PortFromOverride   = "override"
+	PortFromFlag       = "flag"
+	PortFromClusterCfg = "cluster_config"
+	PortFromDefault    = "default"
+)
+
+// DefaultHTTPPort is the default HTTP port of TiFlash, it is used if the port
+// can not be found in any way
+const DefaultHTTPPort = 8123
+
+// PortOpts is the HTTP ports of the TiFlash instances set by flags
+type PortOpts struct {
+	// The HTTP port for all the instances, find the port of each instance if it is 0
+	HTTPPort int
+	// The HTTP port of the instances by the store address
+	HTTPPorts map[string]int
+}
+
+// HTTPPort is the HTTP port of a TiFlash instance and where it is found
+type HTTPPort struct {
+	Port   int
+	Source string
+}
+
+// ResolveHTTPPorts returns the HTTP ports of the TiFlash instances by their
+// store addresses. The port of each instance is resolved in the order of the
+// per-instance override, the port for all instances, the port in
+// `information_schema.cluster_config`, then the default port. Note that the
+// port of status address in PD is the status port of the proxy, it is not
+// the HTTP port.
+func ResolveHTTPPorts(opts PortOpts, addrs []string, configPorts map[string]int) ([]HTTPPort, error) {
+	found := make(map[string]bool)
+	ports := make([]HTTPPort, 0, len(addrs))
+	for _, addr := range addrs {
+		if port, ok := opts.HTTPPorts[addr]; ok {
+			ports = append(ports, HTTPPort{Port: port, Source: PortFromOverride})
+			found[addr] = true
+		} else if opts.HTTPPort != 0 {
+			ports = append(ports, HTTPPort{Port: opts.HTTPPort, Source: PortFromFlag})
+		} else if port := configPorts[addr]; port > 0 {
+			ports = append(ports, HTTPPort{Port: port, Source: PortFromClusterCfg})
+		} else {
+			ports = append(ports, HTTPPort{Port: DefaultHTTPPort, Source: PortFromDefault})
+		}
+	}
+	for addr := range opts.HTTPPorts {
+		if !found[addr] {
+			return nil, fmt.Errorf("can not find TiFlash store with address %s in --tiflash_http_ports", addr)
+		}
+	}
+	return ports, nil
+}
@@ -0,0 +1,55 @@
+package tiflash_test
+
+import (
+	"testing"
+
+	"github.com/JaySon-Huang/tiflash-ctl/pkg/tiflash"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveHTTPPorts(t *testing.T) {
+	addrs := []string{"10.0.0.1:3930", "10.0.0.2:3930"}
+	configPorts := map[string]int{"10.0.0.1:3930": 8125, "10.0.0.2:3930": 8126}
+	for _, c := range []struct {
+		name        string
+		opts        tiflash.PortOpts
+		configPorts map[string]int
+		ports       []tiflash.HTTPPort
+	}{
+		{
+			name:        "override > flag > cluster_config",
+			opts:        tiflash.PortOpts{HTTPPort: 8124, HTTPPorts: map[string]int{"10.0.0.1:3930": 9000}},
+			configPorts: configPorts,
+			ports:       []tiflash.HTTPPort{{9000, tiflash.PortFromOverride}, {8124, tiflash.PortFromFlag}},
+		},
+		{
+			name:        "override > cluster_config",
+			opts:        tiflash.PortOpts{HTTPPorts: map[string]int{"10.0.0.2:3930": 9000}},
+			configPorts: configPorts,
+			ports:       []tiflash.HTTPPort{{8125, tiflash.PortFromClusterCfg}, {9000, tiflash.PortFromOverride}},
+		},
+		{
+			name:        "flag > cluster_config",
+			opts:        tiflash.PortOpts{HTTPPort: 8124},
+			configPorts: configPorts,
+			ports:       []tiflash.HTTPPort{{8124, tiflash.PortFromFlag}, {8124, tiflash.PortFromFlag}},
+		},
+		{
+			name:        "cluster_config > default",
+			configPorts: map[string]int{"10.0.0.2:3930": 8126},
+			ports:       []tiflash.HTTPPort{{tiflash.DefaultHTTPPort, tiflash.PortFromDefault}, {8126, tiflash.PortFromClusterCfg}},
+		},
+		{
+			name:  "default",
+			ports: []tiflash.HTTPPort{{8123, tiflash.PortFromDefault}, {8123, tiflash.PortFromDefault}},
+		},
+	} {
+		ports, err := tiflash.ResolveHTTPPorts(c.opts, addrs, c.configPorts)
+		assert.Equal(t, err, nil, c.name)
+		assert.Equal(t, c.ports, ports, c.name)
+	}
+
+	// The override address is not a TiFlash instance
+	_, err := tiflash.ResolveHTTPPorts(tiflash.PortOpts{HTTPPorts: map[string]int{"10.0.0.3:3930": 9000}}, addrs, configPorts)
+	assert.NotNil(t, err)
+}